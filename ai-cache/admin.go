@@ -0,0 +1,595 @@
+// 这个文件中实现缓存管理接口
+// 开启 admin.path 后，以该路径开头的请求由插件直接响应，不会转发到上游，请求需要携带 Authorization: Bearer <admin.token>
+//   - GET    <path>/lookup?query=<问题>[&topk=<n>]  查询问题精确匹配的条目，以及语义检索的候选向量和距离
+//   - GET    <path>/stats                           返回插件的统计指标
+//   - DELETE <path>/entries?key=<redis key>         删除一个条目及其向量，也可以用 id=<向量 ID> 或 query=<问题> 指定条目
+//   - POST   <path>/purge                           按 redis key 前缀（prefix）、命名空间（namespace）或标签（tag）清空条目及其向量
+//   - POST   <path>/entries                         写入人工整理的回答（query、content、model、tags），条目和向量都写入后才返回
+//
+// 除 key 和 prefix 外，条目的范围由 keyPrefix、namespace 和 tenant 参数指定，默认为全局配置的前缀和命名空间；
+// 开启多租户隔离时非共享的命名空间需要指定 tenant，即租户标识的原文。按命名空间清空时不指定 tenant 表示清空所有租户
+//
+// 删除时先删除缓存存储中的条目再按 ID 删除向量，向量删除失败时留下的孤儿向量会在语义检索命中时或由定期清理删除；
+// 写入时先写条目再写向量，向量写入失败时删除条目，保证条目和向量同时存在
+// 删除和清空只能删除当前 VM 的 L1 中的条目，没有开启 l1Cache.sharedData 时，其他 VM 的 L1 中的条目在 l1Cache.ttl 后才会过期
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alibaba/higress/plugins/wasm-go/extensions/ai-cache/config"
+	"github.com/alibaba/higress/plugins/wasm-go/extensions/ai-cache/protocol"
+	vectorStoreProvider "github.com/alibaba/higress/plugins/wasm-go/extensions/ai-cache/vectorStoreProvider"
+	"github.com/alibaba/higress/plugins/wasm-go/pkg/wrapper"
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm"
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm/types"
+	"github.com/tidwall/gjson"
+)
+
+const (
+	adminOpLookup  = "/lookup"
+	adminOpStats   = "/stats"
+	adminOpEntries = "/entries"
+	adminOpPurge   = "/purge"
+
+	// 语义检索默认返回的候选数和上限
+	defaultAdminTopK = 5
+	maxAdminTopK     = 100
+	// 每次请求向量数据库删除的向量数
+	adminVectorDeleteBatchSize = 100
+)
+
+// adminParams 读取请求参数，GET 和 DELETE 请求的参数在查询字符串中，POST 请求的参数在 JSON Body 中
+type adminParams func(name string) string
+
+type adminEntry struct {
+	Query     string   `json:"query"`
+	Content   string   `json:"content"`
+	Model     string   `json:"model,omitempty"`
+	Tags      []string `json:"tags,omitempty"`
+	VectorID  string   `json:"vector_id,omitempty"`
+	CreatedAt int64    `json:"created_at"`
+	HitCount  int64    `json:"hit_count"`
+	LastHitAt int64    `json:"last_hit_at,omitempty"`
+	Stale     bool     `json:"stale"`
+	Expired   bool     `json:"expired"`
+}
+
+// adminCandidate 是语义检索的一个候选，similar 表示距离小于阈值并且摘要后缀相同，即请求会命中该候选
+type adminCandidate struct {
+	ID       string  `json:"id"`
+	Query    string  `json:"query"`
+	Score    float64 `json:"score"`
+	Similar  bool    `json:"similar"`
+	RedisKey string  `json:"redis_key"`
+}
+
+type adminLookupResult struct {
+	RedisKey        string           `json:"redis_key"`
+	Exact           *adminEntry      `json:"exact"`
+	Candidates      []adminCandidate `json:"candidates"`
+	CandidatesError string           `json:"candidates_error,omitempty"`
+}
+
+type adminDeleteResult struct {
+	Entries int    `json:"entries"`
+	Keys    int    `json:"keys,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+type adminStoreResult struct {
+	RedisKey string `json:"redis_key"`
+	ID       string `json:"id"`
+}
+
+// handleAdminRequestHeaders 处理管理接口的请求头，没有请求 Body 的接口在这里直接响应
+func handleAdminRequestHeaders(ctx wrapper.HttpContext, c config.PluginConfig, log wrapper.Log) types.Action {
+	incrementCounter(metricAdminRequests, 1)
+	path, rawQuery := ctx.Path(), ""
+	if i := strings.Index(path, "?"); i >= 0 {
+		path, rawQuery = path[:i], path[i+1:]
+	}
+	op := strings.TrimPrefix(path, c.AdminConfig.Path)
+	method := ctx.Method()
+	if !adminAuthorized(c) {
+		log.Warnf("unauthorized admin request, method:%s, path:%s", method, path)
+		ctx.DontReadRequestBody()
+		sendAdminError(401, "unauthorized")
+		return types.ActionPause
+	}
+	if method == "POST" && (op == adminOpPurge || op == adminOpEntries) {
+		contentType, _ := proxywasm.GetHttpRequestHeader("content-type")
+		if !strings.Contains(contentType, "application/json") {
+			ctx.DontReadRequestBody()
+			sendAdminError(415, "content-type must be application/json")
+			return types.ActionPause
+		}
+		ctx.SetContext(AdminContextKey, op)
+		return types.HeaderStopIteration
+	}
+	ctx.DontReadRequestBody()
+	values, _ := url.ParseQuery(rawQuery)
+	params := adminParams(values.Get)
+	switch {
+	case method == "GET" && op == adminOpLookup:
+		adminLookup(c, params, log)
+	case method == "GET" && op == adminOpStats:
+		adminStats()
+	case method == "DELETE" && op == adminOpEntries:
+		adminDeleteEntry(c, params, log)
+	default:
+		sendAdminError(404, "unknown admin operation: "+method+" "+op)
+	}
+	return types.ActionPause
+}
+
+// handleAdminRequestBody 处理带有 JSON Body 的管理接口
+func handleAdminRequestBody(c config.PluginConfig, op string, body []byte, log wrapper.Log) {
+	if !gjson.ValidBytes(body) {
+		sendAdminError(400, "request body is not valid json")
+		return
+	}
+	bodyJson := gjson.ParseBytes(body)
+	params := adminParams(func(name string) string {
+		return bodyJson.Get(name).String()
+	})
+	switch op {
+	case adminOpPurge:
+		adminPurge(c, params, log)
+	case adminOpEntries:
+		adminStoreEntry(c, params, bodyJson.Get("tags"), log)
+	}
+}
+
+func adminAuthorized(c config.PluginConfig) bool {
+	value, _ := proxywasm.GetHttpRequestHeader("authorization")
+	return subtle.ConstantTimeCompare([]byte(bearerToken(value)), []byte(c.AdminConfig.Token)) == 1
+}
+
+// adminScope 返回参数指定范围的配置，requireTenant 为 false 时未指定 tenant 表示命名空间中所有租户的范围
+func adminScope(c config.PluginConfig, params adminParams, requireTenant bool) (config.PluginConfig, error) {
+	if prefix := params("keyPrefix"); prefix != "" {
+		if !isConfiguredKeyPrefix(c, prefix) {
+			return c, errors.New("keyPrefix is not configured: " + prefix)
+		}
+		c.CacheKeyPrefix = prefix
+	}
+	if namespace := params("namespace"); namespace != "" {
+		if strings.ContainsAny(namespace, ":'") {
+			return c, errors.New("namespace must not contain ':' or '''")
+		}
+		c.CacheKeyNamespace = namespace
+	}
+	if !c.TenantConfig.Enabled() || c.TenantConfig.IsShared(c.CacheKeyNamespace) {
+		return c, nil
+	}
+	tenant := params("tenant")
+	if tenant == "" {
+		if requireTenant {
+			return c, errors.New("tenant is required in namespace " + c.CacheKeyNamespace)
+		}
+		return c, nil
+	}
+	return c.WithTenant(tenantDigest(tenant)), nil
+}
+
+// isConfiguredKeyPrefix 只允许全局配置和规则中的前缀，管理接口不能操作其他应用的 key
+func isConfiguredKeyPrefix(c config.PluginConfig, prefix string) bool {
+	if prefix == c.CacheKeyPrefix {
+		return true
+	}
+	for _, rule := range c.Rules {
+		if rule.CacheKeyPrefix == prefix {
+			return true
+		}
+	}
+	return false
+}
+
+func hasConfiguredKeyPrefix(c config.PluginConfig, key string) bool {
+	if i := strings.Index(key, ":"); i > 0 {
+		return isConfiguredKeyPrefix(c, key[:i])
+	}
+	return false
+}
+
+func adminLookup(c config.PluginConfig, params adminParams, log wrapper.Log) {
+	c, err := adminScope(c, params, true)
+	if err != nil {
+		sendAdminError(400, err.Error())
+		return
+	}
+	text := c.GetNormalizer().Normalize(params("query"))
+	if text == "" {
+		sendAdminError(400, "query must not be empty")
+		return
+	}
+	key := buildCacheKey(text, "")
+	topK := defaultAdminTopK
+	if value := params("topk"); value != "" {
+		topK, err = strconv.Atoi(value)
+		if err != nil || topK < 1 || topK > maxAdminTopK {
+			sendAdminError(400, "topk must be between 1 and "+strconv.Itoa(maxAdminTopK))
+			return
+		}
+	}
+	result := &adminLookupResult{RedisKey: answerRedisKey(c, key)}
+	// the entry is read from the cache store directly, the lookup does not count as a hit
+	err = c.GetCacheStore().GetFields(result.RedisKey, func(fields map[string]string, err error) {
+		if err != nil {
+			sendAdminError(502, "cache store get failed: "+err.Error())
+			return
+		}
+		if entry := parseCacheEntry(fields); entry != nil {
+			result.Exact = newAdminEntry(c, entry)
+		}
+		lookupAdminCandidates(c, key, topK, log, func(candidates []adminCandidate, err error) {
+			if err != nil {
+				result.CandidatesError = err.Error()
+			}
+			result.Candidates = candidates
+			sendAdminJSON(200, result)
+		})
+	})
+	if err != nil {
+		sendAdminError(502, "cache store get failed: "+err.Error())
+	}
+}
+
+func newAdminEntry(c config.PluginConfig, entry *cacheEntry) *adminEntry {
+	return &adminEntry{
+		Query:     entry.Query,
+		Content:   entry.Answer.Content,
+		Model:     entry.Model,
+		Tags:      entry.Tags,
+		VectorID:  entry.VectorID,
+		CreatedAt: entry.CreatedAt,
+		HitCount:  entry.HitCount,
+		LastHitAt: entry.LastHitAt,
+		Stale:     isEntryStale(c, entry),
+		Expired:   isEntryExpired(c, entry),
+	}
+}
+
+// lookupAdminCandidates 与语义检索使用相同的向量化服务和范围过滤条件
+func lookupAdminCandidates(c config.PluginConfig, key string, topK int, log wrapper.Log, callback func(candidates []adminCandidate, err error)) {
+	querier, ok := c.GetVectorProvider().(vectorStoreProvider.QueryEmbedding)
+	if !ok {
+		callback(nil, errors.New("the vector store provider does not support querying"))
+		return
+	}
+	queryText, keySuffix := splitKeySuffix(key)
+	err := fetchQueryEmbedding(c, log, queryText, func(text_embedding []float64, err error) {
+		if err != nil {
+			callback(nil, err)
+			return
+		}
+		recordVectorDimension(len(text_embedding))
+		err = querier.QueryEmbedding(vectorStoreProvider.QueryRequest{
+			Vector: text_embedding,
+			TopK:   topK,
+			Filter: vectorScopeFilter(c),
+		}, func(resp vectorStoreProvider.QueryResponse, err error) {
+			if err != nil {
+				callback(nil, err)
+				return
+			}
+			candidates := make([]adminCandidate, 0, len(resp.Output))
+			for _, result := range resp.Output {
+				similarKey, _ := result.Fields["query"].(string)
+				similarText, similarKeySuffix := splitKeySuffix(similarKey)
+				candidates = append(candidates, adminCandidate{
+					ID:       result.ID,
+					Query:    similarText,
+					Score:    result.Score,
+					Similar:  result.Score < c.SimilarityThreshold && similarKeySuffix == keySuffix,
+					RedisKey: answerRedisKey(c, similarKey),
+				})
+			}
+			callback(candidates, nil)
+		})
+		if err != nil {
+			callback(nil, err)
+		}
+	})
+	if err != nil {
+		callback(nil, err)
+	}
+}
+
+// adminStats 返回所有指标的当前值，指标由宿主按名称汇总，包含所有 worker 的计数
+func adminStats() {
+	counters := make(map[string]uint64, len(statsMetrics))
+	for _, name := range statsMetrics {
+		counters[name] = counterValue(name)
+	}
+	sendAdminJSON(200, map[string]interface{}{"counters": counters})
+}
+
+func adminDeleteEntry(c config.PluginConfig, params adminParams, log wrapper.Log) {
+	redisKey := params("key")
+	if redisKey != "" {
+		if !hasConfiguredKeyPrefix(c, redisKey) || answerKeyVectorID(redisKey) == "" {
+			sendAdminError(400, "key is not a cached answer of this plugin: "+redisKey)
+			return
+		}
+	} else {
+		scoped, err := adminScope(c, params, true)
+		if err != nil {
+			sendAdminError(400, err.Error())
+			return
+		}
+		if id := params("id"); id != "" {
+			if !isHexDigest(id) {
+				sendAdminError(400, "id is not a valid vector id: "+id)
+				return
+			}
+			redisKey = cacheKeyScope(scoped) + ":" + answerKeyKind + ":" + id
+		} else if text := scoped.GetNormalizer().Normalize(params("query")); text != "" {
+			redisKey = answerRedisKey(scoped, buildCacheKey(text, ""))
+		} else {
+			sendAdminError(400, "one of key, id and query is required")
+			return
+		}
+	}
+	deleteAdminEntries(c, []string{redisKey}, log, func(deleted int, err error) {
+		sendAdminDeleteResult(adminDeleteResult{Entries: deleted}, err)
+	})
+}
+
+func adminPurge(c config.PluginConfig, params adminParams, log wrapper.Log) {
+	if prefix := params("prefix"); prefix != "" {
+		if !hasConfiguredKeyPrefix(c, prefix) {
+			sendAdminError(400, "prefix must start with a configured cacheKeyPrefix followed by ':'")
+			return
+		}
+		purgeByPrefix(c, prefix, log)
+		return
+	}
+	if params("namespace") == "" && params("tag") == "" {
+		sendAdminError(400, "one of prefix, namespace and tag is required")
+		return
+	}
+	tag := params("tag")
+	scoped, err := adminScope(c, params, tag != "")
+	if err != nil {
+		sendAdminError(400, err.Error())
+		return
+	}
+	if tag != "" {
+		purgeByTag(scoped, tag, log)
+		return
+	}
+	// the trailing separator keeps namespaces sharing a common prefix apart
+	purgeByPrefix(scoped, cacheKeyScope(scoped)+":", log)
+}
+
+// purgeByPrefix 删除以前缀开头的所有 key，其中回答的 key 的最后一段即为向量 ID，再按 ID 删除向量
+// 缓存存储中途失败时仍然删除已删除的条目的向量，再返回错误
+func purgeByPrefix(c config.PluginConfig, prefix string, log wrapper.Log) {
+	err := c.GetCacheStore().DeleteByPrefix(prefix, func(keys []string, err error) {
+		if err != nil {
+			log.Warnf("admin purge by prefix failed after %d keys, prefix:%s, err:%v", len(keys), prefix, err)
+			err = errors.New("cache store delete by prefix failed: " + err.Error())
+		}
+		var ids []string
+		l1Cache := c.GetL1Cache()
+		for _, key := range keys {
+			if l1Cache != nil {
+				l1Cache.Delete(key)
+			}
+			if id := answerKeyVectorID(key); id != "" {
+				ids = append(ids, id)
+			}
+		}
+		log.Infof("admin purged %d keys with %d entries, prefix:%s", len(keys), len(ids), prefix)
+		incrementCounter(metricAdminDeletedEntries, uint64(len(ids)))
+		deleteVectors(c, ids, func(vectorErr error) {
+			if err == nil {
+				err = vectorErr
+			}
+			sendAdminDeleteResult(adminDeleteResult{Entries: len(ids), Keys: len(keys)}, err)
+		})
+	})
+	if err != nil {
+		sendAdminError(502, "cache store delete by prefix failed: "+err.Error())
+	}
+}
+
+// purgeByTag 删除标签索引中的所有条目及其向量，全部删除后再删除索引
+func purgeByTag(c config.PluginConfig, tag string, log wrapper.Log) {
+	tagKey := tagRedisKey(c, tag)
+	err := c.GetCacheStore().GetFields(tagKey, func(fields map[string]string, err error) {
+		if err != nil {
+			sendAdminError(502, "cache store get tag index failed: "+err.Error())
+			return
+		}
+		redisKeys := make([]string, 0, len(fields))
+		for redisKey := range fields {
+			redisKeys = append(redisKeys, redisKey)
+		}
+		log.Infof("admin purge %d entries with tag:%s", len(redisKeys), tag)
+		deleteAdminEntries(c, redisKeys, log, func(deleted int, err error) {
+			if err == nil {
+				c.GetCacheStore().Delete(tagKey, nil)
+			}
+			sendAdminDeleteResult(adminDeleteResult{Entries: deleted}, err)
+		})
+	})
+	if err != nil {
+		sendAdminError(502, "cache store get tag index failed: "+err.Error())
+	}
+}
+
+// deleteAdminEntries 删除条目后再删除已删除条目的向量，删除条目失败时保留其向量，回调中返回删除的条目数和第一个错误
+func deleteAdminEntries(c config.PluginConfig, redisKeys []string, log wrapper.Log, callback func(deleted int, err error)) {
+	if len(redisKeys) == 0 {
+		callback(0, nil)
+		return
+	}
+	var ids []string
+	var firstErr error
+	pending := len(redisKeys)
+	l1Cache := c.GetL1Cache()
+	for _, redisKey := range redisKeys {
+		redisKey := redisKey
+		done := func(err error) {
+			if err != nil {
+				log.Warnf("admin delete entry failed, key:%s, err:%v", redisKey, err)
+				if firstErr == nil {
+					firstErr = err
+				}
+			} else if id := answerKeyVectorID(redisKey); id != "" {
+				ids = append(ids, id)
+			}
+			pending--
+			if pending > 0 {
+				return
+			}
+			incrementCounter(metricAdminDeletedEntries, uint64(len(ids)))
+			deleteVectors(c, ids, func(err error) {
+				if firstErr == nil {
+					firstErr = err
+				}
+				callback(len(ids), firstErr)
+			})
+		}
+		if l1Cache != nil {
+			l1Cache.Delete(redisKey)
+		}
+		if err := c.GetCacheStore().Delete(redisKey, done); err != nil {
+			done(err)
+		}
+	}
+}
+
+// deleteVectors 分批删除向量，回调中返回第一个错误
+func deleteVectors(c config.PluginConfig, ids []string, callback func(err error)) {
+	if len(ids) == 0 {
+		callback(nil)
+		return
+	}
+	deleter, ok := c.GetVectorProvider().(vectorStoreProvider.DeleteEmbedding)
+	if !ok {
+		callback(errors.New("the vector store provider does not support deleting"))
+		return
+	}
+	var firstErr error
+	pending := (len(ids) + adminVectorDeleteBatchSize - 1) / adminVectorDeleteBatchSize
+	done := func(err error) {
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+		pending--
+		if pending == 0 {
+			callback(firstErr)
+		}
+	}
+	for start := 0; start < len(ids); start += adminVectorDeleteBatchSize {
+		end := start + adminVectorDeleteBatchSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+		if err := deleter.DeleteEmbedding(ids[start:end], done); err != nil {
+			done(err)
+		}
+	}
+}
+
+// adminStoreEntry 写入人工整理的回答，使用范围内配置的过期时间
+func adminStoreEntry(c config.PluginConfig, params adminParams, tags gjson.Result, log wrapper.Log) {
+	c, err := adminScope(c, params, true)
+	if err != nil {
+		sendAdminError(400, err.Error())
+		return
+	}
+	query := params("query")
+	text := c.GetNormalizer().Normalize(query)
+	content := params("content")
+	if text == "" || content == "" {
+		sendAdminError(400, "query and content must not be empty")
+		return
+	}
+	key := buildCacheKey(text, "")
+	entry := &cacheEntry{
+		Answer:        &protocol.Answer{Content: content},
+		Model:         params("model"),
+		CreatedAt:     time.Now().Unix(),
+		Query:         query,
+		VectorID:      vectorID(key),
+		SchemaVersion: CacheEntrySchemaVersion,
+		Tags:          adminTags(tags),
+	}
+	redisKey := answerRedisKey(c, key)
+	writeCacheEntry(c, redisKey, entry, log, func(err error) {
+		if err != nil {
+			sendAdminError(502, "write cache entry failed: "+err.Error())
+			return
+		}
+		storeAdminEntryVector(c, key, redisKey, log)
+	})
+}
+
+// storeAdminEntryVector 条目写入后向量化问题并写入向量，任一步失败时删除条目
+func storeAdminEntryVector(c config.PluginConfig, key, redisKey string, log wrapper.Log) {
+	queryText, _ := splitKeySuffix(key)
+	err := fetchQueryEmbedding(c, log, queryText, func(text_embedding []float64, err error) {
+		if err != nil {
+			deleteCacheEntry(c, redisKey)
+			sendAdminError(502, "fetch embedding failed: "+err.Error())
+			return
+		}
+		recordVectorDimension(len(text_embedding))
+		// a failed insert deletes the entry as well
+		uploadQueryEmbedding(c, log, key, text_embedding, func(err error) {
+			if err != nil {
+				sendAdminError(502, "insert vector failed: "+err.Error())
+				return
+			}
+			log.Infof("admin stored cache entry, key:%s", redisKey)
+			incrementCounter(metricAdminStoredEntries, 1)
+			sendAdminJSON(200, adminStoreResult{RedisKey: redisKey, ID: vectorID(key)})
+		})
+	})
+	if err != nil {
+		deleteCacheEntry(c, redisKey)
+		sendAdminError(502, "fetch embedding failed: "+err.Error())
+	}
+}
+
+// adminTags 解析 tags 参数，可以是字符串数组，也可以是逗号分隔的字符串
+func adminTags(tags gjson.Result) []string {
+	if !tags.IsArray() {
+		return parseTags(tags.String())
+	}
+	var items []string
+	for _, item := range tags.Array() {
+		items = append(items, item.String())
+	}
+	return parseTags(strings.Join(items, ","))
+}
+
+func sendAdminDeleteResult(result adminDeleteResult, err error) {
+	if err != nil {
+		// the remaining orphan vectors are deleted on the next semantic hit or by the orphan sweep
+		result.Error = err.Error()
+		sendAdminJSON(502, result)
+		return
+	}
+	sendAdminJSON(200, result)
+}
+
+func sendAdminJSON(statusCode uint32, body interface{}) {
+	data, _ := json.Marshal(body)
+	proxywasm.SendHttpResponse(statusCode, [][2]string{{"content-type", "application/json; charset=utf-8"}}, data, -1)
+}
+
+func sendAdminError(statusCode uint32, message string) {
+	sendAdminJSON(statusCode, map[string]string{"error": message})
+}
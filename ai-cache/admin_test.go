@@ -0,0 +1,118 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/alibaba/higress/plugins/wasm-go/extensions/ai-cache/config"
+	"github.com/tidwall/gjson"
+)
+
+func testAdminParams(values map[string]string) adminParams {
+	return func(name string) string {
+		return values[name]
+	}
+}
+
+func TestAdminScope(t *testing.T) {
+	c := config.PluginConfig{
+		CacheKeyPrefix:    DefaultCacheKeyPrefix,
+		CacheKeyNamespace: "default",
+		Rules:             []config.CacheRule{{CacheKeyPrefix: "ruleCache"}},
+		TenantConfig:      config.TenantConfig{From: config.TenantFromConsumer, SharedNamespaces: []string{"public"}},
+	}
+	tests := []struct {
+		name          string
+		params        map[string]string
+		requireTenant bool
+		wantErr       bool
+		wantScope     string
+	}{
+		{name: "all tenants", params: map[string]string{}, wantScope: DefaultCacheKeyPrefix + ":" + CacheKeyVersion + ":default"},
+		{name: "tenant required", params: map[string]string{}, requireTenant: true, wantErr: true},
+		{name: "tenant", params: map[string]string{"tenant": "alice"}, requireTenant: true, wantScope: DefaultCacheKeyPrefix + ":" + CacheKeyVersion + ":default:" + tenantScopePrefix + tenantDigest("alice")},
+		{name: "shared namespace", params: map[string]string{"namespace": "public", "tenant": "alice"}, requireTenant: true, wantScope: DefaultCacheKeyPrefix + ":" + CacheKeyVersion + ":public"},
+		{name: "rule prefix", params: map[string]string{"keyPrefix": "ruleCache", "namespace": "public"}, wantScope: "ruleCache:" + CacheKeyVersion + ":public"},
+		{name: "unknown prefix", params: map[string]string{"keyPrefix": "otherApp"}, wantErr: true},
+		{name: "invalid namespace", params: map[string]string{"namespace": "a:b"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scoped, err := adminScope(c, testAdminParams(tt.params), tt.requireTenant)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("adminScope() error = %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && cacheKeyScope(scoped) != tt.wantScope {
+				t.Fatalf("cacheKeyScope() = %s, want %s", cacheKeyScope(scoped), tt.wantScope)
+			}
+		})
+	}
+}
+
+// 按前缀清空时只允许配置中的前缀，按命名空间清空时前缀以分隔符结尾，不会删除共享同一个前缀的其他命名空间
+func TestPurgePrefix(t *testing.T) {
+	c := config.PluginConfig{CacheKeyPrefix: DefaultCacheKeyPrefix, CacheKeyNamespace: "team"}
+	tests := []struct {
+		prefix string
+		want   bool
+	}{
+		{prefix: DefaultCacheKeyPrefix + ":", want: true},
+		{prefix: DefaultCacheKeyPrefix + ":" + CacheKeyVersion + ":team", want: true},
+		{prefix: DefaultCacheKeyPrefix},
+		{prefix: "otherApp:" + CacheKeyVersion},
+		{prefix: ":" + DefaultCacheKeyPrefix},
+	}
+	for _, tt := range tests {
+		t.Run(tt.prefix, func(t *testing.T) {
+			if got := hasConfiguredKeyPrefix(c, tt.prefix); got != tt.want {
+				t.Fatalf("hasConfiguredKeyPrefix(%q) = %v, want %v", tt.prefix, got, tt.want)
+			}
+		})
+	}
+
+	scoped, err := adminScope(c, testAdminParams(map[string]string{"namespace": "team"}), false)
+	if err != nil {
+		t.Fatalf("adminScope() = %v", err)
+	}
+	prefix := cacheKeyScope(scoped) + ":"
+	other := c
+	other.CacheKeyNamespace = "team2"
+	if !strings.HasPrefix(answerRedisKey(c, "hello"), prefix) || strings.HasPrefix(answerRedisKey(other, "hello"), prefix) {
+		t.Fatalf("purge prefix %s does not match exactly the keys of the namespace", prefix)
+	}
+}
+
+func TestAnswerKeyVectorID(t *testing.T) {
+	c := config.PluginConfig{CacheKeyPrefix: DefaultCacheKeyPrefix, CacheKeyNamespace: "default"}
+	if got := answerKeyVectorID(answerRedisKey(c, "hello")); got != vectorID("hello") {
+		t.Fatalf("answerKeyVectorID() = %q, want %q", got, vectorID("hello"))
+	}
+	for _, redisKey := range []string{
+		tagRedisKey(c, "faq"),
+		queryEmbeddingRedisKey(c, "hello"),
+		answerRedisKey(c, "hello") + "x",
+		"hello",
+	} {
+		if got := answerKeyVectorID(redisKey); got != "" {
+			t.Fatalf("answerKeyVectorID(%q) = %q, want empty", redisKey, got)
+		}
+	}
+}
+
+func TestAdminTags(t *testing.T) {
+	tests := []struct {
+		tags string
+		want string
+	}{
+		{tags: `["faq"," billing ",""]`, want: "faq,billing"},
+		{tags: `"faq, billing"`, want: "faq,billing"},
+		{tags: `""`, want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.tags, func(t *testing.T) {
+			if got := strings.Join(adminTags(gjson.Parse(tt.tags)), ","); got != tt.want {
+				t.Fatalf("adminTags(%s) = %q, want %q", tt.tags, got, tt.want)
+			}
+		})
+	}
+}
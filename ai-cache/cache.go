@@ -2,47 +2,22 @@
 package main
 
 import (
-	"fmt"
-	"net/http"
+	"errors"
+	"time"
 
-	"github.com/alibaba/higress/plugins/wasm-go/extensions/ai-cache/vectorDBProvider"
+	"github.com/alibaba/higress/plugins/wasm-go/extensions/ai-cache/config"
+	textEmbeddingProvider "github.com/alibaba/higress/plugins/wasm-go/extensions/ai-cache/textEmbeddingProvider"
+	vectorStoreProvider "github.com/alibaba/higress/plugins/wasm-go/extensions/ai-cache/vectorStoreProvider"
 	"github.com/alibaba/higress/plugins/wasm-go/pkg/wrapper"
 	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm"
-	"github.com/tidwall/gjson"
-	"github.com/tidwall/resp"
 )
 
-type CacheConfig struct {
-	// @Title zh-CN redis 服务名称
-	// @Description zh-CN 带服务类型的完整 FQDN 名称，例如 my-redis.dns、redis.my-ns.svc.cluster.local
-	RedisServiceName string `required:"true" yaml:"serviceName" json:"serviceName"`
-	// @Title zh-CN redis 服务端口
-	// @Description zh-CN 默认值为6379
-	RedisServicePort int `required:"false" yaml:"servicePort" json:"servicePort"`
-	// @Title zh-CN 用户名
-	// @Description zh-CN 登陆 redis 的用户名，非必填
-	RedisUsername string `required:"false" yaml:"username" json:"username"`
-	// @Title zh-CN 密码
-	// @Description zh-CN 登陆 redis 的密码，非必填，可以只填密码
-	RedisPassword string `required:"false" yaml:"password" json:"password"`
-	// @Title zh-CN 请求超时
-	// @Description zh-CN 请求 redis 的超时时间，单位为毫秒。默认值是1000，即1秒
-	RedisTimeout int `required:"false" yaml:"timeout" json:"timeout"`
-
-	redisClient wrapper.RedisClient `yaml:"-" json:"-"`
-
-	activeEmbeddingProvider vectorDBProvider.ProviderConfig `yaml:"-" json:"-"`
-
-	activeVectorDBProvider vectorDBProvider.ProviderConfig `yaml:"-" json:"-"`
-}
-
-func (c *CacheConfig) FromJson(json gjson.Result) {
-	c.RedisServiceName = json.Get("RedisServiceName").String()
-	c.RedisServicePort = int(json.Get("RedisServicePort").Int())
-	c.RedisUsername = json.Get("RedisUsername").String()
-	c.RedisPassword = json.Get("RedisPassword").String()
-	c.RedisTimeout = int(json.Get("RedisTimeout").Int())
-}
+const (
+	// 查询文本的向量化结果在 L1 缓存中的字段名
+	queryEmbeddingField = "vector"
+	// 向量中保存 key 的前缀和命名空间的字段
+	vectorFieldScope = "namespace"
+)
 
 // ===================== 以下是主要逻辑 =====================
 // 主handler函数，根据key从redis中获取value ，如果不命中，则首先调用文本向量化接口向量化query，然后调用向量搜索接口搜索最相似的出现过的key，最后再次调用redis获取结果
@@ -51,21 +26,49 @@ func (c *CacheConfig) FromJson(json gjson.Result) {
 // 1. query 进来和 redis 中存的 key 匹配 (redisSearchHandler) ，若完全一致则直接返回 (handleCacheHit)
 // 2. 否则请求 text_embdding 接口将 query 转换为 query_embedding (fetchAndProcessEmbeddings)
 // 3. 用 query_embedding 和向量数据库中的向量做 ANN search，返回最接近的 key ，并用阈值过滤 (performQueryAndRespond)
-// 4. 若返回结果为空或大于阈值，舍去，本轮 cache 未命中, 最后将 query_embedding 存入向量数据库 (uploadQueryEmbedding)
+// 4. 若返回结果为空或大于阈值，舍去，本轮 cache 未命中，立即放行请求，query_embedding 保存在 ctx 中
 // 5. 若小于阈值，则再次调用 redis对 most similar key 做匹配。 (redisSearchHandler)
-// 7. 在 response 阶段请求 redis 新增key/LLM返回结果
+// 7. 在 response 阶段请求 redis 新增key/LLM返回结果，写入成功后再将 query_embedding 存入向量数据库 (uploadQueryEmbedding)
 
-func redisSearchHandler(key string, ctx wrapper.HttpContext, config PluginConfig, log wrapper.Log, stream bool, ifUseEmbedding bool) error {
-	err := config.redisClient.Get(config.CacheKeyPrefix+key, func(response resp.Value) {
-		if err := response.Error(); err == nil && !response.IsNull() {
-			log.Warnf("cache hit, key:%s", key)
-			handleCacheHit(key, response, stream, ctx, config, log)
+func redisSearchHandler(key string, ctx wrapper.HttpContext, config config.PluginConfig, log wrapper.Log, stream bool, ifUseEmbedding bool) error {
+	redisKey := answerRedisKey(config, key)
+	l1Cache := config.GetL1Cache()
+	if l1Cache != nil {
+		if fields, ok := l1Cache.Get(redisKey); ok {
+			if entry := parseLiveCacheEntry(config, fields); entry != nil {
+				incrementCounter(metricL1Hits, 1)
+				log.Debugf("l1 cache hit, key:%s", redisKey)
+				serveCacheEntry(key, redisKey, entry, ifUseEmbedding, stream, ctx, config, log)
+				return nil
+			}
+			l1Cache.Delete(redisKey)
+		}
+		incrementCounter(metricL1Misses, 1)
+	}
+	lookupStart := time.Now()
+	err := config.GetCacheStore().GetFields(redisKey, func(fields map[string]string, err error) {
+		incrementCounter(metricL2Lookups, 1)
+		incrementCounter(metricL2LookupMilliseconds, uint64(time.Since(lookupStart).Milliseconds()))
+		var entry *cacheEntry
+		if err == nil {
+			entry = parseLiveCacheEntry(config, fields)
+		}
+		if entry != nil {
+			incrementCounter(metricL2Hits, 1)
+			if l1Cache != nil {
+				l1Cache.Set(redisKey, fields)
+			}
+			serveCacheEntry(key, redisKey, entry, ifUseEmbedding, stream, ctx, config, log)
 		} else {
-			log.Warnf("cache miss, key:%s", key)
+			incrementCounter(metricL2Misses, 1)
+			log.Warnf("cache miss, key:%s", redisKey)
 			if ifUseEmbedding {
-				handleCacheMiss(key, err, response, ctx, config, log, key, stream)
+				queryText, _ := splitKeySuffix(key)
+				coalesceCacheMiss(key, err, ctx, config, log, queryText, stream)
 			} else {
-				proxywasm.ResumeHttpRequest()
+				// the same test as the orphan sweep, a failed lookup or an unreadable entry does not prove the answer is gone
+				handleStaleVector(ctx, config, log, err == nil && len(fields) == 0)
+				resumeRequest(ctx)
 				return
 			}
 		}
@@ -73,131 +76,221 @@ func redisSearchHandler(key string, ctx wrapper.HttpContext, config PluginConfig
 	return err
 }
 
-// 简单处理缓存命中的情况, 从redis中获取到value后，直接返回
-func handleCacheHit(key string, response resp.Value, stream bool, ctx wrapper.HttpContext, config PluginConfig, log wrapper.Log) {
-	log.Warnf("cache hit, key:%s", key)
+// 简单处理缓存命中的情况, 从缓存存储中获取到缓存条目后，更新命中统计，由当前请求的协议按 stream 标识构造响应并直接返回
+// 响应头 x-ai-cache-status 标识条目是否已经超过软过期时间
+func handleCacheHit(redisKey string, entry *cacheEntry, stream bool, ctx wrapper.HttpContext, config config.PluginConfig, log wrapper.Log) {
+	log.Warnf("cache hit, key:%s, hit count:%d", redisKey, entry.HitCount+1)
+	cacheStatus := cacheStatusHit
+	if isEntryStale(config, entry) {
+		cacheStatus = cacheStatusStale
+		incrementCounter(metricStaleHits, 1)
+	}
 	ctx.SetContext(CacheKeyContextKey, nil)
+	shareSingleFlightHit(ctx, config, redisKey)
+	touchCacheEntry(config, redisKey)
+	answer := entry.Answer
+	activeProtocol := getProtocol(ctx, config)
 	if !stream {
-		proxywasm.SendHttpResponse(200, [][2]string{{"content-type", "application/json; charset=utf-8"}}, []byte(fmt.Sprintf(config.ReturnResponseTemplate, response.String())), -1)
+		proxywasm.SendHttpResponse(200, [][2]string{{"content-type", "application/json; charset=utf-8"}, {cacheStatusHeader, cacheStatus}}, activeProtocol.BuildResponse(answer), -1)
 	} else {
-		proxywasm.SendHttpResponse(200, [][2]string{{"content-type", "text/event-stream; charset=utf-8"}}, []byte(fmt.Sprintf(config.ReturnStreamResponseTemplate, response.String())), -1)
+		proxywasm.SendHttpResponse(200, [][2]string{{"content-type", activeProtocol.StreamContentType(ctx.Path())}, {cacheStatusHeader, cacheStatus}}, activeProtocol.BuildStreamResponse(ctx.Path(), answer), -1)
 	}
 }
 
 // 处理缓存未命中的情况，调用fetchAndProcessEmbeddings函数向量化query
-func handleCacheMiss(key string, err error, response resp.Value, ctx wrapper.HttpContext, config PluginConfig, log wrapper.Log, queryString string, stream bool) {
+func handleCacheMiss(key string, err error, ctx wrapper.HttpContext, config config.PluginConfig, log wrapper.Log, queryString string, stream bool) {
 	if err != nil {
-		log.Warnf("redis get key:%s failed, err:%v", key, err)
+		log.Warnf("cache store get key:%s failed, err:%v", answerRedisKey(config, key), err)
+	}
+	if !config.CacheQueryEmbeddings {
+		fetchAndProcessEmbeddings(key, ctx, config, log, queryString, stream)
+		return
 	}
-	if response.IsNull() {
-		log.Warnf("cache miss, key:%s", key)
+	embeddingKey := queryEmbeddingRedisKey(config, queryString)
+	l1Cache := config.GetL1Cache()
+	if l1Cache != nil {
+		if fields, ok := l1Cache.Get(embeddingKey); ok {
+			if embedding, ok := decodeFloat32Vector(fields[queryEmbeddingField]); ok {
+				incrementCounter(metricQueryEmbeddingHits, 1)
+				useQueryEmbedding(key, embedding, ctx, config, log, stream)
+				return
+			}
+		}
+	}
+	err = config.GetCacheStore().Get(embeddingKey, func(value string, found bool, err error) {
+		if err != nil {
+			log.Warnf("cache store get query embedding failed, err:%v", err)
+		}
+		if embedding, ok := decodeFloat32Vector(value); found && ok {
+			incrementCounter(metricQueryEmbeddingHits, 1)
+			if l1Cache != nil {
+				l1Cache.Set(embeddingKey, map[string]string{queryEmbeddingField: value})
+			}
+			useQueryEmbedding(key, embedding, ctx, config, log, stream)
+			return
+		}
+		incrementCounter(metricQueryEmbeddingMisses, 1)
+		fetchAndProcessEmbeddings(key, ctx, config, log, queryString, stream)
+	})
+	if err != nil {
+		log.Warnf("cache store get query embedding failed, err:%v", err)
+		fetchAndProcessEmbeddings(key, ctx, config, log, queryString, stream)
 	}
-	fetchAndProcessEmbeddings(key, ctx, config, log, queryString, stream)
 }
 
-// 调用文本向量化接口向量化query, 向量化成功后调用processFetchedEmbeddings函数处理向量化结果
-func fetchAndProcessEmbeddings(key string, ctx wrapper.HttpContext, config PluginConfig, log wrapper.Log, queryString string, stream bool) {
-	Emb_url, Emb_requestBody, Emb_headers := ConstructTextEmbeddingParameters(&config, log, []string{queryString})
-	config.DashVectorInfo.DashScopeClient.Post(
-		Emb_url,
-		Emb_headers,
-		Emb_requestBody,
-		func(statusCode int, responseHeaders http.Header, responseBody []byte) {
-			// log.Infof("statusCode:%d, responseBody:%s", statusCode, string(responseBody))
-			log.Infof("Successfully fetched embeddings for key: %s", key)
-			if statusCode != 200 {
-				log.Errorf("Failed to fetch embeddings, statusCode: %d, responseBody: %s", statusCode, string(responseBody))
-				ctx.SetContext(QueryEmbeddingKey, nil)
-				proxywasm.ResumeHttpRequest()
-			} else {
-				processFetchedEmbeddings(key, responseBody, ctx, config, log, stream)
-			}
-		},
-		10000)
+// 将向量化的结果写入缓存，相同的文本再次未命中时不需要重新调用文本向量化接口
+func storeQueryEmbedding(config config.PluginConfig, queryString string, embedding []float64) {
+	embeddingKey := queryEmbeddingRedisKey(config, queryString)
+	value := encodeFloat32Vector(embedding)
+	config.GetCacheStore().Set(embeddingKey, value, config.QueryEmbeddingCacheTTL, nil)
+	if l1Cache := config.GetL1Cache(); l1Cache != nil {
+		l1Cache.Set(embeddingKey, map[string]string{queryEmbeddingField: value})
+	}
+}
+
+// 调用文本向量化接口向量化query, 向量化成功后开启 cacheQueryEmbeddings 时写入缓存，再发起向量搜索
+func fetchAndProcessEmbeddings(key string, ctx wrapper.HttpContext, config config.PluginConfig, log wrapper.Log, queryString string, stream bool) {
+	err := fetchQueryEmbedding(config, log, queryString, func(text_embedding []float64, err error) {
+		if err != nil {
+			log.Errorf("Failed to fetch embeddings, err: %v", err)
+			ctx.SetContext(QueryEmbeddingKey, nil)
+			resumeRequest(ctx)
+			return
+		}
+		log.Infof("Successfully fetched embeddings for key: %s", answerRedisKey(config, key))
+		if config.CacheQueryEmbeddings {
+			storeQueryEmbedding(config, queryString, text_embedding)
+		}
+		useQueryEmbedding(key, text_embedding, ctx, config, log, stream)
+	})
+	if err != nil {
+		log.Errorf("Failed to fetch embeddings, err: %v", err)
+		resumeRequest(ctx)
+	}
+}
+
+// fetchQueryEmbedding 调用文本向量化服务向量化一段文本，超过规范化配置中最大长度的部分不参与向量化
+func fetchQueryEmbedding(config config.PluginConfig, log wrapper.Log, queryString string, callback func(text_embedding []float64, err error)) error {
+	embedder, ok := config.GetEmbeddingProvider().(textEmbeddingProvider.GetEmbedding)
+	if !ok {
+		return errors.New("the text embedding provider does not support embedding")
+	}
+	return embedder.GetEmbedding(config.GetNormalizer().Truncate(queryString), callback)
 }
 
 // 先将向量化的结果存入上下文ctx变量，其次发起向量搜索请求
-func processFetchedEmbeddings(key string, responseBody []byte, ctx wrapper.HttpContext, config PluginConfig, log wrapper.Log, stream bool) {
-	text_embedding_raw, _ := ParseTextEmbedding(responseBody)
-	text_embedding := text_embedding_raw.Output.Embeddings[0].Embedding
+func useQueryEmbedding(key string, text_embedding []float64, ctx wrapper.HttpContext, config config.PluginConfig, log wrapper.Log, stream bool) {
 	// ctx.SetContext(CacheKeyContextKey, text_embedding)
 	ctx.SetContext(QueryEmbeddingKey, text_embedding)
 	ctx.SetContext(CacheKeyContextKey, key)
+	recordVectorDimension(len(text_embedding))
 	performQueryAndRespond(key, text_embedding, ctx, config, log, stream)
 }
 
 // 调用向量搜索接口搜索最相似的key，搜索成功后调用redisSearchHandler函数获取最相似的key的结果
-func performQueryAndRespond(key string, text_embedding []float64, ctx wrapper.HttpContext, config PluginConfig, log wrapper.Log, stream bool) {
-	vector_url, vector_request, vector_headers, err := ConstructEmbeddingQueryParameters(config, text_embedding)
-	if err != nil {
-		log.Errorf("Failed to perform query, err: %v", err)
-		proxywasm.ResumeHttpRequest()
+func performQueryAndRespond(key string, text_embedding []float64, ctx wrapper.HttpContext, config config.PluginConfig, log wrapper.Log, stream bool) {
+	querier, ok := config.GetVectorProvider().(vectorStoreProvider.QueryEmbedding)
+	if !ok {
+		log.Errorf("the vector store provider does not support querying")
+		resumeRequest(ctx)
 		return
 	}
-	config.DashVectorInfo.DashVectorClient.Post(
-		vector_url,
-		vector_headers,
-		vector_request,
-		func(statusCode int, responseHeaders http.Header, responseBody []byte) {
-			log.Infof("statusCode:%d, responseBody:%s", statusCode, string(responseBody))
-			query_resp, err_query := ParseQueryResponse(responseBody)
-			if err_query != nil {
-				log.Errorf("Failed to parse response: %v", err)
-				proxywasm.ResumeHttpRequest()
-				return
-			}
-			if len(query_resp.Output) < 1 {
-				log.Warnf("query response is empty")
-				uploadQueryEmbedding(ctx, config, log, key, text_embedding)
-				return
-			}
-			most_similar_key := query_resp.Output[0].Fields["query"].(string)
-			log.Infof("most similar key:%s", most_similar_key)
-			most_similar_score := query_resp.Output[0].Score
-			if most_similar_score < 0.1 {
-				ctx.SetContext(CacheKeyContextKey, nil)
-				redisSearchHandler(most_similar_key, ctx, config, log, stream, false)
-			} else {
-				log.Infof("the most similar key's score is too high, key:%s, score:%f", most_similar_key, most_similar_score)
-				uploadQueryEmbedding(ctx, config, log, key, text_embedding)
-				proxywasm.ResumeHttpRequest()
-				return
+	err := querier.QueryEmbedding(vectorStoreProvider.QueryRequest{
+		Vector: text_embedding,
+		TopK:   1,
+		Filter: vectorScopeFilter(config),
+	}, func(query_resp vectorStoreProvider.QueryResponse, err error) {
+		if err != nil {
+			log.Errorf("Failed to query vector store: %v", err)
+			resumeRequest(ctx)
+			return
+		}
+		if len(query_resp.Output) < 1 {
+			log.Warnf("query response is empty")
+			resumeRequest(ctx)
+			return
+		}
+		most_similar_key, _ := query_resp.Output[0].Fields["query"].(string)
+		log.Infof("most similar key:%s", answerRedisKey(config, most_similar_key))
+		most_similar_score := query_resp.Output[0].Score
+		_, similarKeySuffix := splitKeySuffix(most_similar_key)
+		_, keySuffix := splitKeySuffix(key)
+		if similarKeySuffix != keySuffix {
+			// the similar query was asked with different images or tools, its answer can not be reused
+			log.Infof("the most similar key was cached with different media or tools, key:%s", answerRedisKey(config, most_similar_key))
+			resumeRequest(ctx)
+			return
+		}
+		if most_similar_score < config.SimilarityThreshold {
+			ctx.SetContext(CacheKeyContextKey, nil)
+			ctx.SetContext(SimilarVectorContextKey, similarVector{id: query_resp.Output[0].ID, key: key})
+			if err := redisSearchHandler(most_similar_key, ctx, config, log, stream, false); err != nil {
+				log.Errorf("cache store get similar key failed, err:%v", err)
+				handleStaleVector(ctx, config, log, false)
+				resumeRequest(ctx)
 			}
-		},
-		100000)
+		} else {
+			log.Infof("the most similar key's score is too high, key:%s, score:%f", answerRedisKey(config, most_similar_key), most_similar_score)
+			resumeRequest(ctx)
+			return
+		}
+	})
+	if err != nil {
+		log.Errorf("Failed to perform query, err: %v", err)
+		resumeRequest(ctx)
+	}
 }
 
-// 未命中cache，则将新的query embedding和对应的key存入向量数据库
-func uploadQueryEmbedding(ctx wrapper.HttpContext, config PluginConfig, log wrapper.Log, key string, text_embedding []float64) error {
-	vector_url, vector_body, err := ConsturctEmbeddingInsertParameters(&config, log, text_embedding, key)
-	if err != nil {
-		log.Errorf("Failed to construct embedding insert parameters: %v", err)
-		proxywasm.ResumeHttpRequest()
-		return nil
+// 未命中cache时，在上游成功返回且回答写入缓存后，将新的query embedding和对应的key存入向量数据库
+// 写入在响应阶段异步进行，请求在向量检索确定未命中后就已经放行，不需要等待写入完成
+// 向量写入失败时删除已写入的回答，保证向量和回答同时存在或同时不存在；没有配置向量数据库或者向量数据库不支持写入时不写入向量，
+// 回答仍然保留，可以被精确匹配命中
+// 向量与回答使用相同的过期时间，不支持原生过期的向量数据库由服务提供者记录过期时间并在查询时过滤
+// done 不为空时在写入完成后调用，写入失败时回答已经被删除
+func uploadQueryEmbedding(config config.PluginConfig, log wrapper.Log, key string, text_embedding []float64, done func(err error)) {
+	inserter, ok := config.GetVectorProvider().(vectorStoreProvider.InsertEmbedding)
+	if !ok {
+		log.Debugf("the vector store provider does not support inserting, skip uploading query embedding for key: %s", answerRedisKey(config, key))
+		if done != nil {
+			done(nil)
+		}
+		return
 	}
-	err = config.DashVectorInfo.DashVectorClient.Post(
-		vector_url,
-		[][2]string{
-			{"Content-Type", "application/json"},
-			{"dashvector-auth-token", config.DashVectorInfo.DashVectorKey},
-		},
-		vector_body,
-		func(statusCode int, responseHeaders http.Header, responseBody []byte) {
-			if statusCode != 200 {
-				log.Errorf("Failed to upload query embedding: %s", responseBody)
-			} else {
-				log.Infof("Successfully uploaded query embedding for key: %s", key)
-			}
-			proxywasm.ResumeHttpRequest()
-		},
-		10000,
-	)
+	incrementCounter(metricVectorInserts, 1)
+	finish := func(err error) {
+		if err != nil {
+			log.Errorf("Failed to upload query embedding: %v", err)
+			handleVectorInsertFailure(config, key)
+		} else {
+			log.Infof("Successfully uploaded query embedding for key: %s", answerRedisKey(config, key))
+		}
+		if done != nil {
+			done(err)
+		}
+	}
+	insertStart := time.Now()
+	err := inserter.InsertEmbedding([]vectorStoreProvider.Document{{
+		ID:     vectorID(key),
+		Vector: text_embedding,
+		Fields: map[string]interface{}{"query": key, vectorFieldScope: cacheKeyScope(config)},
+	}}, config.CacheTTL, func(err error) {
+		incrementCounter(metricVectorInsertMilliseconds, uint64(time.Since(insertStart).Milliseconds()))
+		finish(err)
+	})
 	if err != nil {
-		log.Errorf("Failed to upload query embedding: %v", err)
-		proxywasm.ResumeHttpRequest()
-		return nil
+		finish(err)
 	}
-	return nil
+}
+
+// vectorScopeFilter 只检索与当前请求的 key 前缀和命名空间相同的向量，其他范围的向量对应的回答不在当前请求的范围内
+func vectorScopeFilter(config config.PluginConfig) string {
+	return vectorFieldScope + " = '" + cacheKeyScope(config) + "'"
+}
+
+// 向量写入失败时删除对应的回答，下一次相同的请求会重新走未命中的流程
+func handleVectorInsertFailure(config config.PluginConfig, key string) {
+	incrementCounter(metricVectorInsertFailures, 1)
+	deleteCacheEntry(config, answerRedisKey(config, key))
 }
 
 // ===================== 以上是主要逻辑 =====================
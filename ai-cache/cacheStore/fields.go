@@ -0,0 +1,52 @@
+// 这个文件中实现由多个字段组成的条目与 JSON 对象之间的转换，sharedData 和 http 缓存存储都以 JSON 对象保存条目
+// JSON 字符串只能表示合法的 UTF-8，压缩后的字段和二进制的向量等非 UTF-8 的值编码为 base64 并加上前缀，
+// 恰好以该前缀开头的文本同样编码，保证解码后与写入的值完全相同
+package cacheStore
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"unicode/utf8"
+)
+
+const binaryFieldPrefix = "base64:"
+
+func encodeFields(fields map[string]string) string {
+	encoded := make(map[string]string, len(fields))
+	for name, value := range fields {
+		encoded[name] = encodeFieldValue(value)
+	}
+	data, _ := json.Marshal(encoded)
+	return string(data)
+}
+
+func decodeFields(data string) (map[string]string, error) {
+	fields := make(map[string]string)
+	if err := json.Unmarshal([]byte(data), &fields); err != nil {
+		return nil, err
+	}
+	for name, value := range fields {
+		decoded, err := decodeFieldValue(value)
+		if err != nil {
+			return nil, err
+		}
+		fields[name] = decoded
+	}
+	return fields, nil
+}
+
+func encodeFieldValue(value string) string {
+	if utf8.ValidString(value) && !strings.HasPrefix(value, binaryFieldPrefix) {
+		return value
+	}
+	return binaryFieldPrefix + base64.StdEncoding.EncodeToString([]byte(value))
+}
+
+func decodeFieldValue(value string) (string, error) {
+	if !strings.HasPrefix(value, binaryFieldPrefix) {
+		return value, nil
+	}
+	decoded, err := base64.StdEncoding.DecodeString(value[len(binaryFieldPrefix):])
+	return string(decoded), err
+}
@@ -0,0 +1,74 @@
+package cacheStore
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFieldsRoundTrip(t *testing.T) {
+	tests := []struct {
+		name   string
+		fields map[string]string
+	}{
+		{name: "empty", fields: map[string]string{}},
+		{name: "text", fields: map[string]string{"answer": "你好，world", "hit_count": "3"}},
+		{name: "empty value", fields: map[string]string{"tags": ""}},
+		{name: "gzip compressed value", fields: map[string]string{"answer": "\x00\x01\x1f\x8b\x08\x00\xff\xfe"}},
+		{name: "float32 vector", fields: map[string]string{"vector": "\x00\x00\x80\x3f\xcd\xcc\x4c\xbe"}},
+		{name: "text starting with the binary prefix", fields: map[string]string{"answer": binaryFieldPrefix + "aGVsbG8="}},
+		{name: "json special characters", fields: map[string]string{"answer": "\"quoted\"\n\t\\<html>&"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeFields(encodeFields(tt.fields))
+			if err != nil {
+				t.Fatalf("decodeFields() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.fields) {
+				t.Fatalf("decodeFields(encodeFields()) = %q, want %q", got, tt.fields)
+			}
+		})
+	}
+}
+
+func TestEncodeFieldValue(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  string
+	}{
+		{name: "text is kept", value: "hello", want: "hello"},
+		{name: "invalid utf-8", value: "\xff", want: binaryFieldPrefix + "/w=="},
+		{name: "binary prefix", value: binaryFieldPrefix, want: binaryFieldPrefix + "YmFzZTY0Og=="},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encoded := encodeFieldValue(tt.value)
+			if encoded != tt.want {
+				t.Fatalf("encodeFieldValue(%q) = %q, want %q", tt.value, encoded, tt.want)
+			}
+			decoded, err := decodeFieldValue(encoded)
+			if err != nil || decoded != tt.value {
+				t.Fatalf("decodeFieldValue(%q) = %q, %v, want %q", encoded, decoded, err, tt.value)
+			}
+		})
+	}
+}
+
+func TestDecodeFieldsInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{name: "not json", data: "answer"},
+		{name: "not an object of strings", data: `{"hit_count":3}`},
+		{name: "invalid base64", data: `{"answer":"base64:***"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := decodeFields(tt.data); err == nil {
+				t.Fatalf("decodeFields(%q) error = nil, want an error", tt.data)
+			}
+		})
+	}
+}
@@ -0,0 +1,265 @@
+// 基于 HTTP 的通用键值存储，key 经过 URL 编码后拼接在 basePath 之后：
+//   - GET    <basePath><key>                              读取 value，404 表示不存在
+//   - PUT    <basePath><key>?ttl=<秒>                     写入 value
+//   - DELETE <basePath><key>                              删除
+//   - POST   <basePath><key>?op=touch&ttl=<秒>            重新设置过期时间
+//   - POST   <basePath><key>?op=incr&delta=<n>[&field=<f>] 原子地增加计数器或条目中字段的值，响应 Body 为增加后的值
+//   - POST   <basePath><key>?op=hset&field=<f>            将条目中的一个字段设置为请求 Body
+//   - POST   <basePath><key>?op=hit&field=<f>&timeField=<f>&time=<秒> 条目存在时将 field 加一并将 timeField 设置为 time，条目不存在时返回 404 且不创建
+//   - POST   <basePath><key>?op=setnx&px=<毫秒>           key 不存在时写入请求 Body，key 已存在时返回 409
+//   - DELETE <basePath>?prefix=<前缀>                     删除所有以前缀开头的 key，响应 Body 为删除的 key 组成的 JSON 数组
+//
+// 由多个字段组成的条目以 JSON 对象的形式读写，非 UTF-8 的字段值编码为 "base64:<base64>"，hset 的请求 Body 同样按此编码
+package cacheStore
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/alibaba/higress/plugins/wasm-go/pkg/wrapper"
+)
+
+type httpStoreInitializer struct {
+}
+
+func (h *httpStoreInitializer) ValidateConfig(config StoreConfig) error {
+	if config.HTTP.ServiceName == "" {
+		return errors.New("cache store http service name must not by empty")
+	}
+	return nil
+}
+
+func (h *httpStoreInitializer) CreateStore(config StoreConfig) (CacheStore, error) {
+	headers := [][2]string{{"Content-Type", "application/octet-stream"}}
+	for name, value := range config.HTTP.Headers {
+		headers = append(headers, [2]string{name, value})
+	}
+	return &HTTPStore{
+		config: config.HTTP,
+		client: wrapper.NewClusterClient(wrapper.FQDNCluster{
+			FQDN: config.HTTP.ServiceName,
+			Port: int64(config.HTTP.ServicePort),
+		}),
+		headers: headers,
+	}, nil
+}
+
+type HTTPStore struct {
+	config  HTTPConfig
+	client  wrapper.HttpClient
+	headers [][2]string
+}
+
+func (h *HTTPStore) GetStoreType() string {
+	return StoreTypeHTTP
+}
+
+func (h *HTTPStore) Get(key string, callback ValueCallback) error {
+	return h.client.Get(h.url(key, nil), h.headers, func(statusCode int, responseHeaders http.Header, responseBody []byte) {
+		if callback == nil {
+			return
+		}
+		if statusCode == http.StatusNotFound {
+			callback("", false, nil)
+			return
+		}
+		if err := httpStatusError(statusCode, responseBody); err != nil {
+			callback("", false, err)
+			return
+		}
+		callback(string(responseBody), true, nil)
+	}, uint32(h.config.Timeout))
+}
+
+// MGet 并发读取每个 key，所有请求返回后调用回调
+func (h *HTTPStore) MGet(keys []string, callback ValuesCallback) error {
+	values := make([]string, len(keys))
+	found := make([]bool, len(keys))
+	pending := len(keys)
+	var firstErr error
+	for i, key := range keys {
+		index := i
+		err := h.Get(key, func(value string, ok bool, err error) {
+			values[index], found[index] = value, ok
+			if err != nil && firstErr == nil {
+				firstErr = err
+			}
+			pending--
+			if pending == 0 && callback != nil {
+				if firstErr != nil {
+					callback(nil, nil, firstErr)
+				} else {
+					callback(values, found, nil)
+				}
+			}
+		})
+		if err != nil {
+			// 已经发出的请求的回调仍然会被调用，此时不能再调用回调
+			pending = -1
+			return err
+		}
+	}
+	if len(keys) == 0 && callback != nil {
+		callback(values, found, nil)
+	}
+	return nil
+}
+
+func (h *HTTPStore) Set(key, value string, ttl int, callback ErrorCallback) error {
+	return h.client.Put(h.url(key, ttlQuery(ttl)), h.headers, []byte(value), h.errorCallback(callback), uint32(h.config.Timeout))
+}
+
+func (h *HTTPStore) Delete(key string, callback ErrorCallback) error {
+	return h.client.Delete(h.url(key, nil), h.headers, nil, func(statusCode int, responseHeaders http.Header, responseBody []byte) {
+		if callback == nil {
+			return
+		}
+		if statusCode == http.StatusNotFound {
+			callback(nil)
+			return
+		}
+		callback(httpStatusError(statusCode, responseBody))
+	}, uint32(h.config.Timeout))
+}
+
+func (h *HTTPStore) Touch(key string, ttl int, callback ErrorCallback) error {
+	if ttl <= 0 {
+		if callback != nil {
+			callback(nil)
+		}
+		return nil
+	}
+	query := ttlQuery(ttl)
+	query.Set("op", "touch")
+	return h.client.Post(h.url(key, query), h.headers, nil, h.errorCallback(callback), uint32(h.config.Timeout))
+}
+
+func (h *HTTPStore) Incr(key string, delta int64, callback CounterCallback) error {
+	return h.incr(key, "", delta, callback)
+}
+
+func (h *HTTPStore) GetFields(key string, callback FieldsCallback) error {
+	return h.Get(key, func(value string, found bool, err error) {
+		if callback == nil {
+			return
+		}
+		fields := make(map[string]string)
+		if err == nil && found {
+			fields, err = decodeFields(value)
+		}
+		callback(fields, err)
+	})
+}
+
+func (h *HTTPStore) SetFields(key string, fields map[string]string, ttl int, callback ErrorCallback) error {
+	return h.Set(key, encodeFields(fields), ttl, callback)
+}
+
+func (h *HTTPStore) SetField(key, field, value string, callback ErrorCallback) error {
+	query := url.Values{"op": {"hset"}, "field": {field}}
+	return h.client.Post(h.url(key, query), h.headers, []byte(encodeFieldValue(value)), h.errorCallback(callback), uint32(h.config.Timeout))
+}
+
+func (h *HTTPStore) IncrField(key, field string, delta int64, callback CounterCallback) error {
+	return h.incr(key, field, delta, callback)
+}
+
+func (h *HTTPStore) RecordHit(key, countField, timeField string, timestamp int64, callback ErrorCallback) error {
+	query := url.Values{"op": {"hit"}, "field": {countField}, "timeField": {timeField}, "time": {strconv.FormatInt(timestamp, 10)}}
+	return h.client.Post(h.url(key, query), h.headers, nil, func(statusCode int, responseHeaders http.Header, responseBody []byte) {
+		if callback == nil {
+			return
+		}
+		if statusCode == http.StatusNotFound {
+			callback(nil)
+			return
+		}
+		callback(httpStatusError(statusCode, responseBody))
+	}, uint32(h.config.Timeout))
+}
+
+func (h *HTTPStore) SetNX(key, value string, ttlMillis int, callback LockCallback) error {
+	query := url.Values{"op": {"setnx"}, "px": {strconv.Itoa(ttlMillis)}}
+	return h.client.Post(h.url(key, query), h.headers, []byte(value), func(statusCode int, responseHeaders http.Header, responseBody []byte) {
+		if callback == nil {
+			return
+		}
+		if statusCode == http.StatusConflict {
+			callback(false, nil)
+			return
+		}
+		if err := httpStatusError(statusCode, responseBody); err != nil {
+			callback(false, err)
+			return
+		}
+		callback(true, nil)
+	}, uint32(h.config.Timeout))
+}
+
+func (h *HTTPStore) DeleteByPrefix(prefix string, callback KeysCallback) error {
+	return h.client.Delete(h.url("", url.Values{"prefix": {prefix}}), h.headers, nil, func(statusCode int, responseHeaders http.Header, responseBody []byte) {
+		if callback == nil {
+			return
+		}
+		if err := httpStatusError(statusCode, responseBody); err != nil {
+			callback(nil, err)
+			return
+		}
+		var keys []string
+		err := json.Unmarshal(responseBody, &keys)
+		callback(keys, err)
+	}, uint32(h.config.Timeout))
+}
+
+func (h *HTTPStore) incr(key, field string, delta int64, callback CounterCallback) error {
+	query := url.Values{"op": {"incr"}, "delta": {strconv.FormatInt(delta, 10)}}
+	if field != "" {
+		query.Set("field", field)
+	}
+	return h.client.Post(h.url(key, query), h.headers, nil, func(statusCode int, responseHeaders http.Header, responseBody []byte) {
+		if callback == nil {
+			return
+		}
+		if err := httpStatusError(statusCode, responseBody); err != nil {
+			callback(0, err)
+			return
+		}
+		value, err := strconv.ParseInt(strings.TrimSpace(string(responseBody)), 10, 64)
+		callback(value, err)
+	}, uint32(h.config.Timeout))
+}
+
+func (h *HTTPStore) url(key string, query url.Values) string {
+	path := h.config.BasePath + url.PathEscape(key)
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+	return path
+}
+
+func (h *HTTPStore) errorCallback(callback ErrorCallback) wrapper.ResponseCallback {
+	return func(statusCode int, responseHeaders http.Header, responseBody []byte) {
+		if callback != nil {
+			callback(httpStatusError(statusCode, responseBody))
+		}
+	}
+}
+
+func ttlQuery(ttl int) url.Values {
+	query := url.Values{}
+	if ttl > 0 {
+		query.Set("ttl", strconv.Itoa(ttl))
+	}
+	return query
+}
+
+func httpStatusError(statusCode int, responseBody []byte) error {
+	if statusCode >= 200 && statusCode < 300 {
+		return nil
+	}
+	return errors.New("cache store responded with status " + strconv.Itoa(statusCode) + ": " + string(responseBody))
+}
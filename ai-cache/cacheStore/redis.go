@@ -0,0 +1,278 @@
+package cacheStore
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/alibaba/higress/plugins/wasm-go/pkg/wrapper"
+	"github.com/tidwall/resp"
+)
+
+// 原子地替换整个 hash 并设置过期时间，ARGV[1] 为过期时间，其余参数为字段和值
+const redisSetFieldsScript = `
+redis.call('DEL', KEYS[1])
+redis.call('HSET', KEYS[1], unpack(ARGV, 2))
+if tonumber(ARGV[1]) > 0 then
+	redis.call('EXPIRE', KEYS[1], ARGV[1])
+end
+return 1
+`
+
+const redisRecordHitScript = `
+if redis.call('EXISTS', KEYS[1]) == 1 then
+	redis.call('HINCRBY', KEYS[1], ARGV[1], 1)
+	redis.call('HSET', KEYS[1], ARGV[2], ARGV[3])
+	return 1
+end
+return 0
+`
+
+// DeleteByPrefix 每次 SCAN 请求的 COUNT，每页的 key 删除后再请求下一页
+const redisScanCount = 500
+
+type redisStoreInitializer struct {
+}
+
+func (r *redisStoreInitializer) ValidateConfig(config StoreConfig) error {
+	if config.Redis.ServiceName == "" {
+		return errors.New("redis service name must not by empty")
+	}
+	return nil
+}
+
+func (r *redisStoreInitializer) CreateStore(config StoreConfig) (CacheStore, error) {
+	client := wrapper.NewRedisClusterClient(wrapper.FQDNCluster{
+		FQDN: config.Redis.ServiceName,
+		Port: int64(config.Redis.ServicePort),
+	})
+	if err := client.Init(config.Redis.Username, config.Redis.Password, int64(config.Redis.Timeout)); err != nil {
+		return nil, err
+	}
+	return &RedisStore{client: client}, nil
+}
+
+type RedisStore struct {
+	client wrapper.RedisClient
+}
+
+func (r *RedisStore) GetStoreType() string {
+	return StoreTypeRedis
+}
+
+func (r *RedisStore) Get(key string, callback ValueCallback) error {
+	return r.client.Get(key, func(response resp.Value) {
+		if callback == nil {
+			return
+		}
+		if err := response.Error(); err != nil {
+			callback("", false, err)
+			return
+		}
+		callback(response.String(), !response.IsNull(), nil)
+	})
+}
+
+func (r *RedisStore) MGet(keys []string, callback ValuesCallback) error {
+	return r.client.MGet(keys, func(response resp.Value) {
+		if callback == nil {
+			return
+		}
+		if err := response.Error(); err != nil {
+			callback(nil, nil, err)
+			return
+		}
+		values := make([]string, len(keys))
+		found := make([]bool, len(keys))
+		for i, item := range response.Array() {
+			if i < len(keys) && !item.IsNull() {
+				values[i] = item.String()
+				found[i] = true
+			}
+		}
+		callback(values, found, nil)
+	})
+}
+
+func (r *RedisStore) Set(key, value string, ttl int, callback ErrorCallback) error {
+	if ttl > 0 {
+		return r.client.SetEx(key, value, ttl, redisErrorCallback(callback))
+	}
+	return r.client.Set(key, value, redisErrorCallback(callback))
+}
+
+func (r *RedisStore) Delete(key string, callback ErrorCallback) error {
+	return r.client.Del(key, redisErrorCallback(callback))
+}
+
+func (r *RedisStore) Touch(key string, ttl int, callback ErrorCallback) error {
+	if ttl <= 0 {
+		if callback != nil {
+			callback(nil)
+		}
+		return nil
+	}
+	return r.client.Expire(key, ttl, redisErrorCallback(callback))
+}
+
+func (r *RedisStore) Incr(key string, delta int64, callback CounterCallback) error {
+	return r.client.IncrBy(key, int(delta), redisCounterCallback(callback))
+}
+
+func (r *RedisStore) GetFields(key string, callback FieldsCallback) error {
+	return r.client.HGetAll(key, func(response resp.Value) {
+		if callback == nil {
+			return
+		}
+		if err := response.Error(); err != nil {
+			callback(nil, err)
+			return
+		}
+		values := response.Array()
+		fields := make(map[string]string, len(values)/2)
+		for i := 0; i+1 < len(values); i += 2 {
+			fields[values[i].String()] = values[i+1].String()
+		}
+		callback(fields, nil)
+	})
+}
+
+func (r *RedisStore) SetFields(key string, fields map[string]string, ttl int, callback ErrorCallback) error {
+	if len(fields) == 0 {
+		return r.Delete(key, callback)
+	}
+	args := []interface{}{ttl}
+	for name, value := range fields {
+		args = append(args, name, value)
+	}
+	return r.client.Eval(redisSetFieldsScript, 1, []interface{}{key}, args, redisErrorCallback(callback))
+}
+
+func (r *RedisStore) SetField(key, field, value string, callback ErrorCallback) error {
+	return r.client.HSet(key, field, value, redisErrorCallback(callback))
+}
+
+func (r *RedisStore) IncrField(key, field string, delta int64, callback CounterCallback) error {
+	return r.client.HIncrBy(key, field, int(delta), redisCounterCallback(callback))
+}
+
+func (r *RedisStore) RecordHit(key, countField, timeField string, timestamp int64, callback ErrorCallback) error {
+	args := []interface{}{countField, timeField, timestamp}
+	return r.client.Eval(redisRecordHitScript, 1, []interface{}{key}, args, redisErrorCallback(callback))
+}
+
+func (r *RedisStore) SetNX(key, value string, ttlMillis int, callback LockCallback) error {
+	return r.client.Command([]interface{}{"SET", key, value, "NX", "PX", ttlMillis}, func(response resp.Value) {
+		if callback == nil {
+			return
+		}
+		if err := response.Error(); err != nil {
+			callback(false, err)
+			return
+		}
+		callback(!response.IsNull(), nil)
+	})
+}
+
+// DeleteByPrefix 在插件中按游标分页执行 SCAN，每页的 key 逐个用 DEL 删除后再请求下一页，不会在一个命令中遍历整个 key 空间
+// SCAN 只遍历当前连接的 redis 节点，使用 Redis Cluster 时只能删除该节点上的 key
+// 中途失败时回调中返回已经删除的 key 和错误
+func (r *RedisStore) DeleteByPrefix(prefix string, callback KeysCallback) error {
+	if callback == nil {
+		callback = func([]string, error) {}
+	}
+	pattern := redisGlobEscape(prefix) + "*"
+	var deleted []string
+	var scan func(cursor string) error
+	scan = func(cursor string) error {
+		return r.client.Command([]interface{}{"SCAN", cursor, "MATCH", pattern, "COUNT", redisScanCount}, func(response resp.Value) {
+			if err := response.Error(); err != nil {
+				callback(deleted, err)
+				return
+			}
+			result := response.Array()
+			if len(result) != 2 {
+				callback(deleted, errors.New("unexpected redis scan response"))
+				return
+			}
+			next := result[0].String()
+			var keys []string
+			for _, item := range result[1].Array() {
+				keys = append(keys, item.String())
+			}
+			r.deleteKeys(keys, func(err error) {
+				if err != nil {
+					callback(deleted, err)
+					return
+				}
+				deleted = append(deleted, keys...)
+				if next == "0" {
+					callback(deleted, nil)
+					return
+				}
+				if err := scan(next); err != nil {
+					callback(deleted, err)
+				}
+			})
+		})
+	}
+	return scan("0")
+}
+
+// deleteKeys 逐个删除 key，key 可能属于 Redis Cluster 的不同 slot，不能在一个 DEL 命令中删除
+func (r *RedisStore) deleteKeys(keys []string, callback ErrorCallback) {
+	if len(keys) == 0 {
+		callback(nil)
+		return
+	}
+	var firstErr error
+	pending := len(keys)
+	done := func(err error) {
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+		pending--
+		if pending == 0 {
+			callback(firstErr)
+		}
+	}
+	for _, key := range keys {
+		if err := r.Delete(key, done); err != nil {
+			done(err)
+		}
+	}
+}
+
+// redisGlobEscape 转义 SCAN 匹配模式中的特殊字符
+func redisGlobEscape(value string) string {
+	var builder strings.Builder
+	for _, c := range value {
+		if strings.ContainsRune(`*?[]\`, c) {
+			builder.WriteByte('\\')
+		}
+		builder.WriteRune(c)
+	}
+	return builder.String()
+}
+
+func redisErrorCallback(callback ErrorCallback) wrapper.RedisResponseCallback {
+	return func(response resp.Value) {
+		if callback != nil {
+			callback(response.Error())
+		}
+	}
+}
+
+func redisCounterCallback(callback CounterCallback) wrapper.RedisResponseCallback {
+	return func(response resp.Value) {
+		if callback == nil {
+			return
+		}
+		if err := response.Error(); err != nil {
+			callback(0, err)
+			return
+		}
+		value, err := strconv.ParseInt(response.String(), 10, 64)
+		callback(value, err)
+	}
+}
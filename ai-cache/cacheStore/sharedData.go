@@ -0,0 +1,285 @@
+// 基于 proxy-wasm shared data 的缓存存储，数据保存在网关进程内，同一个 vm_id 的所有 Wasm VM 共享
+// 不依赖任何外部服务，适合测试和小规模的网关；数据不会持久化，也不会在多个网关实例之间共享，并且没有淘汰机制
+// shared data 不支持过期和删除，每个 value 前面带有 8 字节的过期时间（unix 时间戳，为0时表示永不过期），删除时写入空 value，
+// 读取到已过期的 value 时同样写入空 value 释放其内存。key 本身一旦写入就一直占用内存直到网关进程退出，
+// 因此只适合 key 的数量有限的场景，例如 key 的取值范围固定的测试，或者开启了 cacheTTL 并且问题的种类有限的网关
+package cacheStore
+
+import (
+	"encoding/binary"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm"
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm/types"
+)
+
+const (
+	sharedDataExpireAtSize = 8
+	// CAS 冲突时的最大重试次数
+	sharedDataMaxCasRetries = 8
+)
+
+type sharedDataStoreInitializer struct {
+}
+
+func (s *sharedDataStoreInitializer) ValidateConfig(config StoreConfig) error {
+	return nil
+}
+
+func (s *sharedDataStoreInitializer) CreateStore(config StoreConfig) (CacheStore, error) {
+	return &SharedDataStore{}, nil
+}
+
+// SharedDataStore 的所有方法都在返回前同步调用回调
+type SharedDataStore struct {
+}
+
+func (s *SharedDataStore) GetStoreType() string {
+	return StoreTypeSharedData
+}
+
+func (s *SharedDataStore) Get(key string, callback ValueCallback) error {
+	value, found, _, err := s.load(key)
+	if callback != nil {
+		callback(value, found, err)
+	}
+	return nil
+}
+
+func (s *SharedDataStore) MGet(keys []string, callback ValuesCallback) error {
+	values := make([]string, len(keys))
+	found := make([]bool, len(keys))
+	for i, key := range keys {
+		value, ok, _, err := s.load(key)
+		if err != nil {
+			if callback != nil {
+				callback(nil, nil, err)
+			}
+			return nil
+		}
+		values[i], found[i] = value, ok
+	}
+	if callback != nil {
+		callback(values, found, nil)
+	}
+	return nil
+}
+
+func (s *SharedDataStore) Set(key, value string, ttl int, callback ErrorCallback) error {
+	err := proxywasm.SetSharedData(key, encodeSharedData(value, expireAt(ttl)), 0)
+	if callback != nil {
+		callback(err)
+	}
+	return nil
+}
+
+func (s *SharedDataStore) Delete(key string, callback ErrorCallback) error {
+	err := proxywasm.SetSharedData(key, nil, 0)
+	if callback != nil {
+		callback(err)
+	}
+	return nil
+}
+
+func (s *SharedDataStore) Touch(key string, ttl int, callback ErrorCallback) error {
+	err := s.update(key, func(value string, found bool, _ int64) (string, int64, bool, error) {
+		return value, expireAt(ttl), found && ttl > 0, nil
+	})
+	if callback != nil {
+		callback(err)
+	}
+	return nil
+}
+
+func (s *SharedDataStore) Incr(key string, delta int64, callback CounterCallback) error {
+	var counter int64
+	err := s.update(key, func(value string, found bool, expireAt int64) (string, int64, bool, error) {
+		counter = 0
+		if found {
+			current, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return "", 0, false, errors.New("value is not an integer")
+			}
+			counter = current
+		}
+		counter += delta
+		return strconv.FormatInt(counter, 10), expireAt, true, nil
+	})
+	if callback != nil {
+		callback(counter, err)
+	}
+	return nil
+}
+
+func (s *SharedDataStore) GetFields(key string, callback FieldsCallback) error {
+	value, found, _, err := s.load(key)
+	fields := make(map[string]string)
+	if err == nil && found {
+		fields, err = decodeFields(value)
+	}
+	if callback != nil {
+		callback(fields, err)
+	}
+	return nil
+}
+
+func (s *SharedDataStore) SetFields(key string, fields map[string]string, ttl int, callback ErrorCallback) error {
+	return s.Set(key, encodeFields(fields), ttl, callback)
+}
+
+func (s *SharedDataStore) SetField(key, field, value string, callback ErrorCallback) error {
+	err := s.updateFields(key, func(fields map[string]string) error {
+		fields[field] = value
+		return nil
+	})
+	if callback != nil {
+		callback(err)
+	}
+	return nil
+}
+
+func (s *SharedDataStore) IncrField(key, field string, delta int64, callback CounterCallback) error {
+	var counter int64
+	err := s.updateFields(key, func(fields map[string]string) error {
+		counter = 0
+		if value, ok := fields[field]; ok {
+			current, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return errors.New("field is not an integer")
+			}
+			counter = current
+		}
+		counter += delta
+		fields[field] = strconv.FormatInt(counter, 10)
+		return nil
+	})
+	if callback != nil {
+		callback(counter, err)
+	}
+	return nil
+}
+
+func (s *SharedDataStore) RecordHit(key, countField, timeField string, timestamp int64, callback ErrorCallback) error {
+	err := s.update(key, func(value string, found bool, expireAt int64) (string, int64, bool, error) {
+		if !found {
+			return "", 0, false, nil
+		}
+		fields, err := decodeFields(value)
+		if err != nil {
+			return "", 0, false, err
+		}
+		count, _ := strconv.ParseInt(fields[countField], 10, 64)
+		fields[countField] = strconv.FormatInt(count+1, 10)
+		fields[timeField] = strconv.FormatInt(timestamp, 10)
+		return encodeFields(fields), expireAt, true, nil
+	})
+	if callback != nil {
+		callback(err)
+	}
+	return nil
+}
+
+// SetNX 的过期时间按秒向上取整
+func (s *SharedDataStore) SetNX(key, value string, ttlMillis int, callback LockCallback) error {
+	acquired := false
+	err := s.update(key, func(_ string, found bool, _ int64) (string, int64, bool, error) {
+		acquired = !found
+		return value, expireAt((ttlMillis + 999) / 1000), !found, nil
+	})
+	if callback != nil {
+		callback(acquired && err == nil, err)
+	}
+	return nil
+}
+
+// DeleteByPrefix 不支持，proxy-wasm 没有提供遍历 shared data 的接口
+func (s *SharedDataStore) DeleteByPrefix(prefix string, callback KeysCallback) error {
+	if callback != nil {
+		callback(nil, errors.New("sharedData cache store does not support deleting by prefix"))
+	}
+	return nil
+}
+
+func (s *SharedDataStore) updateFields(key string, modify func(fields map[string]string) error) error {
+	return s.update(key, func(value string, found bool, expireAt int64) (string, int64, bool, error) {
+		fields := make(map[string]string)
+		if found {
+			var err error
+			if fields, err = decodeFields(value); err != nil {
+				return "", 0, false, err
+			}
+		}
+		if err := modify(fields); err != nil {
+			return "", 0, false, err
+		}
+		return encodeFields(fields), expireAt, true, nil
+	})
+}
+
+// load 读取 value，返回的 cas 用于后续的原子更新
+func (s *SharedDataStore) load(key string) (string, bool, uint32, error) {
+	data, cas, err := proxywasm.GetSharedData(key)
+	if errors.Is(err, types.ErrorStatusNotFound) {
+		return "", false, 0, nil
+	}
+	if err != nil {
+		return "", false, 0, err
+	}
+	value, expireAt, ok := decodeSharedData(data)
+	if !ok {
+		return "", false, cas, nil
+	}
+	if expireAt > 0 && expireAt <= time.Now().Unix() {
+		// release the memory of the expired value, the key itself can not be removed
+		proxywasm.SetSharedData(key, nil, cas)
+		return "", false, 0, nil
+	}
+	return value, true, cas, nil
+}
+
+// update 基于 CAS 原子地修改 value，modify 返回 false 时不写入
+func (s *SharedDataStore) update(key string, modify func(value string, found bool, expireAt int64) (string, int64, bool, error)) error {
+	for i := 0; i < sharedDataMaxCasRetries; i++ {
+		data, cas, err := proxywasm.GetSharedData(key)
+		if err != nil && !errors.Is(err, types.ErrorStatusNotFound) {
+			return err
+		}
+		value, expireAt, found := decodeSharedData(data)
+		if found && expireAt > 0 && expireAt <= time.Now().Unix() {
+			value, expireAt, found = "", 0, false
+		}
+		updated, updatedExpireAt, write, err := modify(value, found, expireAt)
+		if err != nil || !write {
+			return err
+		}
+		err = proxywasm.SetSharedData(key, encodeSharedData(updated, updatedExpireAt), cas)
+		if !errors.Is(err, types.ErrorStatusCasMismatch) {
+			return err
+		}
+	}
+	return types.ErrorStatusCasMismatch
+}
+
+func expireAt(ttl int) int64 {
+	if ttl <= 0 {
+		return 0
+	}
+	return time.Now().Unix() + int64(ttl)
+}
+
+func encodeSharedData(value string, expireAt int64) []byte {
+	data := make([]byte, sharedDataExpireAtSize+len(value))
+	binary.BigEndian.PutUint64(data, uint64(expireAt))
+	copy(data[sharedDataExpireAtSize:], value)
+	return data
+}
+
+// decodeSharedData 解析 value 和过期时间，value 为空（已删除）时返回 false
+func decodeSharedData(data []byte) (string, int64, bool) {
+	if len(data) < sharedDataExpireAtSize {
+		return "", 0, false
+	}
+	return string(data[sharedDataExpireAtSize:]), int64(binary.BigEndian.Uint64(data)), true
+}
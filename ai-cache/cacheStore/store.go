@@ -0,0 +1,184 @@
+// 这个文件中定义缓存存储的接口和配置
+// 缓存条目、embedding 向量以及各种计数器都通过 CacheStore 读写，Redis 只是其中一种实现
+// 所有方法都通过回调返回结果，回调可以为 nil；部分实现（例如 sharedData）会在方法返回前同步调用回调
+package cacheStore
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/tidwall/gjson"
+)
+
+const (
+	StoreTypeRedis      = "redis"
+	StoreTypeSharedData = "sharedData"
+	StoreTypeHTTP       = "http"
+)
+
+type storeInitializer interface {
+	ValidateConfig(StoreConfig) error
+	CreateStore(StoreConfig) (CacheStore, error)
+}
+
+var (
+	storeInitializers = map[string]storeInitializer{
+		StoreTypeRedis:      &redisStoreInitializer{},
+		StoreTypeSharedData: &sharedDataStoreInitializer{},
+		StoreTypeHTTP:       &httpStoreInitializer{},
+	}
+)
+
+type ErrorCallback func(err error)
+
+// ValueCallback 中 found 为 false 表示 key 不存在或已过期
+type ValueCallback func(value string, found bool, err error)
+
+// ValuesCallback 中 values 和 found 与请求中的 keys 一一对应
+type ValuesCallback func(values []string, found []bool, err error)
+
+// FieldsCallback 中 fields 为空表示 key 不存在或已过期
+type FieldsCallback func(fields map[string]string, err error)
+
+type CounterCallback func(value int64, err error)
+
+// LockCallback 中 acquired 为 false 表示 key 已经存在
+type LockCallback func(acquired bool, err error)
+
+type KeysCallback func(keys []string, err error)
+
+// CacheStore 定义缓存存储需要提供的能力，ttl 的单位是秒，为0时表示永不过期
+type CacheStore interface {
+	GetStoreType() string
+	Get(key string, callback ValueCallback) error
+	MGet(keys []string, callback ValuesCallback) error
+	Set(key, value string, ttl int, callback ErrorCallback) error
+	Delete(key string, callback ErrorCallback) error
+	// Touch 重新设置 key 的过期时间，ttl 为0时不做任何修改
+	Touch(key string, ttl int, callback ErrorCallback) error
+	// Incr 原子地增加计数器的值，key 不存在时从0开始计数
+	Incr(key string, delta int64, callback CounterCallback) error
+	// GetFields 读取一个由多个字段组成的条目
+	GetFields(key string, callback FieldsCallback) error
+	// SetFields 使用给定的字段替换整个条目
+	SetFields(key string, fields map[string]string, ttl int, callback ErrorCallback) error
+	SetField(key, field, value string, callback ErrorCallback) error
+	// IncrField 原子地增加条目中某个字段的值
+	IncrField(key, field string, delta int64, callback CounterCallback) error
+	// RecordHit 只在条目存在时原子地将 countField 加一并将 timeField 设置为 timestamp，条目不存在时不做修改，避免重新创建已经过期的条目
+	RecordHit(key, countField, timeField string, timestamp int64, callback ErrorCallback) error
+	// SetNX 只在 key 不存在时写入，ttlMillis 的单位是毫秒，用于实现短期的锁
+	SetNX(key, value string, ttlMillis int, callback LockCallback) error
+	// DeleteByPrefix 删除所有以 prefix 开头的 key，回调中返回删除的 key，中途失败时同时返回已经删除的 key 和错误，用于按前缀或命名空间清空缓存
+	DeleteByPrefix(prefix string, callback KeysCallback) error
+}
+
+type RedisConfig struct {
+	// @Title zh-CN redis 服务名称
+	// @Description zh-CN 带服务类型的完整 FQDN 名称，例如 my-redis.dns、redis.my-ns.svc.cluster.local
+	ServiceName string `required:"true" yaml:"serviceName" json:"serviceName"`
+	// @Title zh-CN redis 服务端口
+	// @Description zh-CN 默认值为6379
+	ServicePort int `required:"false" yaml:"servicePort" json:"servicePort"`
+	// @Title zh-CN 用户名
+	// @Description zh-CN 登陆 redis 的用户名，非必填
+	Username string `required:"false" yaml:"username" json:"username"`
+	// @Title zh-CN 密码
+	// @Description zh-CN 登陆 redis 的密码，非必填，可以只填密码
+	Password string `required:"false" yaml:"password" json:"password"`
+	// @Title zh-CN 请求超时
+	// @Description zh-CN 请求 redis 的超时时间，单位为毫秒。默认值是1000，即1秒
+	Timeout int `required:"false" yaml:"timeout" json:"timeout"`
+}
+
+func (c *RedisConfig) FromJson(json gjson.Result) {
+	c.ServiceName = json.Get("serviceName").String()
+	c.ServicePort = int(json.Get("servicePort").Int())
+	if c.ServicePort == 0 {
+		if strings.HasSuffix(c.ServiceName, ".static") {
+			// use default logic port which is 80 for static service
+			c.ServicePort = 80
+		} else {
+			c.ServicePort = 6379
+		}
+	}
+	c.Username = json.Get("username").String()
+	c.Password = json.Get("password").String()
+	c.Timeout = int(json.Get("timeout").Int())
+	if c.Timeout == 0 {
+		c.Timeout = 1000
+	}
+}
+
+type HTTPConfig struct {
+	// @Title zh-CN 键值服务名称
+	// @Description zh-CN 带服务类型的完整 FQDN 名称，例如 kv.my-ns.svc.cluster.local
+	ServiceName string `required:"true" yaml:"serviceName" json:"serviceName"`
+	// @Title zh-CN 键值服务端口
+	// @Description zh-CN 默认值为80
+	ServicePort int `required:"false" yaml:"servicePort" json:"servicePort"`
+	// @Title zh-CN 请求路径前缀
+	// @Description zh-CN key 经过 URL 编码后拼接在路径前缀之后，默认值为 /
+	BasePath string `required:"false" yaml:"basePath" json:"basePath"`
+	// @Title zh-CN 请求超时
+	// @Description zh-CN 单位为毫秒，默认值是1000
+	Timeout int `required:"false" yaml:"timeout" json:"timeout"`
+	// @Title zh-CN 请求头
+	// @Description zh-CN 每个请求都会携带的请求头，例如用于鉴权的 Authorization
+	Headers map[string]string `required:"false" yaml:"headers" json:"headers"`
+}
+
+func (c *HTTPConfig) FromJson(json gjson.Result) {
+	c.ServiceName = json.Get("serviceName").String()
+	c.ServicePort = int(json.Get("servicePort").Int())
+	if c.ServicePort == 0 {
+		c.ServicePort = 80
+	}
+	c.BasePath = json.Get("basePath").String()
+	if !strings.HasSuffix(c.BasePath, "/") {
+		c.BasePath += "/"
+	}
+	c.Timeout = int(json.Get("timeout").Int())
+	if c.Timeout == 0 {
+		c.Timeout = 1000
+	}
+	c.Headers = make(map[string]string)
+	for name, value := range json.Get("headers").Map() {
+		c.Headers[name] = value.String()
+	}
+}
+
+type StoreConfig struct {
+	// @Title zh-CN 缓存存储类型
+	// @Description zh-CN 可选值为 redis、sharedData 和 http，默认值为 redis
+	Type string `required:"false" yaml:"type" json:"type"`
+	// @Title zh-CN HTTP 键值服务
+	// @Description zh-CN type 为 http 时使用
+	HTTP HTTPConfig `required:"false" yaml:"http" json:"http"`
+	// Redis 的配置位于插件配置的 redis 字段，以兼容旧的配置
+	Redis RedisConfig `yaml:"-" json:"-"`
+}
+
+func (c *StoreConfig) FromJson(json gjson.Result) {
+	c.Type = json.Get("type").String()
+	if c.Type == "" {
+		c.Type = StoreTypeRedis
+	}
+	c.HTTP.FromJson(json.Get("http"))
+}
+
+func (c *StoreConfig) Validate() error {
+	initializer, has := storeInitializers[c.Type]
+	if !has {
+		return errors.New("unknown cache store type: " + c.Type)
+	}
+	return initializer.ValidateConfig(*c)
+}
+
+func CreateStore(config StoreConfig) (CacheStore, error) {
+	initializer, has := storeInitializers[config.Type]
+	if !has {
+		return nil, errors.New("unknown cache store type: " + config.Type)
+	}
+	return initializer.CreateStore(config)
+}
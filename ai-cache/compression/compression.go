@@ -0,0 +1,119 @@
+// 这个文件中实现缓存 value 的压缩
+// 压缩后的 value 以标记字节和算法字节开头，读取时根据标记判断是否需要解压，因此压缩和未压缩的 value 可以共存，修改配置后无需清空缓存
+// 未压缩的 value 以标记字节开头时，无论长度是否达到阈值都会被压缩，保证读取时不会被误判
+package compression
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+
+	"github.com/tidwall/gjson"
+)
+
+const (
+	AlgorithmNone = "none"
+	AlgorithmGzip = "gzip"
+
+	DefaultMinSize = 1024
+
+	// 压缩后 value 的第一个字节，文本内容中不会出现
+	compressedMarker byte = 0x00
+	// 压缩后 value 的第二个字节，标识使用的压缩算法
+	gzipAlgorithmID byte = 0x01
+)
+
+type Config struct {
+	// @Title zh-CN 压缩算法
+	// @Description zh-CN 可选值为 none 和 gzip，默认值为 none，即不压缩
+	Algorithm string `required:"false" yaml:"algorithm" json:"algorithm"`
+	// @Title zh-CN 压缩阈值
+	// @Description zh-CN 单位是字节，长度小于阈值的 value 不压缩，默认值为1024
+	MinSize int `required:"false" yaml:"minSize" json:"minSize"`
+	// @Title zh-CN 压缩级别
+	// @Description zh-CN 取值范围为1到9，数值越大压缩率越高、耗时越长，默认值为6
+	Level int `required:"false" yaml:"level" json:"level"`
+}
+
+func (c *Config) FromJson(json gjson.Result) {
+	c.Algorithm = json.Get("algorithm").String()
+	if c.Algorithm == "" {
+		c.Algorithm = AlgorithmNone
+	}
+	c.MinSize = DefaultMinSize
+	if minSize := json.Get("minSize"); minSize.Exists() {
+		c.MinSize = int(minSize.Int())
+	}
+	c.Level = int(json.Get("level").Int())
+	if c.Level == 0 {
+		c.Level = gzip.DefaultCompression
+	}
+}
+
+func (c *Config) Validate() error {
+	if c.Algorithm != AlgorithmNone && c.Algorithm != AlgorithmGzip {
+		return errors.New("unknown compression algorithm: " + c.Algorithm)
+	}
+	if c.MinSize < 0 {
+		return errors.New("compression minSize must not be negative")
+	}
+	if c.Level != gzip.DefaultCompression && (c.Level < gzip.BestSpeed || c.Level > gzip.BestCompression) {
+		return errors.New("compression level must be between 1 and 9")
+	}
+	return nil
+}
+
+// Compressor 按配置压缩 value，创建后可以被多个请求共享
+type Compressor struct {
+	config Config
+}
+
+func NewCompressor(config Config) *Compressor {
+	return &Compressor{config: config}
+}
+
+// Compress 返回写入缓存的 value 以及是否进行了压缩，压缩后没有变小时返回原始的 value
+func (c *Compressor) Compress(value string) (string, bool) {
+	mustCompress := len(value) > 0 && value[0] == compressedMarker
+	if !mustCompress && (c.config.Algorithm == AlgorithmNone || len(value) < c.config.MinSize) {
+		return value, false
+	}
+	var buffer bytes.Buffer
+	buffer.WriteByte(compressedMarker)
+	buffer.WriteByte(gzipAlgorithmID)
+	writer, err := gzip.NewWriterLevel(&buffer, c.config.Level)
+	if err != nil {
+		return value, false
+	}
+	if _, err = writer.Write([]byte(value)); err != nil {
+		return value, false
+	}
+	if err = writer.Close(); err != nil {
+		return value, false
+	}
+	if !mustCompress && buffer.Len() >= len(value) {
+		return value, false
+	}
+	return buffer.String(), true
+}
+
+// Decompress 解析缓存中的 value，未压缩的 value 原样返回
+func Decompress(value string) (string, error) {
+	if len(value) == 0 || value[0] != compressedMarker {
+		return value, nil
+	}
+	if len(value) < 2 || value[1] != gzipAlgorithmID {
+		return "", errors.New("unknown compressed value format")
+	}
+	reader, err := gzip.NewReader(bytes.NewReader([]byte(value[2:])))
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close()
+	decompressed, err := io.ReadAll(reader)
+	if err != nil {
+		return "", err
+	}
+	return string(decompressed), nil
+}
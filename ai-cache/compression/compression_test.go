@@ -0,0 +1,62 @@
+package compression
+
+import (
+	"compress/gzip"
+	"strings"
+	"testing"
+)
+
+func TestCompressRoundTrip(t *testing.T) {
+	long := strings.Repeat("缓存的回答 cached answer ", 200)
+	tests := []struct {
+		name           string
+		algorithm      string
+		minSize        int
+		value          string
+		wantCompressed bool
+	}{
+		{name: "empty", algorithm: AlgorithmGzip, minSize: 0, value: "", wantCompressed: false},
+		{name: "below min size", algorithm: AlgorithmGzip, minSize: 1024, value: "short answer", wantCompressed: false},
+		{name: "long value", algorithm: AlgorithmGzip, minSize: 1024, value: long, wantCompressed: true},
+		{name: "algorithm none", algorithm: AlgorithmNone, minSize: 0, value: long, wantCompressed: false},
+		{name: "incompressible value is kept", algorithm: AlgorithmGzip, minSize: 0, value: "ab", wantCompressed: false},
+		{name: "value starting with the marker is always compressed", algorithm: AlgorithmGzip, minSize: 1024, value: "\x00\x01raw", wantCompressed: true},
+		{name: "marker with algorithm none", algorithm: AlgorithmNone, minSize: 1024, value: "\x00", wantCompressed: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stored, compressed := NewCompressor(Config{Algorithm: tt.algorithm, MinSize: tt.minSize, Level: gzip.DefaultCompression}).Compress(tt.value)
+			if compressed != tt.wantCompressed {
+				t.Fatalf("Compress() compressed = %v, want %v", compressed, tt.wantCompressed)
+			}
+			if !compressed && stored != tt.value {
+				t.Fatalf("Compress() changed an uncompressed value: %q", stored)
+			}
+			got, err := Decompress(stored)
+			if err != nil {
+				t.Fatalf("Decompress() error = %v", err)
+			}
+			if got != tt.value {
+				t.Fatalf("Decompress() = %q, want %q", got, tt.value)
+			}
+		})
+	}
+}
+
+func TestDecompressInvalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+	}{
+		{name: "marker only", value: "\x00"},
+		{name: "unknown algorithm", value: "\x00\x02data"},
+		{name: "corrupted gzip", value: "\x00\x01not gzip"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Decompress(tt.value); err == nil {
+				t.Fatalf("Decompress(%q) error = nil, want an error", tt.value)
+			}
+		})
+	}
+}
@@ -0,0 +1,51 @@
+package config
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/tidwall/gjson"
+)
+
+type AdminConfig struct {
+	// @Title zh-CN 管理接口的路径前缀
+	// @Description zh-CN 请求路径以该前缀开头时由插件处理，不会转发到上游，为空时不开启管理接口，例如 /ai-cache/admin
+	Path string `required:"false" yaml:"path" json:"path"`
+	// @Title zh-CN 管理接口的访问令牌
+	// @Description zh-CN 开启管理接口时必填，请求需要携带 Authorization: Bearer <token> 请求头
+	Token string `required:"false" yaml:"token" json:"token"`
+}
+
+func (c *AdminConfig) FromJson(json gjson.Result) {
+	c.Path = strings.TrimRight(json.Get("path").String(), "/")
+	c.Token = json.Get("token").String()
+}
+
+func (c *AdminConfig) Validate() error {
+	if c.Path == "" {
+		return nil
+	}
+	if !strings.HasPrefix(c.Path, "/") {
+		return errors.New("admin path must start with '/'")
+	}
+	if c.Token == "" {
+		return errors.New("admin token must not be empty when admin path is set")
+	}
+	return nil
+}
+
+// Enabled 返回是否开启管理接口
+func (c *AdminConfig) Enabled() bool {
+	return c.Path != ""
+}
+
+// Matches 返回请求路径是否属于管理接口，路径中的查询参数不参与匹配
+func (c *AdminConfig) Matches(path string) bool {
+	if !c.Enabled() {
+		return false
+	}
+	if i := strings.Index(path, "?"); i >= 0 {
+		path = path[:i]
+	}
+	return path == c.Path || strings.HasPrefix(path, c.Path+"/")
+}
@@ -0,0 +1,38 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/tidwall/gjson"
+)
+
+func TestAdminConfigMatches(t *testing.T) {
+	var c AdminConfig
+	c.FromJson(gjson.Parse(`{"path":"/ai-cache/admin/","token":"secret"}`))
+	if err := c.Validate(); err != nil {
+		t.Fatalf("Validate() = %v", err)
+	}
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{path: "/ai-cache/admin", want: true},
+		{path: "/ai-cache/admin/lookup?query=hello", want: true},
+		{path: "/ai-cache/admin?x=1", want: true},
+		{path: "/ai-cache/administrator"},
+		{path: "/v1/chat/completions"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			if got := c.Matches(tt.path); got != tt.want {
+				t.Fatalf("Matches(%q) = %v, want %v", tt.path, got, tt.want)
+			}
+		})
+	}
+	if (&AdminConfig{}).Matches("/ai-cache/admin") {
+		t.Fatal("Matches() = true when the admin API is disabled")
+	}
+	if err := (&AdminConfig{Path: "/admin"}).Validate(); err == nil {
+		t.Fatal("Validate() = nil without a token")
+	}
+}
@@ -1 +1,394 @@
 package config
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/alibaba/higress/plugins/wasm-go/extensions/ai-cache/cacheStore"
+	"github.com/alibaba/higress/plugins/wasm-go/extensions/ai-cache/compression"
+	"github.com/alibaba/higress/plugins/wasm-go/extensions/ai-cache/l1cache"
+	"github.com/alibaba/higress/plugins/wasm-go/extensions/ai-cache/normalizer"
+	"github.com/alibaba/higress/plugins/wasm-go/extensions/ai-cache/protocol"
+	textEmbeddingProvider "github.com/alibaba/higress/plugins/wasm-go/extensions/ai-cache/textEmbeddingProvider"
+	vectorStoreProvider "github.com/alibaba/higress/plugins/wasm-go/extensions/ai-cache/vectorStoreProvider"
+	"github.com/alibaba/higress/plugins/wasm-go/pkg/wrapper"
+	"github.com/tidwall/gjson"
+)
+
+const (
+	DefaultCacheKeyPrefix    = "higressAiCache"
+	DefaultCacheKeyNamespace = "default"
+	// 向量检索返回的距离小于该值时认为语义相似
+	DefaultSimilarityThreshold = 0.1
+
+	DefaultReturnResponseTemplate       = `{"id":"from-cache","choices":[{"index":0,"message":{"role":"assistant","content":"%s"},"finish_reason":"stop"}],"model":"gpt-4o","object":"chat.completion","usage":{"prompt_tokens":0,"completion_tokens":0,"total_tokens":0}}`
+	DefaultReturnStreamResponseTemplate = `data:{"id":"from-cache","choices":[{"index":0,"delta":{"role":"assistant","content":"%s"},"finish_reason":"stop"}],"model":"gpt-4o","object":"chat.completion.chunk","usage":{"prompt_tokens":0,"completion_tokens":0,"total_tokens":0}}` + "\n\ndata:[DONE]\n\n"
+)
+
+type OrphanSweepConfig struct {
+	// @Title zh-CN 是否开启孤儿向量的定期清理
+	// @Description zh-CN 默认值为 false
+	Enabled bool `required:"false" yaml:"enabled" json:"enabled"`
+	// @Title zh-CN 清理间隔
+	// @Description zh-CN 单位为毫秒，默认值是60000，即1分钟
+	Interval int `required:"false" yaml:"interval" json:"interval"`
+	// @Title zh-CN 每次检查的向量数
+	// @Description zh-CN 默认值是100
+	BatchSize int `required:"false" yaml:"batchSize" json:"batchSize"`
+}
+
+func (c *OrphanSweepConfig) FromJson(json gjson.Result) {
+	c.Enabled = json.Get("enabled").Bool()
+	c.Interval = int(json.Get("interval").Int())
+	if c.Interval == 0 {
+		c.Interval = 60000
+	}
+	c.BatchSize = int(json.Get("batchSize").Int())
+	if c.BatchSize == 0 {
+		c.BatchSize = 100
+	}
+}
+
+type SingleFlightConfig struct {
+	// @Title zh-CN 是否合并相同问题的并发未命中请求
+	// @Description zh-CN 默认值为 false
+	Enabled bool `required:"false" yaml:"enabled" json:"enabled"`
+	// @Title zh-CN 锁的过期时间
+	// @Description zh-CN 持有锁的请求在这段时间内没有写入回答时，等待的请求转发到上游。单位为毫秒，默认值是30000
+	LockTTL int `required:"false" yaml:"lockTTL" json:"lockTTL"`
+	// @Title zh-CN 最长等待时间
+	// @Description zh-CN 超过后等待的请求转发到上游。单位为毫秒，默认值是30000
+	WaitTimeout int `required:"false" yaml:"waitTimeout" json:"waitTimeout"`
+	// @Title zh-CN 轮询间隔
+	// @Description zh-CN 等待的请求检查回答是否写入的间隔，单位为毫秒，默认值是100
+	PollInterval int `required:"false" yaml:"pollInterval" json:"pollInterval"`
+}
+
+func (c *SingleFlightConfig) FromJson(json gjson.Result) {
+	c.Enabled = json.Get("enabled").Bool()
+	c.LockTTL = int(json.Get("lockTTL").Int())
+	if c.LockTTL == 0 {
+		c.LockTTL = 30000
+	}
+	c.WaitTimeout = int(json.Get("waitTimeout").Int())
+	if c.WaitTimeout == 0 {
+		c.WaitTimeout = 30000
+	}
+	c.PollInterval = int(json.Get("pollInterval").Int())
+	if c.PollInterval == 0 {
+		c.PollInterval = 100
+	}
+}
+
+func (c *SingleFlightConfig) Validate() error {
+	if c.LockTTL < 0 || c.WaitTimeout < 0 || c.PollInterval < 0 {
+		return errors.New("singleFlight lockTTL, waitTimeout and pollInterval must not be negative")
+	}
+	return nil
+}
+
+type KVExtractor struct {
+	// @Title zh-CN 从请求 Body 中基于 [GJSON PATH](https://github.com/tidwall/gjson/blob/master/SYNTAX.md) 语法提取字符串
+	RequestBody string `required:"false" yaml:"requestBody" json:"requestBody"`
+	// @Title zh-CN 从响应 Body 中基于 [GJSON PATH](https://github.com/tidwall/gjson/blob/master/SYNTAX.md) 语法提取字符串
+	ResponseBody string `required:"false" yaml:"responseBody" json:"responseBody"`
+}
+
+type PluginConfig struct {
+	// @Title zh-CN 文本向量化服务
+	// @Description zh-CN 用于将 query 转换为向量的服务配置
+	EmbeddingProviderConfig textEmbeddingProvider.ProviderConfig `required:"true" yaml:"embeddingProvider" json:"embeddingProvider"`
+	// @Title zh-CN 向量存储服务
+	// @Description zh-CN 用于存储和检索 query 向量的服务配置
+	VectorBaseProviderConfig vectorStoreProvider.ProviderConfig `required:"true" yaml:"vectorBaseProvider" json:"vectorBaseProvider"`
+	// @Title zh-CN 孤儿向量的定期清理
+	// @Description zh-CN 定期抽样检查向量数据库中的向量，删除回答已经不存在的向量
+	OrphanSweepConfig OrphanSweepConfig `required:"false" yaml:"orphanSweep" json:"orphanSweep"`
+	// @Title zh-CN 并发未命中请求的合并
+	// @Description zh-CN 第一个未命中的请求持有锁并转发到上游，其余相同问题的请求等待回答写入后直接从缓存返回
+	SingleFlightConfig SingleFlightConfig `required:"false" yaml:"singleFlight" json:"singleFlight"`
+	// @Title zh-CN Redis 地址信息
+	// @Description zh-CN 用于存储缓存结果的 Redis 地址
+	RedisConfig cacheStore.RedisConfig `required:"false" yaml:"redis" json:"redis"`
+	// @Title zh-CN 缓存存储
+	// @Description zh-CN 用于存储缓存条目的后端，默认使用 redis 字段配置的 Redis
+	CacheStoreConfig cacheStore.StoreConfig `required:"false" yaml:"cacheStore" json:"cacheStore"`
+	// @Title zh-CN 进程内一级缓存
+	// @Description zh-CN 位于缓存存储之前，保存最近命中或写入的缓存条目，默认不开启
+	L1CacheConfig l1cache.Config `required:"false" yaml:"l1Cache" json:"l1Cache"`
+	// @Title zh-CN 请求使用的协议
+	// @Description zh-CN 可选值为 auto、openai、anthropic、gemini、dashscope、openai-completions、openai-responses，默认值为 auto，即根据请求路径自动选择，无法识别时按 openai 协议处理
+	Protocol string `required:"false" yaml:"protocol" json:"protocol"`
+	// @Title zh-CN 缓存 key 的来源
+	// @Description zh-CN 往 redis 里存时，使用的 key 的提取方式
+	CacheKeyFrom KVExtractor `required:"true" yaml:"cacheKeyFrom" json:"cacheKeyFrom"`
+	// @Title zh-CN 缓存 value 的来源
+	// @Description zh-CN 往 redis 里存时，使用的 value 的提取方式
+	CacheValueFrom KVExtractor `required:"true" yaml:"cacheValueFrom" json:"cacheValueFrom"`
+	// @Title zh-CN 流式响应下，缓存 value 的来源
+	// @Description zh-CN 往 redis 里存时，使用的 value 的提取方式
+	CacheStreamValueFrom KVExtractor `required:"true" yaml:"cacheStreamValueFrom" json:"cacheStreamValueFrom"`
+	// @Title zh-CN 返回 HTTP 响应的模版
+	// @Description zh-CN 非流式请求命中缓存时使用，用 %s 标记需要被 cache value 替换的部分
+	ReturnResponseTemplate string `required:"true" yaml:"returnResponseTemplate" json:"returnResponseTemplate"`
+	// @Title zh-CN 返回流式 HTTP 响应的模版
+	// @Description zh-CN 流式请求命中缓存时使用，用 %s 标记需要被 cache value 替换的部分
+	ReturnStreamResponseTemplate string `required:"true" yaml:"returnStreamResponseTemplate" json:"returnStreamResponseTemplate"`
+	// @Title zh-CN 文本规范化
+	// @Description zh-CN 在精确匹配查询和向量化之前对提取出的缓存 key 执行的规范化步骤，默认不执行任何规范化
+	NormalizationConfig normalizer.Config `required:"false" yaml:"normalization" json:"normalization"`
+	// @Title zh-CN 是否缓存工具调用结果
+	// @Description zh-CN 开启后会缓存包含 tool_calls 或 function_call 的响应，同时请求中的 tools 定义会参与缓存 key 的计算。默认值为 false
+	CacheToolCalls bool `required:"false" yaml:"cacheToolCalls" json:"cacheToolCalls"`
+	// @Title zh-CN 是否缓存 embeddings 接口
+	// @Description zh-CN 开启后对路径以 /embeddings 结尾的 OpenAI 风格请求按模型和每个 input 做精确匹配缓存。默认值为 false
+	CacheEmbeddings bool `required:"false" yaml:"cacheEmbeddings" json:"cacheEmbeddings"`
+	// @Title zh-CN 是否缓存查询文本的向量化结果
+	// @Description zh-CN 开启后按向量化服务、模型和规范化后的文本缓存向量化结果，相同的文本再次未命中时不需要重新调用文本向量化接口。默认值为 false
+	CacheQueryEmbeddings bool `required:"false" yaml:"cacheQueryEmbeddings" json:"cacheQueryEmbeddings"`
+	// @Title zh-CN 查询文本向量化结果的过期时间
+	// @Description zh-CN 单位是秒，默认值为0，即永不过期
+	QueryEmbeddingCacheTTL int `required:"false" yaml:"queryEmbeddingCacheTTL" json:"queryEmbeddingCacheTTL"`
+	// @Title zh-CN 是否在缓存条目中保存完整的响应
+	// @Description zh-CN 开启后缓存条目中会额外保存上游返回的原始响应 Body，流式响应保存全部 SSE 事件，用于排查和审计。默认值为 false
+	CacheFullResponse bool `required:"false" yaml:"cacheFullResponse" json:"cacheFullResponse"`
+	// @Title zh-CN 缓存 value 的压缩
+	// @Description zh-CN 对缓存条目中较大的字段进行压缩，默认不压缩
+	CompressionConfig compression.Config `required:"false" yaml:"compression" json:"compression"`
+	// @Title zh-CN 缓存的过期时间
+	// @Description zh-CN 单位是秒，默认值为0，即永不过期
+	CacheTTL int `required:"false" yaml:"cacheTTL" json:"cacheTTL"`
+	// @Title zh-CN 缓存的软过期时间
+	// @Description zh-CN 超过软过期时间的条目仍会返回，但会被标记为过期（x-ai-cache-status: stale），并由一个请求绕过缓存刷新。单位是秒，默认值为0，即不开启；开启 cacheTTL 时必须小于 cacheTTL
+	CacheSoftTTL int `required:"false" yaml:"cacheSoftTTL" json:"cacheSoftTTL"`
+	// @Title zh-CN 提前刷新的系数
+	// @Description zh-CN 越大越倾向于在软过期之前提前刷新，默认值为1
+	RefreshBeta float64 `required:"false" yaml:"refreshBeta" json:"refreshBeta"`
+	// @Title zh-CN 清理过期向量的间隔
+	// @Description zh-CN 向量数据库不支持原生过期且 cacheTTL 大于0时，定期删除已过期的向量，单位为毫秒，默认值是60000，即1分钟
+	VectorCleanupInterval int `required:"false" yaml:"vectorCleanupInterval" json:"vectorCleanupInterval"`
+	// @Title zh-CN Redis缓存Key的前缀
+	// @Description zh-CN 默认值是"higressAiCache"
+	CacheKeyPrefix string `required:"false" yaml:"cacheKeyPrefix" json:"cacheKeyPrefix"`
+	// @Title zh-CN Redis缓存Key的命名空间
+	// @Description zh-CN 位于前缀和版本之后，用于隔离共享同一个前缀的不同业务，默认值是"default"
+	CacheKeyNamespace string `required:"false" yaml:"cacheKeyNamespace" json:"cacheKeyNamespace"`
+	// @Title zh-CN 语义相似的阈值
+	// @Description zh-CN 向量检索返回的距离小于该值时认为语义相似，默认值是0.1
+	SimilarityThreshold float64 `required:"false" yaml:"similarityThreshold" json:"similarityThreshold"`
+	// @Title zh-CN 按请求覆盖的缓存规则
+	// @Description zh-CN 按路由、域名、模型、请求路径或消费者覆盖缓存的过期时间、key 的前缀和命名空间、语义相似的阈值以及是否开启缓存，按顺序使用第一个匹配的规则
+	Rules []CacheRule `required:"false" yaml:"rules" json:"rules"`
+
+	// @Title zh-CN 多租户隔离
+	// @Description zh-CN 按租户隔离缓存条目和向量，不配置时所有请求共享同一个 key 空间
+	TenantConfig TenantConfig `required:"false" yaml:"tenant" json:"tenant"`
+
+	// @Title zh-CN 缓存管理接口
+	// @Description zh-CN 由插件直接处理的 HTTP 接口，用于查询、删除、清空和写入缓存条目以及查看统计信息，不配置时不开启
+	AdminConfig AdminConfig `required:"false" yaml:"admin" json:"admin"`
+
+	// 匹配的规则关闭了缓存，只在按请求覆盖后的配置中设置
+	disabled bool
+	// 当前请求所属租户的标识摘要，只在按请求覆盖后的配置中设置，共享的命名空间中为空
+	tenant string
+
+	cacheStore        cacheStore.CacheStore          `yaml:"-" json:"-"`
+	protocolSelector  *protocol.Selector             `yaml:"-" json:"-"`
+	embeddingProvider textEmbeddingProvider.Provider `yaml:"-" json:"-"`
+	vectorProvider    vectorStoreProvider.Provider   `yaml:"-" json:"-"`
+	normalizer        *normalizer.Normalizer         `yaml:"-" json:"-"`
+	compressor        *compression.Compressor        `yaml:"-" json:"-"`
+	l1Cache           *l1cache.Cache                 `yaml:"-" json:"-"`
+}
+
+func (c *PluginConfig) FromJson(json gjson.Result) {
+	c.EmbeddingProviderConfig.FromJson(json.Get("embeddingProvider"))
+	c.VectorBaseProviderConfig.FromJson(json.Get("vectorBaseProvider"))
+	c.OrphanSweepConfig.FromJson(json.Get("orphanSweep"))
+	c.SingleFlightConfig.FromJson(json.Get("singleFlight"))
+	c.RedisConfig.FromJson(json.Get("redis"))
+	c.CacheStoreConfig.FromJson(json.Get("cacheStore"))
+	c.CacheStoreConfig.Redis = c.RedisConfig
+	c.L1CacheConfig.FromJson(json.Get("l1Cache"))
+
+	c.Protocol = json.Get("protocol").String()
+	c.CacheKeyFrom.RequestBody = json.Get("cacheKeyFrom.requestBody").String()
+	if c.CacheKeyFrom.RequestBody == "" {
+		c.CacheKeyFrom.RequestBody = "messages.@reverse.0.content"
+	}
+	c.CacheValueFrom.ResponseBody = json.Get("cacheValueFrom.responseBody").String()
+	if c.CacheValueFrom.ResponseBody == "" {
+		c.CacheValueFrom.ResponseBody = "choices.0.message.content"
+	}
+	c.CacheStreamValueFrom.ResponseBody = json.Get("cacheStreamValueFrom.responseBody").String()
+	if c.CacheStreamValueFrom.ResponseBody == "" {
+		c.CacheStreamValueFrom.ResponseBody = "choices.0.delta.content"
+	}
+	c.ReturnResponseTemplate = json.Get("returnResponseTemplate").String()
+	if c.ReturnResponseTemplate == "" {
+		c.ReturnResponseTemplate = DefaultReturnResponseTemplate
+	}
+	c.ReturnStreamResponseTemplate = json.Get("returnStreamResponseTemplate").String()
+	if c.ReturnStreamResponseTemplate == "" {
+		c.ReturnStreamResponseTemplate = DefaultReturnStreamResponseTemplate
+	}
+	c.NormalizationConfig.FromJson(json.Get("normalization"))
+	c.CacheToolCalls = json.Get("cacheToolCalls").Bool()
+	c.CacheEmbeddings = json.Get("cacheEmbeddings").Bool()
+	c.CacheQueryEmbeddings = json.Get("cacheQueryEmbeddings").Bool()
+	c.QueryEmbeddingCacheTTL = int(json.Get("queryEmbeddingCacheTTL").Int())
+	c.CacheFullResponse = json.Get("cacheFullResponse").Bool()
+	c.CompressionConfig.FromJson(json.Get("compression"))
+	c.CacheTTL = int(json.Get("cacheTTL").Int())
+	c.CacheSoftTTL = int(json.Get("cacheSoftTTL").Int())
+	c.RefreshBeta = 1
+	if refreshBeta := json.Get("refreshBeta"); refreshBeta.Exists() {
+		c.RefreshBeta = refreshBeta.Float()
+	}
+	c.VectorCleanupInterval = int(json.Get("vectorCleanupInterval").Int())
+	if c.VectorCleanupInterval == 0 {
+		c.VectorCleanupInterval = 60000
+	}
+	c.CacheKeyPrefix = json.Get("cacheKeyPrefix").String()
+	if c.CacheKeyPrefix == "" {
+		c.CacheKeyPrefix = DefaultCacheKeyPrefix
+	}
+	c.CacheKeyNamespace = json.Get("cacheKeyNamespace").String()
+	if c.CacheKeyNamespace == "" {
+		c.CacheKeyNamespace = DefaultCacheKeyNamespace
+	}
+	c.SimilarityThreshold = DefaultSimilarityThreshold
+	if threshold := json.Get("similarityThreshold"); threshold.Exists() {
+		c.SimilarityThreshold = threshold.Float()
+	}
+	c.TenantConfig.FromJson(json.Get("tenant"))
+	c.AdminConfig.FromJson(json.Get("admin"))
+	c.Rules = nil
+	for _, item := range json.Get("rules").Array() {
+		var rule CacheRule
+		rule.FromJson(item)
+		c.Rules = append(c.Rules, rule)
+	}
+}
+
+func (c *PluginConfig) Validate() error {
+	if err := c.CacheStoreConfig.Validate(); err != nil {
+		return err
+	}
+	if err := c.validateCachePolicy(); err != nil {
+		return err
+	}
+	for i := range c.Rules {
+		ruleConfig := c.Rules[i].apply(*c)
+		if err := ruleConfig.validateCachePolicy(); err != nil {
+			return errors.New("rules[" + strconv.Itoa(i) + "]: " + err.Error())
+		}
+	}
+	if !protocol.IsValidProtocolType(c.Protocol) {
+		return errors.New("unknown protocol: " + c.Protocol)
+	}
+	if err := c.NormalizationConfig.Validate(); err != nil {
+		return err
+	}
+	if err := c.CompressionConfig.Validate(); err != nil {
+		return err
+	}
+	if err := c.L1CacheConfig.Validate(); err != nil {
+		return err
+	}
+	if c.OrphanSweepConfig.Interval < 0 || c.OrphanSweepConfig.BatchSize < 0 {
+		return errors.New("orphanSweep interval and batchSize must not be negative")
+	}
+	if err := c.TenantConfig.Validate(); err != nil {
+		return err
+	}
+	if err := c.AdminConfig.Validate(); err != nil {
+		return err
+	}
+	if err := c.SingleFlightConfig.Validate(); err != nil {
+		return err
+	}
+	if c.RefreshBeta < 0 {
+		return errors.New("refreshBeta must not be negative")
+	}
+	if c.VectorCleanupInterval < 0 {
+		return errors.New("vectorCleanupInterval must not be negative")
+	}
+	if strings.Count(c.ReturnResponseTemplate, "%s") != 1 {
+		return errors.New("returnResponseTemplate must contain exactly one %s")
+	}
+	if strings.Count(c.ReturnStreamResponseTemplate, "%s") != 1 {
+		return errors.New("returnStreamResponseTemplate must contain exactly one %s")
+	}
+	return nil
+}
+
+func (c *PluginConfig) Complete(log wrapper.Log) error {
+	var err error
+	if c.EmbeddingProviderConfig.GetProviderType() != "" {
+		c.embeddingProvider, err = textEmbeddingProvider.CreateProvider(c.EmbeddingProviderConfig)
+		if err != nil {
+			return err
+		}
+	}
+	if c.VectorBaseProviderConfig.GetProviderType() != "" {
+		c.vectorProvider, err = vectorStoreProvider.CreateProvider(c.VectorBaseProviderConfig)
+		if err != nil {
+			return err
+		}
+	}
+	c.protocolSelector, err = protocol.NewSelector(c.Protocol, protocol.ProtocolConfig{
+		KeyFrom:                c.CacheKeyFrom.RequestBody,
+		ValueFrom:              c.CacheValueFrom.ResponseBody,
+		StreamValueFrom:        c.CacheStreamValueFrom.ResponseBody,
+		ResponseTemplate:       c.ReturnResponseTemplate,
+		StreamResponseTemplate: c.ReturnStreamResponseTemplate,
+	})
+	if err != nil {
+		return err
+	}
+	c.normalizer, err = normalizer.NewNormalizer(c.NormalizationConfig)
+	if err != nil {
+		return err
+	}
+	c.compressor = compression.NewCompressor(c.CompressionConfig)
+	if c.L1CacheConfig.Enabled {
+		c.l1Cache = l1cache.NewCache(c.L1CacheConfig)
+	}
+	c.cacheStore, err = cacheStore.CreateStore(c.CacheStoreConfig)
+	return err
+}
+
+func (c *PluginConfig) GetCacheStore() cacheStore.CacheStore {
+	return c.cacheStore
+}
+
+// GetEmbeddingProvider 返回文本向量化的服务提供者，未配置时返回 nil
+func (c *PluginConfig) GetEmbeddingProvider() textEmbeddingProvider.Provider {
+	return c.embeddingProvider
+}
+
+// GetVectorProvider 返回向量数据库的服务提供者，未配置时返回 nil
+func (c *PluginConfig) GetVectorProvider() vectorStoreProvider.Provider {
+	return c.vectorProvider
+}
+
+func (c *PluginConfig) GetNormalizer() *normalizer.Normalizer {
+	return c.normalizer
+}
+
+// GetL1Cache 返回进程内一级缓存，未开启时返回 nil
+func (c *PluginConfig) GetL1Cache() *l1cache.Cache {
+	return c.l1Cache
+}
+
+func (c *PluginConfig) GetCompressor() *compression.Compressor {
+	return c.compressor
+}
+
+// GetProtocol 返回请求路径对应的协议适配器
+func (c *PluginConfig) GetProtocol(path string) protocol.Protocol {
+	return c.protocolSelector.Select(path)
+}
@@ -0,0 +1,177 @@
+package config
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/tidwall/gjson"
+)
+
+// RuleMatch 定义规则的匹配条件，为空的条件不参与匹配，所有非空的条件都满足时规则才匹配
+type RuleMatch struct {
+	// @Title zh-CN 路由名称
+	// @Description zh-CN 与请求匹配的 Higress 路由名称完全相同时匹配
+	Route string `required:"false" yaml:"route" json:"route"`
+	// @Title zh-CN 域名
+	// @Description zh-CN 与请求的 Host 完全相同时匹配，以"*."开头时匹配所有子域名
+	Host string `required:"false" yaml:"host" json:"host"`
+	// @Title zh-CN 模型
+	// @Description zh-CN 与请求中的模型完全相同时匹配
+	Model string `required:"false" yaml:"model" json:"model"`
+	// @Title zh-CN 请求路径前缀
+	// @Description zh-CN 请求路径以该前缀开头时匹配
+	Path string `required:"false" yaml:"path" json:"path"`
+	// @Title zh-CN 消费者
+	// @Description zh-CN 与 Higress 认证插件设置的 x-mse-consumer 请求头完全相同时匹配
+	Consumer string `required:"false" yaml:"consumer" json:"consumer"`
+}
+
+// RequestAttributes 是参与规则匹配的请求属性
+type RequestAttributes struct {
+	Route    string
+	Host     string
+	Model    string
+	Path     string
+	Consumer string
+}
+
+func (m *RuleMatch) FromJson(json gjson.Result) {
+	m.Route = json.Get("route").String()
+	m.Host = json.Get("host").String()
+	m.Model = json.Get("model").String()
+	m.Path = json.Get("path").String()
+	m.Consumer = json.Get("consumer").String()
+}
+
+func (m *RuleMatch) Matches(attrs RequestAttributes) bool {
+	if m.Route != "" && m.Route != attrs.Route {
+		return false
+	}
+	if m.Host != "" && !matchHost(m.Host, attrs.Host) {
+		return false
+	}
+	if m.Model != "" && m.Model != attrs.Model {
+		return false
+	}
+	if m.Path != "" && !strings.HasPrefix(attrs.Path, m.Path) {
+		return false
+	}
+	if m.Consumer != "" && m.Consumer != attrs.Consumer {
+		return false
+	}
+	return true
+}
+
+func matchHost(pattern, host string) bool {
+	// the port is not part of the match
+	if i := strings.LastIndex(host, ":"); i >= 0 && !strings.Contains(host[i:], "]") {
+		host = host[:i]
+	}
+	if strings.HasPrefix(pattern, "*.") {
+		return strings.HasSuffix(host, pattern[1:])
+	}
+	return pattern == host
+}
+
+// CacheRule 按请求覆盖部分缓存配置，未设置的字段沿用插件的全局配置
+type CacheRule struct {
+	// @Title zh-CN 匹配条件
+	// @Description zh-CN 为空时匹配所有请求
+	Match RuleMatch `required:"false" yaml:"match" json:"match"`
+	// @Title zh-CN 是否开启缓存
+	// @Description zh-CN 为 false 时匹配的请求既不查询也不写入缓存
+	Enabled *bool `required:"false" yaml:"enabled" json:"enabled"`
+	// @Title zh-CN 缓存的过期时间
+	// @Description zh-CN 单位是秒，为0时永不过期
+	CacheTTL *int `required:"false" yaml:"cacheTTL" json:"cacheTTL"`
+	// @Title zh-CN 缓存的软过期时间
+	// @Description zh-CN 单位是秒，为0时不开启
+	CacheSoftTTL *int `required:"false" yaml:"cacheSoftTTL" json:"cacheSoftTTL"`
+	// @Title zh-CN Redis缓存Key的前缀
+	CacheKeyPrefix string `required:"false" yaml:"cacheKeyPrefix" json:"cacheKeyPrefix"`
+	// @Title zh-CN Redis缓存Key的命名空间
+	CacheKeyNamespace string `required:"false" yaml:"cacheKeyNamespace" json:"cacheKeyNamespace"`
+	// @Title zh-CN 语义相似的阈值
+	// @Description zh-CN 向量检索返回的距离小于该值时认为语义相似
+	SimilarityThreshold *float64 `required:"false" yaml:"similarityThreshold" json:"similarityThreshold"`
+}
+
+func (r *CacheRule) FromJson(json gjson.Result) {
+	r.Match.FromJson(json.Get("match"))
+	if enabled := json.Get("enabled"); enabled.Exists() {
+		value := enabled.Bool()
+		r.Enabled = &value
+	}
+	if cacheTTL := json.Get("cacheTTL"); cacheTTL.Exists() {
+		value := int(cacheTTL.Int())
+		r.CacheTTL = &value
+	}
+	if cacheSoftTTL := json.Get("cacheSoftTTL"); cacheSoftTTL.Exists() {
+		value := int(cacheSoftTTL.Int())
+		r.CacheSoftTTL = &value
+	}
+	r.CacheKeyPrefix = json.Get("cacheKeyPrefix").String()
+	r.CacheKeyNamespace = json.Get("cacheKeyNamespace").String()
+	if threshold := json.Get("similarityThreshold"); threshold.Exists() {
+		value := threshold.Float()
+		r.SimilarityThreshold = &value
+	}
+}
+
+// apply 返回用规则覆盖后的配置，c 本身不会被修改
+func (r *CacheRule) apply(c PluginConfig) PluginConfig {
+	if r.Enabled != nil {
+		c.disabled = !*r.Enabled
+	}
+	if r.CacheTTL != nil {
+		c.CacheTTL = *r.CacheTTL
+	}
+	if r.CacheSoftTTL != nil {
+		c.CacheSoftTTL = *r.CacheSoftTTL
+	}
+	if r.CacheKeyPrefix != "" {
+		c.CacheKeyPrefix = r.CacheKeyPrefix
+	}
+	if r.CacheKeyNamespace != "" {
+		c.CacheKeyNamespace = r.CacheKeyNamespace
+	}
+	if r.SimilarityThreshold != nil {
+		c.SimilarityThreshold = *r.SimilarityThreshold
+	}
+	return c
+}
+
+// ForRequest 返回按第一个匹配的规则覆盖后的配置，没有匹配的规则时返回全局配置
+func (c *PluginConfig) ForRequest(attrs RequestAttributes) PluginConfig {
+	for i := range c.Rules {
+		if c.Rules[i].Match.Matches(attrs) {
+			return c.Rules[i].apply(*c)
+		}
+	}
+	return *c
+}
+
+// Disabled 返回匹配的规则是否关闭了缓存
+func (c *PluginConfig) Disabled() bool {
+	return c.disabled
+}
+
+// validateCachePolicy 校验可以被规则覆盖的配置项，全局配置和每个规则覆盖后的配置都需要校验
+func (c *PluginConfig) validateCachePolicy() error {
+	if strings.ContainsAny(c.CacheKeyNamespace, ":'") {
+		return errors.New("cacheKeyNamespace must not contain ':' or '''")
+	}
+	if strings.Contains(c.CacheKeyPrefix, "'") {
+		return errors.New("cacheKeyPrefix must not contain '''")
+	}
+	if c.CacheTTL < 0 || c.CacheSoftTTL < 0 {
+		return errors.New("cacheTTL and cacheSoftTTL must not be negative")
+	}
+	if c.CacheTTL > 0 && c.CacheSoftTTL >= c.CacheTTL {
+		return errors.New("cacheSoftTTL must be less than cacheTTL")
+	}
+	if c.SimilarityThreshold < 0 {
+		return errors.New("similarityThreshold must not be negative")
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/tidwall/gjson"
+)
+
+func TestMatchHost(t *testing.T) {
+	tests := []struct {
+		pattern string
+		host    string
+		want    bool
+	}{
+		{pattern: "api.example.com", host: "api.example.com", want: true},
+		{pattern: "api.example.com", host: "api.example.com:8080", want: true},
+		{pattern: "api.example.com", host: "example.com"},
+		{pattern: "*.example.com", host: "api.example.com", want: true},
+		{pattern: "*.example.com", host: "a.b.example.com:443", want: true},
+		{pattern: "*.example.com", host: "example.com"},
+		{pattern: "*.example.com", host: "badexample.com"},
+		{pattern: "[::1]", host: "[::1]", want: true},
+		{pattern: "[::1]", host: "[::1]:8080", want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern+" "+tt.host, func(t *testing.T) {
+			if got := matchHost(tt.pattern, tt.host); got != tt.want {
+				t.Fatalf("matchHost(%q, %q) = %v, want %v", tt.pattern, tt.host, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRuleMatch(t *testing.T) {
+	attrs := RequestAttributes{
+		Route:    "chat",
+		Host:     "api.example.com",
+		Model:    "gpt-4o",
+		Path:     "/v1/chat/completions",
+		Consumer: "alice",
+	}
+	tests := []struct {
+		name  string
+		match string
+		want  bool
+	}{
+		{name: "empty", match: `{}`, want: true},
+		{name: "all", match: `{"route":"chat","host":"*.example.com","model":"gpt-4o","path":"/v1/","consumer":"alice"}`, want: true},
+		{name: "route", match: `{"route":"embeddings"}`},
+		{name: "host", match: `{"host":"other.example.com"}`},
+		{name: "model", match: `{"model":"gpt-4o-mini"}`},
+		{name: "path prefix", match: `{"path":"/v1/embeddings"}`},
+		{name: "consumer", match: `{"consumer":"bob"}`},
+		{name: "one of several", match: `{"model":"gpt-4o","consumer":"bob"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var m RuleMatch
+			m.FromJson(gjson.Parse(tt.match))
+			if got := m.Matches(attrs); got != tt.want {
+				t.Fatalf("Matches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestForRequest(t *testing.T) {
+	c := PluginConfig{CacheTTL: 100, CacheKeyNamespace: "global"}
+	for _, rule := range []string{
+		`{"match":{"consumer":"alice"},"enabled":false}`,
+		`{"match":{"model":"gpt-4o"},"cacheTTL":0,"cacheKeyNamespace":"gpt"}`,
+		`{"match":{},"cacheTTL":10}`,
+	} {
+		var r CacheRule
+		r.FromJson(gjson.Parse(rule))
+		c.Rules = append(c.Rules, r)
+	}
+	tests := []struct {
+		name          string
+		attrs         RequestAttributes
+		wantDisabled  bool
+		wantTTL       int
+		wantNamespace string
+	}{
+		{name: "disabled", attrs: RequestAttributes{Consumer: "alice", Model: "gpt-4o"}, wantDisabled: true, wantTTL: 100, wantNamespace: "global"},
+		{name: "first match wins", attrs: RequestAttributes{Model: "gpt-4o"}, wantTTL: 0, wantNamespace: "gpt"},
+		{name: "catch all", attrs: RequestAttributes{Model: "qwen-max"}, wantTTL: 10, wantNamespace: "global"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := c.ForRequest(tt.attrs)
+			if got.Disabled() != tt.wantDisabled || got.CacheTTL != tt.wantTTL || got.CacheKeyNamespace != tt.wantNamespace {
+				t.Fatalf("ForRequest() disabled = %v, cacheTTL = %d, namespace = %q", got.Disabled(), got.CacheTTL, got.CacheKeyNamespace)
+			}
+		})
+	}
+	if c.Disabled() || c.CacheTTL != 100 || c.CacheKeyNamespace != "global" {
+		t.Fatal("ForRequest() modified the global config")
+	}
+}
@@ -0,0 +1,96 @@
+package config
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/tidwall/gjson"
+)
+
+const (
+	TenantFromHeader   = "header"
+	TenantFromConsumer = "consumer"
+	TenantFromJWTClaim = "jwtClaim"
+	TenantFromAPIKey   = "apiKey"
+
+	DefaultTenantHeader = "Authorization"
+)
+
+type TenantConfig struct {
+	// @Title zh-CN 租户标识的来源
+	// @Description zh-CN 可选值为 header、consumer、jwtClaim 和 apiKey，为空时不区分租户
+	From string `required:"false" yaml:"from" json:"from"`
+	// @Title zh-CN 请求头名称
+	// @Description zh-CN from 为 header 时必填；from 为 jwtClaim 或 apiKey 时为携带 JWT 或 API Key 的请求头，默认值是 Authorization
+	Header string `required:"false" yaml:"header" json:"header"`
+	// @Title zh-CN JWT 中的 claim
+	// @Description zh-CN from 为 jwtClaim 时必填，支持 GJSON PATH 语法，例如 tenant_id、org.id
+	Claim string `required:"false" yaml:"claim" json:"claim"`
+	// @Title zh-CN 跨租户共享的命名空间
+	// @Description zh-CN 这些命名空间中的缓存条目和向量被所有租户共享
+	SharedNamespaces []string `required:"false" yaml:"sharedNamespaces" json:"sharedNamespaces"`
+}
+
+func (c *TenantConfig) FromJson(json gjson.Result) {
+	c.From = json.Get("from").String()
+	c.Header = json.Get("header").String()
+	if c.Header == "" && (c.From == TenantFromJWTClaim || c.From == TenantFromAPIKey) {
+		c.Header = DefaultTenantHeader
+	}
+	c.Claim = json.Get("claim").String()
+	c.SharedNamespaces = nil
+	for _, item := range json.Get("sharedNamespaces").Array() {
+		c.SharedNamespaces = append(c.SharedNamespaces, item.String())
+	}
+}
+
+func (c *TenantConfig) Validate() error {
+	switch c.From {
+	case "", TenantFromConsumer, TenantFromAPIKey:
+	case TenantFromHeader:
+		if c.Header == "" {
+			return errors.New("tenant header must not be empty")
+		}
+	case TenantFromJWTClaim:
+		if c.Claim == "" {
+			return errors.New("tenant claim must not be empty")
+		}
+	default:
+		return errors.New("unknown tenant from: " + c.From)
+	}
+	for _, namespace := range c.SharedNamespaces {
+		if strings.ContainsAny(namespace, ":'") {
+			return errors.New("tenant sharedNamespaces must not contain ':' or '''")
+		}
+	}
+	return nil
+}
+
+// Enabled 返回是否区分租户
+func (c *TenantConfig) Enabled() bool {
+	return c.From != ""
+}
+
+// IsShared 返回命名空间是否跨租户共享
+func (c *TenantConfig) IsShared(namespace string) bool {
+	for _, shared := range c.SharedNamespaces {
+		if shared == namespace {
+			return true
+		}
+	}
+	return false
+}
+
+// WithTenant 返回属于租户的配置，租户标识只影响 key 的范围，命名空间跨租户共享时不设置
+func (c *PluginConfig) WithTenant(tenant string) PluginConfig {
+	tenantConfig := *c
+	if !c.TenantConfig.IsShared(c.CacheKeyNamespace) {
+		tenantConfig.tenant = tenant
+	}
+	return tenantConfig
+}
+
+// Tenant 返回当前请求所属租户的标识摘要，不区分租户或者命名空间跨租户共享时为空
+func (c *PluginConfig) Tenant() string {
+	return c.tenant
+}
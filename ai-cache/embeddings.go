@@ -0,0 +1,280 @@
+// 这个文件中实现 OpenAI 风格 /v1/embeddings 接口的精确匹配缓存
+// 每个 input 单独缓存，key 由模型、维度以及 input 计算得到，value 为 base64 编码的 float32 小端序向量
+// 批量请求部分命中时，只将未命中的 input 转发到上游，收到响应后按原始顺序合并缓存中的向量
+package main
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/alibaba/higress/plugins/wasm-go/extensions/ai-cache/config"
+	"github.com/alibaba/higress/plugins/wasm-go/pkg/wrapper"
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm"
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm/types"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+const (
+	EmbeddingsCacheContextKey = "embeddingsCache"
+
+	embeddingsPathSuffix = "/embeddings"
+
+	encodingFormatBase64 = "base64"
+)
+
+// embeddingsCacheState 记录一次 embeddings 请求的缓存查询结果，用于在响应阶段写入缓存以及合并向量
+type embeddingsCacheState struct {
+	// 每个 input 对应的缓存 key
+	keys []string
+	// 每个 input 对应的向量，未命中时为空字符串
+	vectors []string
+	// 未命中的 input 在原始请求中的下标，按转发到上游的顺序排列
+	missing []int
+	// 请求是否要求返回 base64 编码的向量
+	base64 bool
+	// 响应体分片
+	body []byte
+}
+
+func isEmbeddingsRequest(path string) bool {
+	if index := strings.IndexByte(path, '?'); index >= 0 {
+		path = path[:index]
+	}
+	return strings.HasSuffix(path, embeddingsPathSuffix)
+}
+
+// 将请求中的 input 拆分为单个的 input，input 可以是字符串、字符串数组、token 数组或 token 数组的数组
+func splitEmbeddingsInput(input gjson.Result) []gjson.Result {
+	if !input.Exists() {
+		return nil
+	}
+	if !input.IsArray() {
+		return []gjson.Result{input}
+	}
+	items := input.Array()
+	// 单个 token 数组形式的 input，例如 [1, 2, 3]
+	if len(items) > 0 && items[0].Type == gjson.Number {
+		return []gjson.Result{input}
+	}
+	return items
+}
+
+func embeddingsCacheKey(config config.PluginConfig, model, dimensions string, input gjson.Result) string {
+	return buildRedisKey(config, embeddingKeyKind, strings.Join([]string{model, dimensions, input.Get("@ugly").Raw}, "\x00"))
+}
+
+// 查询请求中每个 input 的缓存，全部命中时直接返回，否则只将未命中的 input 转发到上游
+func handleEmbeddingsRequest(ctx wrapper.HttpContext, config config.PluginConfig, body []byte, log wrapper.Log) types.Action {
+	bodyJson := gjson.ParseBytes(body)
+	model := bodyJson.Get("model").String()
+	inputs := splitEmbeddingsInput(bodyJson.Get("input"))
+	if model == "" || len(inputs) == 0 {
+		log.Debug("parse model or input from embeddings request body failed")
+		return types.ActionContinue
+	}
+	state := &embeddingsCacheState{
+		keys:    make([]string, len(inputs)),
+		vectors: make([]string, len(inputs)),
+		base64:  bodyJson.Get("encoding_format").String() == encodingFormatBase64,
+	}
+	dimensions := bodyJson.Get("dimensions").String()
+	for i, input := range inputs {
+		state.keys[i] = embeddingsCacheKey(config, model, dimensions, input)
+	}
+	err := config.GetCacheStore().MGet(state.keys, func(values []string, found []bool, err error) {
+		if err != nil {
+			log.Warnf("cache store mget embeddings failed, err:%v", err)
+			state.missing = allEmbeddingsIndexes(len(inputs))
+			ctx.SetContext(EmbeddingsCacheContextKey, state)
+			resumeRequest(ctx)
+			return
+		}
+		for i := range state.vectors {
+			if found[i] {
+				state.vectors[i] = values[i]
+			}
+		}
+		for i, vector := range state.vectors {
+			if vector == "" {
+				state.missing = append(state.missing, i)
+			}
+		}
+		if len(state.missing) == 0 {
+			log.Infof("embeddings cache hit, model:%s, inputs:%d", model, len(inputs))
+			proxywasm.SendHttpResponse(200, [][2]string{{"content-type", "application/json; charset=utf-8"}}, buildEmbeddingsResponse(state, model, ""), -1)
+			return
+		}
+		if len(state.missing) < len(inputs) {
+			// 部分命中，只转发未命中的 input
+			missingInputs := "[]"
+			for _, index := range state.missing {
+				missingInputs, _ = sjson.SetRaw(missingInputs, "-1", inputs[index].Raw)
+			}
+			newBody, _ := sjson.SetRawBytes(body, "input", []byte(missingInputs))
+			if err := proxywasm.ReplaceHttpRequestBody(newBody); err != nil {
+				log.Warnf("replace embeddings request body failed, err:%v", err)
+				// 仍然转发完整的请求，所有 input 都视为未命中
+				state.missing = allEmbeddingsIndexes(len(inputs))
+			} else {
+				log.Infof("embeddings cache partially hit, model:%s, hit:%d, miss:%d", model, len(inputs)-len(state.missing), len(state.missing))
+			}
+		}
+		ctx.SetContext(EmbeddingsCacheContextKey, state)
+		resumeRequest(ctx)
+	})
+	if err != nil {
+		log.Error("cache store access failed")
+		return types.ActionContinue
+	}
+	return types.ActionPause
+}
+
+func allEmbeddingsIndexes(n int) []int {
+	indexes := make([]int, n)
+	for i := range indexes {
+		indexes[i] = i
+	}
+	return indexes
+}
+
+// 上游返回错误时不缓存也不合并，部分命中时响应体会被改写，需要去掉 content-length
+func handleEmbeddingsResponseHeaders(ctx wrapper.HttpContext, state *embeddingsCacheState, log wrapper.Log) {
+	status, _ := proxywasm.GetHttpResponseHeader(":status")
+	if status != "200" {
+		log.Warnf("embeddings upstream responded with status %s, skip caching", status)
+		ctx.SetContext(EmbeddingsCacheContextKey, nil)
+		return
+	}
+	if len(state.missing) < len(state.keys) {
+		proxywasm.RemoveHttpResponseHeader("content-length")
+	}
+}
+
+// 缓存上游返回的向量，并在部分命中时将缓存中的向量按原始顺序合并到响应中
+func handleEmbeddingsResponse(config config.PluginConfig, state *embeddingsCacheState, chunk []byte, isLastChunk bool, log wrapper.Log) []byte {
+	partial := len(state.missing) < len(state.keys)
+	state.body = append(state.body, chunk...)
+	if !isLastChunk {
+		if partial {
+			// 部分命中时需要等待完整的响应后再合并
+			return []byte{}
+		}
+		return chunk
+	}
+	bodyJson := gjson.ParseBytes(state.body)
+	data := bodyJson.Get("data").Array()
+	if len(data) != len(state.missing) {
+		log.Warnf("embeddings response does not match request, expect:%d, actual:%d", len(state.missing), len(data))
+		if partial {
+			return state.body
+		}
+		return chunk
+	}
+	for i, item := range data {
+		index := i
+		if itemIndex := item.Get("index"); itemIndex.Exists() {
+			index = int(itemIndex.Int())
+		}
+		if index < 0 || index >= len(state.missing) {
+			continue
+		}
+		vector := encodeEmbedding(item.Get("embedding"))
+		if vector == "" {
+			continue
+		}
+		originIndex := state.missing[index]
+		state.vectors[originIndex] = vector
+		config.GetCacheStore().Set(state.keys[originIndex], vector, config.CacheTTL, nil)
+	}
+	if !partial {
+		return chunk
+	}
+	return buildEmbeddingsResponse(state, bodyJson.Get("model").String(), bodyJson.Get("usage").Raw)
+}
+
+// 按原始 input 的顺序构造 embeddings 响应
+func buildEmbeddingsResponse(state *embeddingsCacheState, model string, usage string) []byte {
+	var builder strings.Builder
+	builder.WriteString(`{"object":"list","data":[`)
+	for i, vector := range state.vectors {
+		if i > 0 {
+			builder.WriteByte(',')
+		}
+		builder.WriteString(`{"object":"embedding","index":`)
+		builder.WriteString(strconv.Itoa(i))
+		builder.WriteString(`,"embedding":`)
+		if state.base64 {
+			builder.WriteString(`"` + vector + `"`)
+		} else {
+			builder.WriteString(decodeEmbedding(vector))
+		}
+		builder.WriteByte('}')
+	}
+	builder.WriteString(`]}`)
+	body, _ := sjson.Set(builder.String(), "model", model)
+	if usage == "" {
+		usage = `{"prompt_tokens":0,"total_tokens":0}`
+	}
+	body, _ = sjson.SetRaw(body, "usage", usage)
+	return []byte(body)
+}
+
+// 将响应中的向量编码为 base64 的 float32 小端序字节，响应中的向量本身是 base64 时直接使用
+func encodeEmbedding(embedding gjson.Result) string {
+	if embedding.Type == gjson.String {
+		return embedding.String()
+	}
+	if !embedding.IsArray() {
+		return ""
+	}
+	values := embedding.Array()
+	buf := make([]byte, 4*len(values))
+	for i, value := range values {
+		binary.LittleEndian.PutUint32(buf[4*i:], math.Float32bits(float32(value.Float())))
+	}
+	return base64.StdEncoding.EncodeToString(buf)
+}
+
+// 将向量编码为小端序的 float32 二进制，用于缓存查询文本的向量化结果
+func encodeFloat32Vector(vector []float64) string {
+	buf := make([]byte, 4*len(vector))
+	for i, value := range vector {
+		binary.LittleEndian.PutUint32(buf[4*i:], math.Float32bits(float32(value)))
+	}
+	return string(buf)
+}
+
+func decodeFloat32Vector(value string) ([]float64, bool) {
+	if len(value) == 0 || len(value)%4 != 0 {
+		return nil, false
+	}
+	buf := []byte(value)
+	vector := make([]float64, len(buf)/4)
+	for i := range vector {
+		vector[i] = float64(math.Float32frombits(binary.LittleEndian.Uint32(buf[4*i:])))
+	}
+	return vector, true
+}
+
+// 将 base64 编码的向量解码为 JSON 数组
+func decodeEmbedding(vector string) string {
+	buf, err := base64.StdEncoding.DecodeString(vector)
+	if err != nil {
+		return "[]"
+	}
+	var builder strings.Builder
+	builder.WriteByte('[')
+	for i := 0; i+4 <= len(buf); i += 4 {
+		if i > 0 {
+			builder.WriteByte(',')
+		}
+		value := math.Float32frombits(binary.LittleEndian.Uint32(buf[i:]))
+		builder.WriteString(strconv.FormatFloat(float64(value), 'g', -1, 32))
+	}
+	builder.WriteByte(']')
+	return builder.String()
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/tidwall/gjson"
+)
+
+func TestIsEmbeddingsRequest(t *testing.T) {
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{path: "/v1/embeddings", want: true},
+		{path: "/v1/embeddings?api-version=2024-02-01", want: true},
+		{path: "/v1/chat/completions", want: false},
+		{path: "/v1/embeddings/list", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			if got := isEmbeddingsRequest(tt.path); got != tt.want {
+				t.Fatalf("isEmbeddingsRequest(%q) = %v, want %v", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitEmbeddingsInput(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []string
+	}{
+		{name: "missing", input: `{}`, want: nil},
+		{name: "string", input: `{"input":"hello"}`, want: []string{`"hello"`}},
+		{name: "strings", input: `{"input":["a","b"]}`, want: []string{`"a"`, `"b"`}},
+		{name: "tokens", input: `{"input":[1,2,3]}`, want: []string{`[1,2,3]`}},
+		{name: "token arrays", input: `{"input":[[1,2],[3]]}`, want: []string{`[1,2]`, `[3]`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := splitEmbeddingsInput(gjson.Get(tt.input, "input"))
+			if len(got) != len(tt.want) {
+				t.Fatalf("splitEmbeddingsInput() = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i].Raw != tt.want[i] {
+					t.Fatalf("splitEmbeddingsInput()[%d] = %s, want %s", i, got[i].Raw, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestEncodeEmbedding(t *testing.T) {
+	vector := encodeEmbedding(gjson.Parse(`[0.5,-1.25,3]`))
+	if got := decodeEmbedding(vector); got != "[0.5,-1.25,3]" {
+		t.Fatalf("decodeEmbedding(encodeEmbedding()) = %s", got)
+	}
+	if got := encodeEmbedding(gjson.Parse(`"AAAAPw=="`)); got != "AAAAPw==" {
+		t.Fatalf("encodeEmbedding() of a base64 vector = %q, want it unchanged", got)
+	}
+	if got := encodeEmbedding(gjson.Parse(`{}`)); got != "" {
+		t.Fatalf("encodeEmbedding() of an object = %q, want empty", got)
+	}
+	if got := decodeEmbedding("not base64"); got != "[]" {
+		t.Fatalf("decodeEmbedding() of an invalid vector = %s, want []", got)
+	}
+}
+
+// 部分命中时，缓存中的向量和上游返回的向量按原始 input 的顺序合并
+func TestBuildEmbeddingsResponse(t *testing.T) {
+	state := &embeddingsCacheState{
+		vectors: []string{
+			encodeEmbedding(gjson.Parse(`[1]`)),
+			encodeEmbedding(gjson.Parse(`[2,3]`)),
+		},
+	}
+	body := gjson.ParseBytes(buildEmbeddingsResponse(state, "text-embedding-3-small", ""))
+	if got := body.Get("model").String(); got != "text-embedding-3-small" {
+		t.Fatalf("model = %q", got)
+	}
+	if got := body.Get("data.#.index").Raw; got != "[0,1]" {
+		t.Fatalf("data indexes = %s, want [0,1]", got)
+	}
+	if got := body.Get("data.1.embedding").Raw; got != "[2,3]" {
+		t.Fatalf("data.1.embedding = %s, want [2,3]", got)
+	}
+	if got := body.Get("usage.total_tokens"); !got.Exists() || got.Int() != 0 {
+		t.Fatalf("usage = %s, want zero usage", body.Get("usage").Raw)
+	}
+
+	state.base64 = true
+	body = gjson.ParseBytes(buildEmbeddingsResponse(state, "text-embedding-3-small", `{"prompt_tokens":2,"total_tokens":2}`))
+	if got := body.Get("data.0.embedding").String(); got != state.vectors[0] {
+		t.Fatalf("data.0.embedding = %q, want %q", got, state.vectors[0])
+	}
+	if got := body.Get("usage.total_tokens").Int(); got != 2 {
+		t.Fatalf("usage.total_tokens = %d, want 2", got)
+	}
+}
+
+func TestFloat32Vector(t *testing.T) {
+	vector := []float64{0.5, -1.25, 3}
+	got, ok := decodeFloat32Vector(encodeFloat32Vector(vector))
+	if !ok || len(got) != len(vector) {
+		t.Fatalf("decodeFloat32Vector(encodeFloat32Vector()) = %v, %v", got, ok)
+	}
+	for i := range vector {
+		if got[i] != vector[i] {
+			t.Fatalf("decodeFloat32Vector()[%d] = %v, want %v", i, got[i], vector[i])
+		}
+	}
+	for _, value := range []string{"", "abc"} {
+		if _, ok := decodeFloat32Vector(value); ok {
+			t.Fatalf("decodeFloat32Vector(%q) ok = true, want false", value)
+		}
+	}
+}
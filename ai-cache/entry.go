@@ -0,0 +1,283 @@
+// 这个文件中定义缓存条目在 redis 中的存储格式
+// 每个条目是一个 redis hash，除回答本身外还保存模型、创建时间、命中次数、原始问题等元数据，用于观测、淘汰、审计和失效
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alibaba/higress/plugins/wasm-go/extensions/ai-cache/compression"
+	"github.com/alibaba/higress/plugins/wasm-go/extensions/ai-cache/config"
+	"github.com/alibaba/higress/plugins/wasm-go/extensions/ai-cache/protocol"
+	"github.com/alibaba/higress/plugins/wasm-go/pkg/wrapper"
+	"github.com/tidwall/gjson"
+)
+
+const (
+	// 条目格式的版本，格式发生不兼容的变化时需要升级
+	CacheEntrySchemaVersion = 1
+
+	entryFieldContent       = "content"
+	entryFieldFinishReason  = "finish_reason"
+	entryFieldToolCalls     = "tool_calls"
+	entryFieldFunctionCall  = "function_call"
+	entryFieldResponse      = "response"
+	entryFieldModel         = "model"
+	entryFieldCreatedAt     = "created_at"
+	entryFieldHitCount      = "hit_count"
+	entryFieldLastHitAt     = "last_hit_at"
+	entryFieldQuery         = "query"
+	entryFieldParamsDigest  = "params_digest"
+	entryFieldVectorID      = "vector_id"
+	entryFieldSchemaVersion = "schema_version"
+	entryFieldComputeMillis = "compute_ms"
+	entryFieldTags          = "tags"
+)
+
+// 携带条目标签的请求头，多个标签用逗号分隔
+const cacheTagsHeader = "x-ai-cache-tags"
+
+// 可能较大、开启压缩后会被压缩的字段
+var compressibleEntryFields = []string{
+	entryFieldContent,
+	entryFieldToolCalls,
+	entryFieldFunctionCall,
+	entryFieldResponse,
+	entryFieldQuery,
+}
+
+// 不参与请求参数摘要计算的字段，这些字段是对话内容或者只影响响应的传输方式
+var conversationFields = map[string]bool{
+	"messages":       true,
+	"contents":       true,
+	"input":          true,
+	"prompt":         true,
+	"system":         true,
+	"instructions":   true,
+	"stream":         true,
+	"stream_options": true,
+}
+
+// cacheEntry 定义 redis 中的一个缓存条目
+type cacheEntry struct {
+	Answer *protocol.Answer
+	// 上游的完整响应，仅在开启 cacheFullResponse 时保存
+	Response string
+	Model    string
+	// 时间均为 unix 时间戳，单位是秒
+	CreatedAt int64
+	HitCount  int64
+	LastHitAt int64
+	// 写入缓存时请求中的原始问题，redis key 只包含问题的摘要
+	Query string
+	// 请求中除对话内容外其余参数的摘要，用于判断条目是基于哪些参数生成的
+	ParamsDigest  string
+	VectorID      string
+	SchemaVersion int
+	// 上游生成回答的耗时，单位是毫秒，用于决定提前刷新的概率
+	ComputeMillis int64
+	// 条目的标签，可以按标签清空缓存
+	Tags []string
+}
+
+func (e *cacheEntry) toFields() map[string]string {
+	fields := map[string]string{
+		entryFieldContent:       e.Answer.Content,
+		entryFieldFinishReason:  e.Answer.GetFinishReason(),
+		entryFieldModel:         e.Model,
+		entryFieldCreatedAt:     strconv.FormatInt(e.CreatedAt, 10),
+		entryFieldHitCount:      strconv.FormatInt(e.HitCount, 10),
+		entryFieldLastHitAt:     strconv.FormatInt(e.LastHitAt, 10),
+		entryFieldQuery:         e.Query,
+		entryFieldParamsDigest:  e.ParamsDigest,
+		entryFieldVectorID:      e.VectorID,
+		entryFieldSchemaVersion: strconv.Itoa(e.SchemaVersion),
+		entryFieldComputeMillis: strconv.FormatInt(e.ComputeMillis, 10),
+	}
+	if len(e.Answer.ToolCalls) > 0 {
+		toolCalls, _ := json.Marshal(e.Answer.ToolCalls)
+		fields[entryFieldToolCalls] = string(toolCalls)
+	}
+	if e.Answer.FunctionCall != nil {
+		functionCall, _ := json.Marshal(e.Answer.FunctionCall)
+		fields[entryFieldFunctionCall] = string(functionCall)
+	}
+	if e.Response != "" {
+		fields[entryFieldResponse] = e.Response
+	}
+	if len(e.Tags) > 0 {
+		fields[entryFieldTags] = strings.Join(e.Tags, ",")
+	}
+	return fields
+}
+
+// parseCacheEntry 解析从缓存存储中读取的字段，key 不存在或者条目格式不兼容时返回 nil
+// fields 可能是 L1 缓存中保存的 map，解压的结果只保存在局部变量中，不会修改 fields
+func parseCacheEntry(fields map[string]string) *cacheEntry {
+	if len(fields) == 0 {
+		return nil
+	}
+	decompressed := make(map[string]string, len(compressibleEntryFields))
+	for _, name := range compressibleEntryFields {
+		value, err := compression.Decompress(fields[name])
+		if err != nil {
+			incrementCounter(metricDecompressionFailures, 1)
+			return nil
+		}
+		decompressed[name] = value
+	}
+	schemaVersion, _ := strconv.Atoi(fields[entryFieldSchemaVersion])
+	if schemaVersion != CacheEntrySchemaVersion {
+		return nil
+	}
+	answer := &protocol.Answer{
+		Content:      decompressed[entryFieldContent],
+		FinishReason: fields[entryFieldFinishReason],
+	}
+	if toolCalls := decompressed[entryFieldToolCalls]; toolCalls != "" {
+		if err := json.Unmarshal([]byte(toolCalls), &answer.ToolCalls); err != nil {
+			return nil
+		}
+	}
+	if functionCall := decompressed[entryFieldFunctionCall]; functionCall != "" {
+		answer.FunctionCall = &protocol.ToolCallFunction{}
+		if err := json.Unmarshal([]byte(functionCall), answer.FunctionCall); err != nil {
+			return nil
+		}
+	}
+	entry := &cacheEntry{
+		Answer:        answer,
+		Response:      decompressed[entryFieldResponse],
+		Model:         fields[entryFieldModel],
+		Query:         decompressed[entryFieldQuery],
+		ParamsDigest:  fields[entryFieldParamsDigest],
+		VectorID:      fields[entryFieldVectorID],
+		SchemaVersion: schemaVersion,
+	}
+	entry.CreatedAt, _ = strconv.ParseInt(fields[entryFieldCreatedAt], 10, 64)
+	entry.HitCount, _ = strconv.ParseInt(fields[entryFieldHitCount], 10, 64)
+	entry.LastHitAt, _ = strconv.ParseInt(fields[entryFieldLastHitAt], 10, 64)
+	entry.ComputeMillis, _ = strconv.ParseInt(fields[entryFieldComputeMillis], 10, 64)
+	entry.Tags = parseTags(fields[entryFieldTags])
+	return entry
+}
+
+// 写入缓存条目，整个条目被替换，不会残留上一次写入时的可选字段，写入成功后调用 onStored
+func writeCacheEntry(config config.PluginConfig, redisKey string, entry *cacheEntry, log wrapper.Log, done func(err error)) {
+	fields := entry.toFields()
+	compressEntryFields(config, fields)
+	err := config.GetCacheStore().SetFields(redisKey, fields, config.CacheTTL, func(err error) {
+		if err != nil {
+			log.Warnf("write cache entry failed, key:%s, err:%v", redisKey, err)
+		} else {
+			if l1Cache := config.GetL1Cache(); l1Cache != nil {
+				l1Cache.Set(redisKey, fields)
+			}
+			indexCacheEntryTags(config, redisKey, entry)
+		}
+		if done != nil {
+			done(err)
+		}
+	})
+	if err != nil {
+		log.Warnf("write cache entry failed, key:%s, err:%v", redisKey, err)
+		if done != nil {
+			done(err)
+		}
+	}
+}
+
+// indexCacheEntryTags 将条目加入每个标签的索引，索引的字段为条目的 redis key，值为向量 ID
+// 索引的过期时间在每次写入时延长为 cacheTTL，不短于其中任何条目的过期时间
+func indexCacheEntryTags(config config.PluginConfig, redisKey string, entry *cacheEntry) {
+	for _, tag := range entry.Tags {
+		tagKey := tagRedisKey(config, tag)
+		config.GetCacheStore().SetField(tagKey, redisKey, entry.VectorID, nil)
+		config.GetCacheStore().Touch(tagKey, config.CacheTTL, nil)
+	}
+}
+
+// parseTags 解析逗号分隔的标签，忽略空白的标签
+func parseTags(value string) []string {
+	var tags []string
+	for _, tag := range strings.Split(value, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+func deleteCacheEntry(config config.PluginConfig, redisKey string) {
+	config.GetCacheStore().Delete(redisKey, nil)
+	if l1Cache := config.GetL1Cache(); l1Cache != nil {
+		l1Cache.Delete(redisKey)
+	}
+}
+
+// 按配置压缩较大的字段，并记录压缩前后的字节数
+func compressEntryFields(config config.PluginConfig, fields map[string]string) {
+	for _, name := range compressibleEntryFields {
+		value, ok := fields[name]
+		if !ok {
+			continue
+		}
+		compressed, ok := config.GetCompressor().Compress(value)
+		if !ok {
+			continue
+		}
+		fields[name] = compressed
+		incrementCounter(metricCompressedValues, 1)
+		incrementCounter(metricCompressionInputBytes, uint64(len(value)))
+		incrementCounter(metricCompressionOutputBytes, uint64(len(compressed)))
+	}
+}
+
+// 命中时原子地增加命中次数并更新最后命中时间，条目在此期间过期或被删除时不会被重新创建
+func touchCacheEntry(config config.PluginConfig, redisKey string) {
+	config.GetCacheStore().RecordHit(redisKey, entryFieldHitCount, entryFieldLastHitAt, time.Now().Unix(), nil)
+}
+
+// 计算请求中除对话内容外其余参数的摘要，参数按字段名排序，与字段在请求中的顺序无关
+func requestParamsDigest(body gjson.Result) string {
+	params := make(map[string]string)
+	var names []string
+	body.ForEach(func(key, value gjson.Result) bool {
+		if !conversationFields[key.String()] {
+			names = append(names, key.String())
+			params[key.String()] = value.Get("@ugly").Raw
+		}
+		return true
+	})
+	sort.Strings(names)
+	hash := sha256.New()
+	for _, name := range names {
+		hash.Write([]byte(name))
+		hash.Write([]byte{0})
+		hash.Write([]byte(params[name]))
+		hash.Write([]byte{0})
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// 请求中的模型，Gemini 的模型位于路径中，例如 /v1beta/models/gemini-pro:generateContent
+func requestModel(path string, body gjson.Result) string {
+	if model := body.Get("model").String(); model != "" {
+		return model
+	}
+	const modelsSegment = "/models/"
+	index := strings.LastIndex(path, modelsSegment)
+	if index < 0 {
+		return ""
+	}
+	model := path[index+len(modelsSegment):]
+	if end := strings.IndexAny(model, ":/?"); end >= 0 {
+		model = model[:end]
+	}
+	return model
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"compress/gzip"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/alibaba/higress/plugins/wasm-go/extensions/ai-cache/compression"
+	"github.com/alibaba/higress/plugins/wasm-go/extensions/ai-cache/protocol"
+	"github.com/tidwall/gjson"
+)
+
+func TestParseCacheEntry(t *testing.T) {
+	entry := &cacheEntry{
+		Answer: &protocol.Answer{
+			FinishReason: protocol.FinishReasonToolCalls,
+			ToolCalls: []protocol.ToolCall{{
+				ID:       "call_1",
+				Type:     "function",
+				Function: protocol.ToolCallFunction{Name: "get_weather", Arguments: `{"city":"hangzhou"}`},
+			}},
+		},
+		Model:         "gpt-4o",
+		CreatedAt:     1700000000,
+		HitCount:      3,
+		LastHitAt:     1700000100,
+		ComputeMillis: 1500,
+		Query:         "weather in hangzhou",
+		ParamsDigest:  "digest",
+		VectorID:      "id",
+		SchemaVersion: CacheEntrySchemaVersion,
+	}
+	got := parseCacheEntry(entry.toFields())
+	if !reflect.DeepEqual(got, entry) {
+		t.Fatalf("parseCacheEntry() = %+v, want %+v", got, entry)
+	}
+
+	// L1 缓存返回的是其中保存的 map，解析时不能修改
+	content := strings.Repeat("hello world ", 100)
+	entry.Answer = &protocol.Answer{Content: content, FinishReason: "stop"}
+	fields := entry.toFields()
+	var compressed bool
+	fields[entryFieldContent], compressed = compression.NewCompressor(compression.Config{
+		Algorithm: compression.AlgorithmGzip, Level: gzip.DefaultCompression,
+	}).Compress(fields[entryFieldContent])
+	if !compressed {
+		t.Fatal("Compress() did not compress the content")
+	}
+	stored := make(map[string]string, len(fields))
+	for name, value := range fields {
+		stored[name] = value
+	}
+	if got := parseCacheEntry(fields); got == nil || got.Answer.Content != content {
+		t.Fatalf("parseCacheEntry() of compressed fields = %+v, want the decompressed content", got)
+	}
+	if !reflect.DeepEqual(fields, stored) {
+		t.Fatalf("parseCacheEntry() modified its input: %+v, want %+v", fields, stored)
+	}
+
+	if got := parseCacheEntry(map[string]string{}); got != nil {
+		t.Fatalf("parseCacheEntry() of a missing key = %+v, want nil", got)
+	}
+	fields = entry.toFields()
+	fields[entryFieldSchemaVersion] = strconv.Itoa(CacheEntrySchemaVersion + 1)
+	if got := parseCacheEntry(fields); got != nil {
+		t.Fatalf("parseCacheEntry() of another schema version = %+v, want nil", got)
+	}
+}
+
+func TestRequestParamsDigest(t *testing.T) {
+	digest := requestParamsDigest(gjson.Parse(`{"model":"gpt-4o","temperature":0,"messages":[{"role":"user","content":"a"}]}`))
+	tests := []struct {
+		name      string
+		body      string
+		wantEqual bool
+	}{
+		{name: "field order", body: `{"temperature":0,"model":"gpt-4o","messages":[]}`, wantEqual: true},
+		{name: "conversation and stream", body: `{"model":"gpt-4o","temperature":0,"messages":[{"role":"user","content":"b"}],"stream":true}`, wantEqual: true},
+		{name: "temperature", body: `{"model":"gpt-4o","temperature":1,"messages":[]}`},
+		{name: "model", body: `{"model":"gpt-4o-mini","temperature":0,"messages":[]}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := requestParamsDigest(gjson.Parse(tt.body)); (got == digest) != tt.wantEqual {
+				t.Fatalf("requestParamsDigest() = %s, base %s, want equal %v", got, digest, tt.wantEqual)
+			}
+		})
+	}
+}
+
+func TestRequestModel(t *testing.T) {
+	tests := []struct {
+		path string
+		body string
+		want string
+	}{
+		{path: "/v1/chat/completions", body: `{"model":"gpt-4o"}`, want: "gpt-4o"},
+		{path: "/v1beta/models/gemini-pro:generateContent", body: `{}`, want: "gemini-pro"},
+		{path: "/v1beta/models/gemini-pro:streamGenerateContent?alt=sse", body: `{}`, want: "gemini-pro"},
+		{path: "/v1/messages", body: `{}`, want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			if got := requestModel(tt.path, gjson.Parse(tt.body)); got != tt.want {
+				t.Fatalf("requestModel() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
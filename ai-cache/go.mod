@@ -12,6 +12,7 @@ require (
 	github.com/tidwall/gjson v1.14.3
 	github.com/tidwall/resp v0.1.1
 	github.com/tidwall/sjson v1.2.5
+	golang.org/x/text v0.14.0
 )
 
 require (
@@ -0,0 +1,140 @@
+// 这个文件中实现缓存 key 的构造和拆分
+// 缓存 key 由参与向量化的文本和若干摘要后缀组成：<文本的字节长度>:<文本>#media:<非文本内容摘要>#tools:<tools 定义摘要>#system:<系统指令摘要>
+// 文本的长度写在最前面，拆分时不需要在文本中查找分隔符，文本中恰好包含分隔符或者摘要时也不会被误拆
+// 摘要后缀只参与精确匹配，语义相似的 key 只有在所有摘要后缀都相同时才能复用其回答
+// 缓存 key 本身不会直接作为 redis key，redis key 的格式为 <前缀>:<版本>:<命名空间>[:tenant-<租户摘要>]:<类型>:<缓存 key 的 SHA-256>
+// 这样 redis key 的长度固定，也不会在 key 空间和日志中暴露用户的原始问题
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"strings"
+
+	"github.com/alibaba/higress/plugins/wasm-go/extensions/ai-cache/config"
+)
+
+const (
+	// redis key 格式的版本，key 的构造方式或 value 的类型发生不兼容的变化时需要升级版本，旧版本的 key 会随过期时间自然淘汰
+	CacheKeyVersion = "v3"
+
+	// redis key 中的类型，区分不同用途的缓存
+	answerKeyKind    = "answer"
+	embeddingKeyKind = "embedding"
+	// 查询文本的向量化结果
+	queryEmbeddingKeyKind = "query-embedding"
+	// 标签的索引
+	tagKeyKind = "tag"
+
+	// redis key 中租户标识摘要的前缀
+	tenantScopePrefix = "tenant-"
+
+	// 缓存 key 中非文本内容摘要的分隔符
+	mediaKeySeparator = "#media:"
+	// 缓存 key 中 tools 定义摘要的分隔符
+	toolsKeySeparator = "#tools:"
+	// 缓存 key 中消息之外的系统指令摘要的分隔符
+	systemKeySeparator = "#system:"
+)
+
+// mediaKeySuffix 根据协议适配器提取出的非文本内容摘要生成缓存 key 的后缀，请求中没有非文本内容时返回空字符串
+func mediaKeySuffix(media string) string {
+	return digestKeySuffix(mediaKeySeparator, media)
+}
+
+// toolsKeySuffix 根据协议适配器提取出的 tools 定义生成缓存 key 的后缀，请求中没有 tools 定义时返回空字符串
+func toolsKeySuffix(tools string) string {
+	return digestKeySuffix(toolsKeySeparator, tools)
+}
+
+// systemKeySuffix 根据协议适配器提取出的系统指令生成缓存 key 的后缀，同一个问题在不同的系统指令下回答不同，请求中没有时返回空字符串
+func systemKeySuffix(instructions string) string {
+	return digestKeySuffix(systemKeySeparator, instructions)
+}
+
+func digestKeySuffix(separator, content string) string {
+	if content == "" {
+		return ""
+	}
+	hash := sha256.Sum256([]byte(content))
+	return separator + hex.EncodeToString(hash[:])
+}
+
+// buildCacheKey 由用于向量化的文本和摘要后缀构造缓存 key
+func buildCacheKey(text, suffix string) string {
+	return strconv.Itoa(len(text)) + ":" + text + suffix
+}
+
+// splitKeySuffix 按 key 开头记录的文本长度将缓存 key 拆分为用于向量化的文本和摘要后缀，
+// 不是由 buildCacheKey 构造的 key（例如旧版本写入的向量中保存的 key）整体作为文本返回
+func splitKeySuffix(key string) (string, string) {
+	index := strings.IndexByte(key, ':')
+	if index < 0 {
+		return key, ""
+	}
+	length, err := strconv.Atoi(key[:index])
+	if err != nil || length < 0 || length > len(key)-index-1 {
+		return key, ""
+	}
+	end := index + 1 + length
+	return key[index+1 : end], key[end:]
+}
+
+func isHexDigest(digest string) bool {
+	if len(digest) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(digest)
+	return err == nil
+}
+
+// answerRedisKey 返回缓存 key 对应回答的 redis key
+func answerRedisKey(config config.PluginConfig, key string) string {
+	return buildRedisKey(config, answerKeyKind, key)
+}
+
+// queryEmbeddingRedisKey 返回查询文本的向量化结果的 redis key，不同的向量化服务和模型的结果不能共用
+func queryEmbeddingRedisKey(config config.PluginConfig, queryString string) string {
+	provider := config.EmbeddingProviderConfig.GetProviderType()
+	model := config.EmbeddingProviderConfig.Model
+	return buildRedisKey(config, queryEmbeddingKeyKind, provider+"\x00"+model+"\x00"+queryString)
+}
+
+func tagRedisKey(config config.PluginConfig, tag string) string {
+	return buildRedisKey(config, tagKeyKind, tag)
+}
+
+// vectorID 返回缓存 key 在向量数据库中对应的文档 ID，与 redis key 一样由缓存 key 的摘要确定
+func vectorID(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(hash[:])
+}
+
+// answerKeyVectorID 返回回答的 redis key 对应的向量 ID，即 key 的最后一段，不是回答的 redis key 时返回空字符串
+func answerKeyVectorID(redisKey string) string {
+	index := strings.LastIndex(redisKey, ":")
+	if index < 0 || !strings.HasSuffix(redisKey[:index], ":"+answerKeyKind) || !isHexDigest(redisKey[index+1:]) {
+		return ""
+	}
+	return redisKey[index+1:]
+}
+
+// cacheKeyScope 返回 redis key 中类型之前的部分，规则可以按请求覆盖前缀和命名空间，向量中也保存这一部分，用于隔离不同范围的语义检索
+// 区分租户时范围的最后是租户标识的摘要：<前缀>:<版本>:<命名空间>:tenant-<摘要>
+func cacheKeyScope(config config.PluginConfig) string {
+	parts := []string{config.CacheKeyPrefix, CacheKeyVersion, config.CacheKeyNamespace}
+	if tenant := config.Tenant(); tenant != "" {
+		parts = append(parts, tenantScopePrefix+tenant)
+	}
+	return strings.Join(parts, ":")
+}
+
+func buildRedisKey(config config.PluginConfig, kind, material string) string {
+	return buildScopedRedisKey(cacheKeyScope(config), kind, material)
+}
+
+func buildScopedRedisKey(scope, kind, material string) string {
+	hash := sha256.Sum256([]byte(material))
+	return strings.Join([]string{scope, kind, hex.EncodeToString(hash[:])}, ":")
+}
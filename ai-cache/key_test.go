@@ -0,0 +1,92 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/alibaba/higress/plugins/wasm-go/extensions/ai-cache/config"
+)
+
+func TestDigestKeySuffix(t *testing.T) {
+	if suffix := toolsKeySuffix(""); suffix != "" {
+		t.Fatalf("toolsKeySuffix(\"\") = %q, want empty", suffix)
+	}
+	suffix := toolsKeySuffix(`[{"type":"function","function":{"name":"get_weather"}}]`)
+	if len(suffix) != len(toolsKeySeparator)+64 {
+		t.Fatalf("toolsKeySuffix() = %q, want a sha256 digest", suffix)
+	}
+	if other := toolsKeySuffix(`[{"type":"function","function":{"name":"get_time"}}]`); other == suffix {
+		t.Fatalf("toolsKeySuffix() of different tools = %q, want a different digest", other)
+	}
+	if media := mediaKeySuffix("image"); media[:len(mediaKeySeparator)] != mediaKeySeparator {
+		t.Fatalf("mediaKeySuffix() = %q, want the %q separator", media, mediaKeySeparator)
+	}
+}
+
+func TestSplitKeySuffix(t *testing.T) {
+	media := mediaKeySuffix("image")
+	tools := toolsKeySuffix("tools")
+	tests := []struct {
+		name       string
+		key        string
+		wantText   string
+		wantSuffix string
+	}{
+		{name: "text only", key: buildCacheKey("hello", ""), wantText: "hello"},
+		{name: "tools", key: buildCacheKey("hello", tools), wantText: "hello", wantSuffix: tools},
+		{name: "media and tools", key: buildCacheKey("hello", media+tools), wantText: "hello", wantSuffix: media + tools},
+		{name: "separator in text", key: buildCacheKey("a#tools:b", media), wantText: "a#tools:b", wantSuffix: media},
+		{name: "digest in text", key: buildCacheKey("hello"+tools, ""), wantText: "hello" + tools},
+		{name: "length prefix in text", key: buildCacheKey("3:abc", ""), wantText: "3:abc"},
+		{name: "without length prefix", key: "hello#media:abc", wantText: "hello#media:abc"},
+		{name: "length beyond the key", key: "10:hello", wantText: "10:hello"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			text, suffix := splitKeySuffix(tt.key)
+			if text != tt.wantText || suffix != tt.wantSuffix {
+				t.Fatalf("splitKeySuffix(%q) = %q, %q, want %q, %q", tt.key, text, suffix, tt.wantText, tt.wantSuffix)
+			}
+		})
+	}
+}
+
+func TestBuildRedisKey(t *testing.T) {
+	c := config.PluginConfig{CacheKeyPrefix: "higressAiCache", CacheKeyNamespace: "tenant-a"}
+	key := answerRedisKey(c, "what is the weather in hangzhou")
+	parts := strings.Split(key, ":")
+	if len(parts) != 5 || parts[0] != "higressAiCache" || parts[1] != CacheKeyVersion || parts[2] != "tenant-a" || parts[3] != answerKeyKind {
+		t.Fatalf("answerRedisKey() = %q, want <prefix>:<version>:<namespace>:<kind>:<digest>", key)
+	}
+	if !isHexDigest(parts[4]) || strings.Contains(key, "hangzhou") {
+		t.Fatalf("answerRedisKey() = %q, want the query replaced by its digest", key)
+	}
+	if answerRedisKey(c, "what is the weather in hangzhou") != key {
+		t.Fatal("answerRedisKey() is not deterministic")
+	}
+	if buildRedisKey(c, embeddingKeyKind, "what is the weather in hangzhou") == key {
+		t.Fatal("keys of different kinds must not collide")
+	}
+	other := c
+	other.CacheKeyNamespace = "tenant-b"
+	if answerRedisKey(other, "what is the weather in hangzhou") == key {
+		t.Fatal("keys of different namespaces must not collide")
+	}
+}
+
+// 不同模型的向量化结果不能共用
+func TestQueryEmbeddingRedisKey(t *testing.T) {
+	c := config.PluginConfig{CacheKeyPrefix: "higressAiCache", CacheKeyNamespace: "default"}
+	c.EmbeddingProviderConfig.Model = "text-embedding-v1"
+	key := queryEmbeddingRedisKey(c, "hello")
+	if !strings.Contains(key, ":"+queryEmbeddingKeyKind+":") {
+		t.Fatalf("queryEmbeddingRedisKey() = %q, want the %q kind", key, queryEmbeddingKeyKind)
+	}
+	if key == answerRedisKey(c, "hello") {
+		t.Fatal("query embedding keys must not collide with answer keys")
+	}
+	c.EmbeddingProviderConfig.Model = "text-embedding-v2"
+	if queryEmbeddingRedisKey(c, "hello") == key {
+		t.Fatal("query embedding keys of different models must not collide")
+	}
+}
@@ -0,0 +1,213 @@
+// 这个文件中实现位于缓存存储之前的进程内一级缓存（L1）
+// L1 保存最近命中或写入的缓存条目，命中时不需要访问 Redis 等二级缓存（L2），条目的过期时间较短，以减少与 L2 不一致的时间
+// 默认每个 Wasm VM 各自保存一份，开启 sharedData 后条目保存在 proxy-wasm shared data 中，同一个网关进程的所有 worker 共享
+// 开启 sharedData 时每个 worker 只记录自己写入的条目，容量限制对每个 worker 分别生效，shared data 中的条目总大小最多为 worker 数乘以 maxBytes；
+// shared data 不能删除 key，被淘汰、删除或过期的条目只释放 value，key 一直占用内存直到网关进程退出
+// 不开启 sharedData 时，删除只对当前 VM 生效，其他 VM 中的条目在 ttl 后过期
+package l1cache
+
+import (
+	"container/list"
+	"errors"
+	"time"
+
+	"github.com/alibaba/higress/plugins/wasm-go/extensions/ai-cache/cacheStore"
+	"github.com/tidwall/gjson"
+)
+
+const (
+	EvictionLRU = "lru"
+	EvictionLFU = "lfu"
+
+	DefaultMaxBytes = 8 * 1024 * 1024
+	DefaultTTL      = 60
+
+	// 每个条目除字段内容外的估算开销，单位是字节
+	entryOverhead = 64
+	// 开启 sharedData 时 L1 条目在 shared data 中的 key 前缀
+	sharedDataKeyPrefix = "ai-cache-l1:"
+)
+
+type Config struct {
+	// @Title zh-CN 是否开启 L1 缓存
+	// @Description zh-CN 默认值为 false
+	Enabled bool `required:"false" yaml:"enabled" json:"enabled"`
+	// @Title zh-CN L1 缓存的容量
+	// @Description zh-CN 单位是字节，超出时按淘汰策略淘汰条目，默认值为8388608，即8MB
+	MaxBytes int `required:"false" yaml:"maxBytes" json:"maxBytes"`
+	// @Title zh-CN 淘汰策略
+	// @Description zh-CN 可选值为 lru 和 lfu，默认值为 lru
+	Eviction string `required:"false" yaml:"eviction" json:"eviction"`
+	// @Title zh-CN L1 缓存的过期时间
+	// @Description zh-CN 单位是秒，默认值为60
+	TTL int `required:"false" yaml:"ttl" json:"ttl"`
+	// @Title zh-CN 是否使用 shared data 在 worker 之间共享
+	// @Description zh-CN 开启后容量限制对每个 worker 写入的条目分别生效。默认值为 false
+	SharedData bool `required:"false" yaml:"sharedData" json:"sharedData"`
+}
+
+func (c *Config) FromJson(json gjson.Result) {
+	c.Enabled = json.Get("enabled").Bool()
+	c.MaxBytes = int(json.Get("maxBytes").Int())
+	if c.MaxBytes == 0 {
+		c.MaxBytes = DefaultMaxBytes
+	}
+	c.Eviction = json.Get("eviction").String()
+	if c.Eviction == "" {
+		c.Eviction = EvictionLRU
+	}
+	c.TTL = int(json.Get("ttl").Int())
+	if c.TTL == 0 {
+		c.TTL = DefaultTTL
+	}
+	c.SharedData = json.Get("sharedData").Bool()
+}
+
+func (c *Config) Validate() error {
+	if c.MaxBytes < 0 {
+		return errors.New("l1Cache maxBytes must not be negative")
+	}
+	if c.Eviction != EvictionLRU && c.Eviction != EvictionLFU {
+		return errors.New("unknown l1Cache eviction: " + c.Eviction)
+	}
+	if c.TTL < 0 {
+		return errors.New("l1Cache ttl must not be negative")
+	}
+	return nil
+}
+
+type entry struct {
+	key    string
+	fields map[string]string
+	size   int
+	// unix 时间戳，单位是秒
+	expireAt int64
+	hits     int64
+	element  *list.Element
+}
+
+// Cache 是 L1 缓存，proxy-wasm 中每个 VM 是单线程的，不需要加锁
+type Cache struct {
+	config  Config
+	entries map[string]*entry
+	// 按最近访问时间排序，最近访问的在前
+	recency *list.List
+	bytes   int
+	shared  *cacheStore.SharedDataStore
+}
+
+func NewCache(config Config) *Cache {
+	c := &Cache{
+		config:  config,
+		entries: make(map[string]*entry),
+		recency: list.New(),
+	}
+	if config.SharedData {
+		c.shared = &cacheStore.SharedDataStore{}
+	}
+	return c
+}
+
+// Get 返回缓存的条目字段，调用方不能修改返回的 map
+func (c *Cache) Get(key string) (map[string]string, bool) {
+	e, ok := c.entries[key]
+	if ok && e.expireAt <= time.Now().Unix() {
+		c.remove(e)
+		e, ok = nil, false
+	}
+	if c.shared != nil {
+		// 其他 worker 写入的条目不在本地的索引中，总是从 shared data 中读取，其过期时间由写入的 worker 保存在 shared data 中
+		var fields map[string]string
+		c.shared.GetFields(sharedDataKeyPrefix+key, func(value map[string]string, err error) {
+			if err == nil {
+				fields = value
+			}
+		})
+		if len(fields) == 0 {
+			return nil, false
+		}
+		if ok {
+			c.touch(e)
+		}
+		return fields, true
+	}
+	if !ok {
+		return nil, false
+	}
+	c.touch(e)
+	return e.fields, true
+}
+
+// Set 写入条目，条目本身超过容量时不缓存
+func (c *Cache) Set(key string, fields map[string]string) {
+	if e, ok := c.entries[key]; ok {
+		c.remove(e)
+	}
+	size := entryOverhead + len(key)
+	for name, value := range fields {
+		size += len(name) + len(value)
+	}
+	if size > c.config.MaxBytes {
+		return
+	}
+	for c.bytes+size > c.config.MaxBytes {
+		c.evict()
+	}
+	e := &entry{
+		key:      key,
+		size:     size,
+		expireAt: time.Now().Unix() + int64(c.config.TTL),
+	}
+	if c.shared != nil {
+		var err error
+		c.shared.SetFields(sharedDataKeyPrefix+key, fields, c.config.TTL, func(setErr error) {
+			err = setErr
+		})
+		if err != nil {
+			return
+		}
+	} else {
+		e.fields = fields
+	}
+	e.element = c.recency.PushFront(e)
+	c.entries[key] = e
+	c.bytes += size
+}
+
+func (c *Cache) Delete(key string) {
+	if e, ok := c.entries[key]; ok {
+		c.remove(e)
+	} else if c.shared != nil {
+		c.shared.Delete(sharedDataKeyPrefix+key, nil)
+	}
+}
+
+func (c *Cache) touch(e *entry) {
+	e.hits++
+	c.recency.MoveToFront(e.element)
+}
+
+// evict 淘汰一个条目，LRU 淘汰最久未访问的条目，LFU 淘汰访问次数最少的条目，次数相同时淘汰最久未访问的
+func (c *Cache) evict() {
+	victim := c.recency.Back()
+	if victim == nil {
+		return
+	}
+	if c.config.Eviction == EvictionLFU {
+		for element := victim.Prev(); element != nil; element = element.Prev() {
+			if element.Value.(*entry).hits < victim.Value.(*entry).hits {
+				victim = element
+			}
+		}
+	}
+	c.remove(victim.Value.(*entry))
+}
+
+func (c *Cache) remove(e *entry) {
+	c.recency.Remove(e.element)
+	delete(c.entries, e.key)
+	c.bytes -= e.size
+	if c.shared != nil {
+		c.shared.Delete(sharedDataKeyPrefix+e.key, nil)
+	}
+}
@@ -0,0 +1,148 @@
+package l1cache
+
+import (
+	"testing"
+
+	"github.com/tidwall/gjson"
+)
+
+// 每个测试条目的大小为 entryOverhead + len(key) + len("v") + len(value)，即 64 + 2 + 1 + 1 = 68 字节
+func testFields(value string) map[string]string {
+	return map[string]string{"v": value}
+}
+
+type operation struct {
+	// set、get 或 delete
+	op    string
+	key   string
+	value string
+	// get 时期望的结果，value 为空表示不存在
+	want string
+}
+
+func TestCache(t *testing.T) {
+	tests := []struct {
+		name       string
+		config     string
+		operations []operation
+	}{
+		{
+			name:   "set and get",
+			config: `{"enabled":true}`,
+			operations: []operation{
+				{op: "get", key: "k1"},
+				{op: "set", key: "k1", value: "a"},
+				{op: "get", key: "k1", want: "a"},
+				{op: "set", key: "k1", value: "b"},
+				{op: "get", key: "k1", want: "b"},
+			},
+		},
+		{
+			name:   "delete",
+			config: `{"enabled":true}`,
+			operations: []operation{
+				{op: "set", key: "k1", value: "a"},
+				{op: "delete", key: "k1"},
+				{op: "get", key: "k1"},
+				{op: "delete", key: "k2"},
+			},
+		},
+		{
+			name:   "entry larger than the capacity is not cached",
+			config: `{"enabled":true,"maxBytes":67}`,
+			operations: []operation{
+				{op: "set", key: "k1", value: "a"},
+				{op: "get", key: "k1"},
+			},
+		},
+		{
+			name:   "lru evicts the least recently used entry",
+			config: `{"enabled":true,"maxBytes":150,"eviction":"lru"}`,
+			operations: []operation{
+				{op: "set", key: "k1", value: "a"},
+				{op: "set", key: "k2", value: "b"},
+				{op: "get", key: "k1", want: "a"},
+				{op: "set", key: "k3", value: "c"},
+				{op: "get", key: "k2"},
+				{op: "get", key: "k1", want: "a"},
+				{op: "get", key: "k3", want: "c"},
+			},
+		},
+		{
+			name:   "lfu evicts the least frequently used entry",
+			config: `{"enabled":true,"maxBytes":150,"eviction":"lfu"}`,
+			operations: []operation{
+				{op: "set", key: "k1", value: "a"},
+				{op: "get", key: "k1", want: "a"},
+				{op: "get", key: "k1", want: "a"},
+				{op: "set", key: "k2", value: "b"},
+				{op: "get", key: "k2", want: "b"},
+				{op: "set", key: "k3", value: "c"},
+				{op: "get", key: "k2"},
+				{op: "get", key: "k1", want: "a"},
+				{op: "get", key: "k3", want: "c"},
+			},
+		},
+		{
+			name:   "lfu evicts the least recently used entry among equal hits",
+			config: `{"enabled":true,"maxBytes":150,"eviction":"lfu"}`,
+			operations: []operation{
+				{op: "set", key: "k1", value: "a"},
+				{op: "set", key: "k2", value: "b"},
+				{op: "set", key: "k3", value: "c"},
+				{op: "get", key: "k1"},
+				{op: "get", key: "k2", want: "b"},
+				{op: "get", key: "k3", want: "c"},
+			},
+		},
+		{
+			name:   "overwriting an entry releases its capacity",
+			config: `{"enabled":true,"maxBytes":150}`,
+			operations: []operation{
+				{op: "set", key: "k1", value: "a"},
+				{op: "set", key: "k2", value: "b"},
+				{op: "set", key: "k2", value: "c"},
+				{op: "get", key: "k1", want: "a"},
+				{op: "get", key: "k2", want: "c"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := Config{}
+			config.FromJson(gjson.Parse(tt.config))
+			if err := config.Validate(); err != nil {
+				t.Fatalf("Validate() error = %v", err)
+			}
+			cache := NewCache(config)
+			for i, operation := range tt.operations {
+				switch operation.op {
+				case "set":
+					cache.Set(operation.key, testFields(operation.value))
+				case "delete":
+					cache.Delete(operation.key)
+				case "get":
+					fields, ok := cache.Get(operation.key)
+					if ok != (operation.want != "") || fields["v"] != operation.want {
+						t.Fatalf("operation %d: Get(%q) = %v, %v, want %q", i, operation.key, fields, ok, operation.want)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestCacheExpiration(t *testing.T) {
+	config := Config{}
+	config.FromJson(gjson.Parse(`{"enabled":true}`))
+	// ttl 为0时写入的条目立即过期
+	config.TTL = 0
+	cache := NewCache(config)
+	cache.Set("k1", testFields("a"))
+	if fields, ok := cache.Get("k1"); ok {
+		t.Fatalf("Get() = %v, want the entry to be expired", fields)
+	}
+	if cache.bytes != 0 || len(cache.entries) != 0 || cache.recency.Len() != 0 {
+		t.Fatalf("the expired entry is not released, bytes:%d, entries:%d", cache.bytes, len(cache.entries))
+	}
+}
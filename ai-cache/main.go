@@ -3,47 +3,69 @@
 package main
 
 import (
-	"fmt"
-	"net/http"
 	"strings"
+	"time"
 
 	"github.com/alibaba/higress/plugins/wasm-go/extensions/ai-cache/config"
-	provider "github.com/alibaba/higress/plugins/wasm-go/extensions/ai-cache/vectordatabaseProvider"
+	"github.com/alibaba/higress/plugins/wasm-go/extensions/ai-cache/protocol"
+	"github.com/alibaba/higress/plugins/wasm-go/extensions/ai-cache/sse"
 	"github.com/alibaba/higress/plugins/wasm-go/pkg/wrapper"
 	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm"
 	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm/types"
 	"github.com/tidwall/gjson"
-	"github.com/tidwall/resp"
 )
 
 const (
-	CacheKeyContextKey       = "cacheKey"
-	CacheContentContextKey   = "cacheContent"
-	PartialMessageContextKey = "partialMessage"
-	ToolCallsContextKey      = "toolCalls"
-	StreamContextKey         = "stream"
-	CacheKeyPrefix           = "higressAiCache"
-	DefaultCacheKeyPrefix    = "higressAiCache"
-	QueryEmbeddingKey        = "queryEmbedding"
+	CacheKeyContextKey           = "cacheKey"
+	CacheContentContextKey       = "cacheContent"
+	SSEDecoderContextKey         = "sseDecoder"
+	StreamAccumulatorContextKey  = "streamAccumulator"
+	ProtocolContextKey           = "protocol"
+	QueryTextContextKey          = "queryText"
+	ModelContextKey              = "model"
+	ParamsDigestContextKey       = "paramsDigest"
+	StreamContextKey             = "stream"
+	SSEResponseContextKey        = "sseResponse"
+	RequestBodyPhaseContextKey   = "requestBodyPhase"
+	ResumeRequestContextKey      = "resumeRequest"
+	SimilarVectorContextKey      = "similarVector"
+	SingleFlightLockContextKey   = "singleFlightLock"
+	SingleFlightWaiterContextKey = "singleFlightWaiter"
+	ContextIDContextKey          = "contextID"
+	UpstreamStartContextKey      = "upstreamStart"
+	RequestConfigContextKey      = "requestConfig"
+	TagsContextKey               = "tags"
+	AdminContextKey              = "admin"
+	RequestBodyContextKey        = "requestBody"
+	CacheKeyPrefix               = "higressAiCache"
+	DefaultCacheKeyPrefix        = "higressAiCache"
+	QueryEmbeddingKey            = "queryEmbedding"
 )
 
 func main() {
-	wrapper.SetCtx(
+	proxywasm.SetVMContext(singleFlightVMContext{wrapper.NewCommonVmCtx(
 		"ai-cache",
 		wrapper.ParseConfigBy(parseConfig),
 		wrapper.ProcessRequestHeadersBy(onHttpRequestHeaders),
 		wrapper.ProcessRequestBodyBy(onHttpRequestBody),
 		wrapper.ProcessResponseHeadersBy(onHttpResponseHeaders),
 		wrapper.ProcessStreamingResponseBodyBy(onHttpResponseBody),
-	)
+		wrapper.ProcessStreamDoneBy(onHttpStreamDone),
+	)})
 }
 
-func parseConfig(json gjson.Result, config.PluginConfig provider.config.PluginConfig, log wrapper.Log) error {
-	config.PluginConfig.EmbeddingProviderConfig.FromJson(json.Get("embeddingProvider"))
-	config.PluginConfig.VectorBaseProviderConfig.FromJson(json.Get("vectorBaseProvider"))
-	if err := config.PluginConfig.Validate(); err != nil {
+func parseConfig(json gjson.Result, c *config.PluginConfig, log wrapper.Log) error {
+	c.FromJson(json)
+	if err := c.Validate(); err != nil {
 		return err
 	}
+	if err := c.Complete(log); err != nil {
+		return err
+	}
+	registerOrphanSweep(*c, log)
+	registerExpiredVectorCleanup(*c, log)
+	registerSingleFlight(*c, log)
+	registerRefresh(*c, log)
 	return nil
 }
 
@@ -51,172 +73,264 @@ func TrimQuote(source string) string {
 	return strings.Trim(source, `"`)
 }
 
+func onHttpRequestHeaders(ctx wrapper.HttpContext, config config.PluginConfig, log wrapper.Log) types.Action {
+	if config.AdminConfig.Matches(ctx.Path()) {
+		return handleAdminRequestHeaders(ctx, config, log)
+	}
+	contentType, _ := proxywasm.GetHttpRequestHeader("content-type")
+	// The request does not have a body.
+	if contentType == "" {
+		return types.ActionContinue
+	}
+	if !strings.Contains(contentType, "application/json") {
+		log.Warnf("content is not json, can't process:%s", contentType)
+		ctx.DontReadRequestBody()
+		return types.ActionContinue
+	}
+	proxywasm.RemoveHttpRequestHeader("Accept-Encoding")
+	// The request has a body and requires delaying the header transmission until a cache miss occurs,
+	// at which point the header should be sent.
+	return types.HeaderStopIteration
+}
+
 func onHttpRequestBody(ctx wrapper.HttpContext, config config.PluginConfig, body []byte, log wrapper.Log) types.Action {
-	activeEmbeddingProvider := config.EmbeddingProviderConfig.GetProvider()
-	activeVectorBaseProvider := config.VectorBaseProviderConfig.GetProvider()
+	if op, ok := ctx.GetContext(AdminContextKey).(string); ok {
+		handleAdminRequestBody(config, op, body, log)
+		return types.ActionPause
+	}
+	ctx.SetContext(RequestBodyPhaseContextKey, struct{}{})
+	action := processRequestBody(ctx, config, body, log)
+	ctx.SetContext(RequestBodyPhaseContextKey, nil)
+	if action == types.ActionPause && ctx.GetContext(ResumeRequestContextKey) != nil {
+		// the cache store called back synchronously and decided to forward the request
+		return types.ActionContinue
+	}
+	return action
+}
+
+// resumeRequest 恢复被暂停的请求。sharedData 等缓存存储会在请求 Body 的回调中同步调用回调，此时不能调用 ResumeHttpRequest，而是由 onHttpRequestBody 直接返回 ActionContinue
+// 恢复的时间用于计算上游生成回答的耗时
+func resumeRequest(ctx wrapper.HttpContext) {
+	ctx.SetContext(UpstreamStartContextKey, time.Now())
+	if ctx.GetContext(RequestBodyPhaseContextKey) != nil {
+		ctx.SetContext(ResumeRequestContextKey, struct{}{})
+		return
+	}
+	proxywasm.ResumeHttpRequest()
+}
 
+func processRequestBody(ctx wrapper.HttpContext, config config.PluginConfig, body []byte, log wrapper.Log) types.Action {
 	bodyJson := gjson.ParseBytes(body)
-	// TODO: It may be necessary to support stream mode determination for different LLM providers.
+	config = applyCacheRules(ctx, config, bodyJson)
+	if config.Disabled() {
+		log.Debugf("cache is disabled by rule, path:%s", ctx.Path())
+		return types.ActionContinue
+	}
+	config, ok := applyTenant(ctx, config, log)
+	if !ok {
+		return types.ActionContinue
+	}
+
+	if config.CacheEmbeddings && isEmbeddingsRequest(ctx.Path()) {
+		return handleEmbeddingsRequest(ctx, config, body, log)
+	}
+
+	activeProtocol := config.GetProtocol(ctx.Path())
+	ctx.SetContext(ProtocolContextKey, activeProtocol)
 	stream := false
-	if bodyJson.Get("stream").Bool() {
+	headers, _ := proxywasm.GetHttpRequestHeaders()
+	if activeProtocol.IsStreamRequest(ctx.Path(), headers, bodyJson) {
 		stream = true
 		ctx.SetContext(StreamContextKey, struct{}{})
-	} else if ctx.GetContext(StreamContextKey) != nil {
-		stream = true
 	}
-	// key := TrimQuote(bodyJson.Get(config.CacheKeyFrom.RequestBody).Raw)
-	key := bodyJson.Get(config.CacheKeyFrom.RequestBody).String()
+	if stream {
+		ctx.SetContext(StreamAccumulatorContextKey, activeProtocol.NewStreamAccumulator(bodyJson))
+	}
+	queryText := activeProtocol.ExtractKey(bodyJson)
+	key := config.GetNormalizer().Normalize(queryText)
 	if key == "" {
 		log.Debug("parse key from request body failed")
 		return types.ActionContinue
 	}
+	// the same text with different images or audio must not share an answer
+	suffix := mediaKeySuffix(activeProtocol.ExtractMediaDigest(bodyJson))
+	if config.CacheToolCalls {
+		// the same question with different tools may lead to different tool calls
+		suffix += toolsKeySuffix(activeProtocol.ExtractTools(bodyJson))
+	}
+	suffix += systemKeySuffix(activeProtocol.ExtractInstructions(bodyJson))
+	key = buildCacheKey(key, suffix)
 
-	queryString := config.CacheKeyPrefix + key
+	ctx.SetContext(QueryTextContextKey, queryText)
+	ctx.SetContext(ModelContextKey, requestModel(ctx.Path(), bodyJson))
+	ctx.SetContext(ParamsDigestContextKey, requestParamsDigest(bodyJson))
+	if tags, _ := proxywasm.GetHttpRequestHeader(cacheTagsHeader); tags != "" {
+		ctx.SetContext(TagsContextKey, parseTags(tags))
+	}
+	if config.CacheSoftTTL > 0 {
+		// a stale hit may refresh the entry in the background by replaying the request
+		ctx.SetContext(RequestBodyContextKey, body)
+	}
 
-	err := redisSearchHandler(queryString, ctx, config, log, stream, true)
+	// the answer is cached under the key even if the query is never embedded, e.g. without an embedding provider
+	ctx.SetContext(CacheKeyContextKey, key)
+	err := redisSearchHandler(key, ctx, config, log, stream, true)
 
 	if err != nil {
-		log.Error("redis access failed")
+		log.Error("cache store access failed")
 		return types.ActionContinue
 	}
 	return types.ActionPause
 }
 
-func processSSEMessage(ctx wrapper.HttpContext, config config.PluginConfig, sseMessage string, log wrapper.Log) string {
-	subMessages := strings.Split(sseMessage, "\n")
-	var message string
-	for _, msg := range subMessages {
-		if strings.HasPrefix(msg, "data:") {
-			message = msg
-			break
-		}
+func getProtocol(ctx wrapper.HttpContext, config config.PluginConfig) protocol.Protocol {
+	if protocolI := ctx.GetContext(ProtocolContextKey); protocolI != nil {
+		return protocolI.(protocol.Protocol)
 	}
-	if len(message) < 6 {
-		log.Warnf("invalid message:%s", message)
-		return ""
-	}
-	// skip the prefix "data:"
-	bodyJson := message[5:]
-	if gjson.Get(bodyJson, config.CacheStreamValueFrom.ResponseBody).Exists() {
-		tempContentI := ctx.GetContext(CacheContentContextKey)
-		if tempContentI == nil {
-			content := TrimQuote(gjson.Get(bodyJson, config.CacheStreamValueFrom.ResponseBody).Raw)
-			ctx.SetContext(CacheContentContextKey, content)
-			return content
+	return config.GetProtocol(ctx.Path())
+}
+
+// 将响应体 chunk 输入当前请求的 SSE 解析器，并将其中所有已完整结束的事件交给协议适配器处理
+func processSSEChunk(ctx wrapper.HttpContext, config config.PluginConfig, chunk []byte, log wrapper.Log) *protocol.StreamAccumulator {
+	var decoder *sse.Decoder
+	if decoderI := ctx.GetContext(SSEDecoderContextKey); decoderI != nil {
+		decoder = decoderI.(*sse.Decoder)
+	} else {
+		decoder = sse.NewDecoder()
+		ctx.SetContext(SSEDecoderContextKey, decoder)
+	}
+	var accumulator *protocol.StreamAccumulator
+	if accumulatorI := ctx.GetContext(StreamAccumulatorContextKey); accumulatorI != nil {
+		accumulator = accumulatorI.(*protocol.StreamAccumulator)
+	} else {
+		accumulator = &protocol.StreamAccumulator{}
+		ctx.SetContext(StreamAccumulatorContextKey, accumulator)
+	}
+	activeProtocol := getProtocol(ctx, config)
+	for _, event := range decoder.Feed(chunk) {
+		if !event.IsDone() && !gjson.Valid(event.Data) {
+			log.Warnf("invalid message:%s", event.Data)
+			continue
 		}
-		append := TrimQuote(gjson.Get(bodyJson, config.CacheStreamValueFrom.ResponseBody).Raw)
-		content := tempContentI.(string) + append
-		ctx.SetContext(CacheContentContextKey, content)
-		return content
-	} else if gjson.Get(bodyJson, "choices.0.delta.content.tool_calls").Exists() {
-		// TODO: compatible with other providers
-		ctx.SetContext(ToolCallsContextKey, struct{}{})
-		return ""
-	}
-	log.Warnf("unknown message:%s", bodyJson)
-	return ""
+		activeProtocol.ProcessStreamEvent(event, accumulator)
+	}
+	return accumulator
 }
 
-func onHttpResponseHeaders(ctx wrapper.HttpContext, config config.config.PluginConfig, log wrapper.Log) types.Action {
+func onHttpResponseHeaders(ctx wrapper.HttpContext, config config.PluginConfig, log wrapper.Log) types.Action {
+	config = getRequestConfig(ctx, config)
+	if stateI := ctx.GetContext(EmbeddingsCacheContextKey); stateI != nil {
+		handleEmbeddingsResponseHeaders(ctx, stateI.(*embeddingsCacheState), log)
+		return types.ActionContinue
+	}
+	if status, _ := proxywasm.GetHttpResponseHeader(":status"); status != "200" && ctx.GetContext(CacheKeyContextKey) != nil {
+		// 上游请求失败时既不缓存回答，也不写入向量
+		log.Warnf("upstream responded with status %s, skip caching", status)
+		ctx.SetContext(CacheKeyContextKey, nil)
+		releaseSingleFlightLock(ctx, config)
+	}
 	contentType, _ := proxywasm.GetHttpResponseHeader("content-type")
+	// 流式请求的响应不一定是 SSE，例如 Gemini 不带 alt=sse 的流式接口返回的是 JSON 数组，这类响应与非流式响应一样整体解析
 	if strings.Contains(contentType, "text/event-stream") {
-		ctx.SetContext(StreamContextKey, struct{}{})
+		ctx.SetContext(SSEResponseContextKey, struct{}{})
 	}
 	return types.ActionContinue
 }
 
 func onHttpResponseBody(ctx wrapper.HttpContext, config config.PluginConfig, chunk []byte, isLastChunk bool, log wrapper.Log) []byte {
-	// log.Infof("I am here")
-	if ctx.GetContext(ToolCallsContextKey) != nil {
-		// we should not cache tool call result
-		return chunk
+	config = getRequestConfig(ctx, config)
+	if stateI := ctx.GetContext(EmbeddingsCacheContextKey); stateI != nil {
+		return handleEmbeddingsResponse(config, stateI.(*embeddingsCacheState), chunk, isLastChunk, log)
 	}
 	keyI := ctx.GetContext(CacheKeyContextKey)
-	// log.Infof("I am here 2: %v", keyI)
 	if keyI == nil {
 		return chunk
 	}
 	if !isLastChunk {
-		stream := ctx.GetContext(StreamContextKey)
-		if stream == nil {
-			tempContentI := ctx.GetContext(CacheContentContextKey)
-			if tempContentI == nil {
-				ctx.SetContext(CacheContentContextKey, chunk)
-				return chunk
-			}
-			tempContent := tempContentI.([]byte)
-			tempContent = append(tempContent, chunk...)
-			ctx.SetContext(CacheContentContextKey, tempContent)
-		} else {
-			var partialMessage []byte
-			partialMessageI := ctx.GetContext(PartialMessageContextKey)
-			if partialMessageI != nil {
-				partialMessage = append(partialMessageI.([]byte), chunk...)
-			} else {
-				partialMessage = chunk
-			}
-			messages := strings.Split(string(partialMessage), "\n\n")
-			for i, msg := range messages {
-				if i < len(messages)-1 {
-					// process complete message
-					processSSEMessage(ctx, config, msg, log)
-				}
-			}
-			if !strings.HasSuffix(string(partialMessage), "\n\n") {
-				ctx.SetContext(PartialMessageContextKey, []byte(messages[len(messages)-1]))
-			} else {
-				ctx.SetContext(PartialMessageContextKey, nil)
-			}
+		sseResponse := ctx.GetContext(SSEResponseContextKey)
+		if sseResponse == nil || config.CacheFullResponse {
+			tempContent, _ := ctx.GetContext(CacheContentContextKey).([]byte)
+			ctx.SetContext(CacheContentContextKey, append(tempContent, chunk...))
+		}
+		if sseResponse != nil {
+			processSSEChunk(ctx, config, chunk, log)
 		}
 		return chunk
 	}
 	// last chunk
-	key := keyI.(string)
-	stream := ctx.GetContext(StreamContextKey)
-	var value string
-	if stream == nil {
-		var body []byte
-		tempContentI := ctx.GetContext(CacheContentContextKey)
-		if tempContentI != nil {
-			body = append(tempContentI.([]byte), chunk...)
-		} else {
-			body = chunk
+	// the single flight lock is released after the answer is stored, or right away when the answer is not cached
+	stored := false
+	defer func() {
+		if !stored {
+			releaseSingleFlightLock(ctx, config)
 		}
-		bodyJson := gjson.ParseBytes(body)
-
-		value = TrimQuote(bodyJson.Get(config.CacheValueFrom.ResponseBody).Raw)
-		if value == "" {
+	}()
+	key := keyI.(string)
+	redisKey := answerRedisKey(config, key)
+	sseResponse := ctx.GetContext(SSEResponseContextKey)
+	var body []byte
+	if tempContentI := ctx.GetContext(CacheContentContextKey); tempContentI != nil {
+		body = append(tempContentI.([]byte), chunk...)
+	} else {
+		body = chunk
+	}
+	var answer *protocol.Answer
+	if sseResponse == nil {
+		answer = getProtocol(ctx, config).ParseResponse(body)
+		if answer == nil {
 			log.Warnf("parse value from response body failded, body:%s", body)
 			return chunk
 		}
 	} else {
-		if len(chunk) > 0 {
-			var lastMessage []byte
-			partialMessageI := ctx.GetContext(PartialMessageContextKey)
-			if partialMessageI != nil {
-				lastMessage = append(partialMessageI.([]byte), chunk...)
-			} else {
-				lastMessage = chunk
-			}
-			if !strings.HasSuffix(string(lastMessage), "\n\n") {
-				log.Warnf("invalid lastMessage:%s", lastMessage)
-				return chunk
-			}
-			// remove the last \n\n
-			lastMessage = lastMessage[:len(lastMessage)-2]
-			value = processSSEMessage(ctx, config, string(lastMessage), log)
-		} else {
-			tempContentI := ctx.GetContext(CacheContentContextKey)
-			if tempContentI == nil {
-				return chunk
-			}
-			value = tempContentI.(string)
+		accumulator := processSSEChunk(ctx, config, chunk, log)
+		if !accumulator.Completed() {
+			// neither a finish reason nor an end event was received, the stream may be truncated
+			log.Warnf("stream ended unexpectedly, skip caching, key:%s", redisKey)
+			return chunk
 		}
+		answer = accumulator.Answer()
+		if answer.Content == "" && !answer.HasCalls() {
+			return chunk
+		}
+	}
+	if answer.HasCalls() && !config.CacheToolCalls {
+		// we should not cache tool call result
+		return chunk
+	}
+	entry := &cacheEntry{
+		Answer:        answer,
+		CreatedAt:     time.Now().Unix(),
+		VectorID:      vectorID(key),
+		SchemaVersion: CacheEntrySchemaVersion,
+	}
+	if config.CacheFullResponse {
+		entry.Response = string(body)
 	}
-	log.Infof("I am processing cache to redis, key:%s, value:%s", key, value)
-	config.redisClient.Set(config.CacheKeyPrefix+key, value, nil)
-	if config.CacheTTL != 0 {
-		config.redisClient.Expire(config.CacheKeyPrefix+key, config.CacheTTL, nil)
+	entry.Query, _ = ctx.GetContext(QueryTextContextKey).(string)
+	entry.Model, _ = ctx.GetContext(ModelContextKey).(string)
+	entry.ParamsDigest, _ = ctx.GetContext(ParamsDigestContextKey).(string)
+	entry.Tags, _ = ctx.GetContext(TagsContextKey).([]string)
+	if upstreamStart, ok := ctx.GetContext(UpstreamStartContextKey).(time.Time); ok {
+		entry.ComputeMillis = time.Since(upstreamStart).Milliseconds()
 	}
+	log.Infof("I am processing cache to redis, key:%s", redisKey)
+	// the query embedding is inserted into the vector store only after the answer is stored,
+	// so a semantic hit never points to an answer that was never written
+	embedding, _ := ctx.GetContext(QueryEmbeddingKey).([]float64)
+	stored = true
+	releaseLock := detachSingleFlightLock(ctx, config)
+	writeCacheEntry(config, redisKey, entry, log, func(err error) {
+		releaseLock()
+		if err == nil && embedding != nil {
+			uploadQueryEmbedding(config, log, key, embedding, nil)
+		}
+	})
 	return chunk
 }
+
+// 请求结束时调用，下游提前关闭时等待中的请求也会在这里结束；
+// 上游连接中断等没有收到最后一个响应分片的请求也在这里释放锁，等待的请求不需要等到锁过期
+func onHttpStreamDone(ctx wrapper.HttpContext, config config.PluginConfig, log wrapper.Log) {
+	abandonSingleFlightWait(ctx)
+	releaseSingleFlightLock(ctx, getRequestConfig(ctx, config))
+}
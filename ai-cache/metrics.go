@@ -0,0 +1,91 @@
+// 这个文件中定义插件对外暴露的指标
+// 指标在第一次使用时定义，由 proxy-wasm 宿主汇总，可以通过 Envoy 的 stats 接口查看
+package main
+
+import (
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm"
+)
+
+const (
+	// 参与压缩的字段在压缩前后的总字节数，两者之比即为压缩率
+	metricCompressionInputBytes  = "ai_cache_compression_input_bytes"
+	metricCompressionOutputBytes = "ai_cache_compression_output_bytes"
+	// 被压缩的字段数
+	metricCompressedValues = "ai_cache_compressed_values"
+	// 解压失败的字段数，解压失败的条目按未命中处理
+	metricDecompressionFailures = "ai_cache_decompression_failures"
+	// 各级缓存的命中和未命中次数，L1 为进程内缓存，L2 为缓存存储
+	metricL1Hits   = "ai_cache_l1_hits"
+	metricL1Misses = "ai_cache_l1_misses"
+	metricL2Hits   = "ai_cache_l2_hits"
+	metricL2Misses = "ai_cache_l2_misses"
+	// 查询 L2 的累计耗时和次数，两者之比即为每次查询的平均耗时，也就是每次 L1 命中节省的耗时
+	metricL2LookupMilliseconds = "ai_cache_l2_lookup_milliseconds"
+	metricL2Lookups            = "ai_cache_l2_lookups"
+	// 查询文本的向量化结果的缓存命中和未命中次数，未命中时需要调用文本向量化接口
+	metricQueryEmbeddingHits   = "ai_cache_query_embedding_hits"
+	metricQueryEmbeddingMisses = "ai_cache_query_embedding_misses"
+	// 向量写入的次数、失败次数和累计耗时，向量在响应阶段异步写入，不会增加请求的耗时
+	metricVectorInserts            = "ai_cache_vector_inserts"
+	metricVectorInsertFailures     = "ai_cache_vector_insert_failures"
+	metricVectorInsertMilliseconds = "ai_cache_vector_insert_milliseconds"
+	// 删除的孤儿向量数，分别由语义检索命中和定期清理触发
+	metricOrphanVectorsDeletedOnHit   = "ai_cache_orphan_vectors_deleted_on_hit"
+	metricOrphanVectorsDeletedBySweep = "ai_cache_orphan_vectors_deleted_by_sweep"
+	// 定期清理检查的向量数和删除失败的向量数
+	metricOrphanSweepScanned         = "ai_cache_orphan_sweep_scanned"
+	metricOrphanVectorDeleteFailures = "ai_cache_orphan_vector_delete_failures"
+	// 后台清理删除的过期向量数和清理失败的次数，只在向量数据库不支持原生过期时统计
+	metricExpiredVectorsDeleted        = "ai_cache_expired_vectors_deleted"
+	metricExpiredVectorCleanupFailures = "ai_cache_expired_vector_cleanup_failures"
+	// 合并并发未命中请求时获得锁的请求数、等待的请求数，以及等待的请求中从缓存返回、因持有锁的请求失败或等待超时而转发到上游的请求数
+	metricSingleFlightLocks     = "ai_cache_single_flight_locks"
+	metricSingleFlightWaiters   = "ai_cache_single_flight_waiters"
+	metricSingleFlightServed    = "ai_cache_single_flight_served"
+	metricSingleFlightFallbacks = "ai_cache_single_flight_fallbacks"
+	metricSingleFlightTimeouts  = "ai_cache_single_flight_timeouts"
+	// 返回的超过软过期时间的条目数、由请求刷新的条目数，以及因超过硬过期时间而按未命中处理的条目数
+	metricStaleHits      = "ai_cache_stale_hits"
+	metricEntryRefreshes = "ai_cache_entry_refreshes"
+	metricExpiredEntries = "ai_cache_expired_entries"
+	// 开启多租户隔离时无法提取租户标识、因而跳过缓存的请求数
+	metricTenantMissing = "ai_cache_tenant_missing"
+	// 管理接口的请求数，以及通过管理接口删除、清空和写入的条目数
+	metricAdminRequests       = "ai_cache_admin_requests"
+	metricAdminDeletedEntries = "ai_cache_admin_deleted_entries"
+	metricAdminStoredEntries  = "ai_cache_admin_stored_entries"
+)
+
+// 管理接口返回的统计信息包含的指标
+var statsMetrics = []string{
+	metricCompressionInputBytes, metricCompressionOutputBytes, metricCompressedValues, metricDecompressionFailures,
+	metricL1Hits, metricL1Misses, metricL2Hits, metricL2Misses, metricL2LookupMilliseconds, metricL2Lookups,
+	metricQueryEmbeddingHits, metricQueryEmbeddingMisses,
+	metricVectorInserts, metricVectorInsertFailures, metricVectorInsertMilliseconds,
+	metricOrphanVectorsDeletedOnHit, metricOrphanVectorsDeletedBySweep, metricOrphanSweepScanned, metricOrphanVectorDeleteFailures,
+	metricExpiredVectorsDeleted, metricExpiredVectorCleanupFailures,
+	metricSingleFlightLocks, metricSingleFlightWaiters, metricSingleFlightServed, metricSingleFlightFallbacks, metricSingleFlightTimeouts,
+	metricStaleHits, metricEntryRefreshes, metricExpiredEntries,
+	metricTenantMissing,
+	metricAdminRequests, metricAdminDeletedEntries, metricAdminStoredEntries,
+}
+
+var counterMetrics = make(map[string]proxywasm.MetricCounter)
+
+func getCounter(name string) proxywasm.MetricCounter {
+	counter, ok := counterMetrics[name]
+	if !ok {
+		counter = proxywasm.DefineCounterMetric(name)
+		counterMetrics[name] = counter
+	}
+	return counter
+}
+
+func incrementCounter(name string, offset uint64) {
+	getCounter(name).Increment(offset)
+}
+
+// counterValue 返回指标在宿主中的值，宿主按指标名汇总，因此包含所有 worker 的计数
+func counterValue(name string) uint64 {
+	return getCounter(name).Value()
+}
@@ -0,0 +1,206 @@
+// 这个文件中实现缓存 key 的文本规范化流水线
+// 规范化在精确匹配查询以及向量化之前执行，避免仅有格式差异的请求无法命中缓存，同时减少不必要的向量化调用
+// 各步骤按以下顺序执行：NFKC、全角/半角转换、正则替换、大小写折叠、去除标点、合并空白
+// 截断只作用于向量化的文本，精确匹配使用完整的文本，开头相同但后续内容不同的长文本不会共用同一个缓存 key
+package normalizer
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/tidwall/gjson"
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+	"golang.org/x/text/width"
+)
+
+const (
+	MaxLengthUnitChars  = "chars"
+	MaxLengthUnitTokens = "tokens"
+
+	// 估算 token 数时，非 CJK 文本平均每个 token 对应的字符数
+	charsPerToken = 4
+)
+
+type Replacement struct {
+	// @Title zh-CN 正则表达式
+	// @Description zh-CN 使用 Go 的 RE2 语法，例如 [0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}
+	Pattern string `required:"true" yaml:"pattern" json:"pattern"`
+	// @Title zh-CN 替换内容
+	// @Description zh-CN 可以使用 $1 引用分组，默认值为空字符串，即删除匹配的内容
+	Replacement string `required:"false" yaml:"replacement" json:"replacement"`
+}
+
+type Config struct {
+	// @Title zh-CN Unicode NFKC 规范化
+	// @Description zh-CN 将兼容字符统一为标准形式，例如 ① 转换为 1。默认值为 false
+	NFKC bool `required:"false" yaml:"nfkc" json:"nfkc"`
+	// @Title zh-CN 全角/半角转换
+	// @Description zh-CN 将全角的字母、数字和标点转换为半角，将半角片假名转换为全角。默认值为 false
+	WidthFold bool `required:"false" yaml:"widthFold" json:"widthFold"`
+	// @Title zh-CN 正则替换
+	// @Description zh-CN 按顺序执行的正则替换，用于去除应用注入的时间戳、UUID、请求 ID 等内容
+	Replacements []Replacement `required:"false" yaml:"replacements" json:"replacements"`
+	// @Title zh-CN 大小写折叠
+	// @Description zh-CN 默认值为 false
+	CaseFold bool `required:"false" yaml:"caseFold" json:"caseFold"`
+	// @Title zh-CN 去除标点
+	// @Description zh-CN 去除所有 Unicode 标点和符号。默认值为 false
+	StripPunctuation bool `required:"false" yaml:"stripPunctuation" json:"stripPunctuation"`
+	// @Title zh-CN 合并空白
+	// @Description zh-CN 将连续的空白字符合并为一个空格，并去除首尾空白。默认值为 false
+	CollapseWhitespace bool `required:"false" yaml:"collapseWhitespace" json:"collapseWhitespace"`
+	// @Title zh-CN 最大长度
+	// @Description zh-CN 用于向量化的文本超过最大长度时只保留开头的部分，精确匹配仍使用完整的文本，默认值为0，即不截断
+	MaxLength int `required:"false" yaml:"maxLength" json:"maxLength"`
+	// @Title zh-CN 最大长度的单位
+	// @Description zh-CN 可选值为 chars（字符数）和 tokens（估算的 token 数，CJK 字符按每个字符一个 token，其余按每 4 个字符一个 token 估算），默认值为 chars
+	MaxLengthUnit string `required:"false" yaml:"maxLengthUnit" json:"maxLengthUnit"`
+}
+
+func (c *Config) FromJson(json gjson.Result) {
+	c.NFKC = json.Get("nfkc").Bool()
+	c.WidthFold = json.Get("widthFold").Bool()
+	c.Replacements = nil
+	for _, item := range json.Get("replacements").Array() {
+		c.Replacements = append(c.Replacements, Replacement{
+			Pattern:     item.Get("pattern").String(),
+			Replacement: item.Get("replacement").String(),
+		})
+	}
+	c.CaseFold = json.Get("caseFold").Bool()
+	c.StripPunctuation = json.Get("stripPunctuation").Bool()
+	c.CollapseWhitespace = json.Get("collapseWhitespace").Bool()
+	c.MaxLength = int(json.Get("maxLength").Int())
+	c.MaxLengthUnit = json.Get("maxLengthUnit").String()
+	if c.MaxLengthUnit == "" {
+		c.MaxLengthUnit = MaxLengthUnitChars
+	}
+}
+
+func (c *Config) Validate() error {
+	for _, replacement := range c.Replacements {
+		if replacement.Pattern == "" {
+			return errors.New("normalization replacement pattern must not be empty")
+		}
+		if _, err := regexp.Compile(replacement.Pattern); err != nil {
+			return errors.New("invalid normalization replacement pattern: " + err.Error())
+		}
+	}
+	if c.MaxLength < 0 {
+		return errors.New("normalization maxLength must not be negative")
+	}
+	if c.MaxLengthUnit != MaxLengthUnitChars && c.MaxLengthUnit != MaxLengthUnitTokens {
+		return errors.New("unknown normalization maxLengthUnit: " + c.MaxLengthUnit)
+	}
+	return nil
+}
+
+type replacer struct {
+	pattern     *regexp.Regexp
+	replacement string
+}
+
+// Normalizer 按配置对文本执行规范化，创建后可以被多个请求共享
+type Normalizer struct {
+	config    Config
+	replacers []replacer
+}
+
+func NewNormalizer(config Config) (*Normalizer, error) {
+	n := &Normalizer{config: config}
+	for _, replacement := range config.Replacements {
+		pattern, err := regexp.Compile(replacement.Pattern)
+		if err != nil {
+			return nil, err
+		}
+		n.replacers = append(n.replacers, replacer{pattern: pattern, replacement: replacement.Replacement})
+	}
+	return n, nil
+}
+
+func (n *Normalizer) Normalize(text string) string {
+	if n.config.NFKC {
+		text = norm.NFKC.String(text)
+	}
+	if n.config.WidthFold {
+		text = width.Fold.String(text)
+	}
+	for _, r := range n.replacers {
+		text = r.pattern.ReplaceAllString(text, r.replacement)
+	}
+	if n.config.CaseFold {
+		// cases.Caser 不是并发安全的，每次使用新的实例
+		text = cases.Fold().String(text)
+	}
+	if n.config.StripPunctuation {
+		text = strings.Map(func(r rune) rune {
+			if unicode.IsPunct(r) || unicode.IsSymbol(r) {
+				return -1
+			}
+			return r
+		}, text)
+	}
+	if n.config.CollapseWhitespace {
+		text = strings.Join(strings.Fields(text), " ")
+	}
+	return text
+}
+
+// Truncate 将规范化后的文本截断为用于向量化的文本，未配置最大长度时原样返回
+func (n *Normalizer) Truncate(text string) string {
+	if n.config.MaxLength <= 0 {
+		return text
+	}
+	text = n.truncate(text)
+	if n.config.CollapseWhitespace {
+		text = strings.TrimRightFunc(text, unicode.IsSpace)
+	}
+	return text
+}
+
+func (n *Normalizer) truncate(text string) string {
+	if n.config.MaxLengthUnit == MaxLengthUnitTokens {
+		return truncateTokens(text, n.config.MaxLength)
+	}
+	if utf8.RuneCountInString(text) <= n.config.MaxLength {
+		return text
+	}
+	count := 0
+	for index := range text {
+		if count == n.config.MaxLength {
+			return text[:index]
+		}
+		count++
+	}
+	return text
+}
+
+// truncateTokens 按估算的 token 数截断，CJK 字符每个计为一个 token，其余字符每 charsPerToken 个计为一个 token
+func truncateTokens(text string, maxTokens int) string {
+	tokens := 0
+	otherChars := 0
+	for index, r := range text {
+		cost := 0
+		if isCJK(r) {
+			cost = 1
+		} else {
+			if otherChars%charsPerToken == 0 {
+				cost = 1
+			}
+			otherChars++
+		}
+		if tokens+cost > maxTokens {
+			return text[:index]
+		}
+		tokens += cost
+	}
+	return text
+}
+
+func isCJK(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul)
+}
@@ -0,0 +1,83 @@
+package normalizer
+
+import (
+	"testing"
+
+	"github.com/tidwall/gjson"
+)
+
+func newTestNormalizer(t *testing.T, json string) *Normalizer {
+	config := Config{}
+	config.FromJson(gjson.Parse(json))
+	if err := config.Validate(); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+	n, err := NewNormalizer(config)
+	if err != nil {
+		t.Fatalf("NewNormalizer() error = %v", err)
+	}
+	return n
+}
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		text   string
+		want   string
+	}{
+		{name: "no steps", config: `{}`, text: "  Hello,  World! ", want: "  Hello,  World! "},
+		{name: "nfkc", config: `{"nfkc":true}`, text: "①ﬁ", want: "1fi"},
+		{name: "width fold", config: `{"widthFold":true}`, text: "ＡＢＣ１２３，ｶﾀｶﾅ", want: "ABC123,カタカナ"},
+		{
+			name:   "replacements in order",
+			config: `{"replacements":[{"pattern":"[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}","replacement":"<id>"},{"pattern":"time=(\\d+)"}]}`,
+			text:   "order 123e4567-e89b-12d3-a456-426614174000 time=1700000000",
+			want:   "order <id> ",
+		},
+		{name: "replacement with group", config: `{"replacements":[{"pattern":"(\\w+)@example\\.com","replacement":"$1"}]}`, text: "mail alice@example.com", want: "mail alice"},
+		{name: "case fold", config: `{"caseFold":true}`, text: "Hello ΣΑΣ Straße", want: "hello σασ strasse"},
+		{name: "strip punctuation", config: `{"stripPunctuation":true}`, text: "你好，世界！Hello, world? $5", want: "你好世界Hello world 5"},
+		{name: "collapse whitespace", config: `{"collapseWhitespace":true}`, text: " a \t b\n\nc ", want: "a b c"},
+		{
+			name:   "all steps",
+			config: `{"nfkc":true,"widthFold":true,"caseFold":true,"stripPunctuation":true,"collapseWhitespace":true}`,
+			text:   "  ＨＥＬＬＯ，  Ｗｏｒｌｄ！ ",
+			want:   "hello world",
+		},
+		{name: "max length does not truncate the key", config: `{"maxLength":3}`, text: "abcdef", want: "abcdef"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := newTestNormalizer(t, tt.config).Normalize(tt.text); got != tt.want {
+				t.Fatalf("Normalize(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		text   string
+		want   string
+	}{
+		{name: "disabled", config: `{}`, text: "abcdef", want: "abcdef"},
+		{name: "chars", config: `{"maxLength":3}`, text: "abcdef", want: "abc"},
+		{name: "chars shorter than max", config: `{"maxLength":10}`, text: "abc", want: "abc"},
+		{name: "chars counts runes", config: `{"maxLength":2}`, text: "你好世界", want: "你好"},
+		{name: "trailing space is trimmed when collapsing whitespace", config: `{"maxLength":4,"collapseWhitespace":true}`, text: "abc def", want: "abc"},
+		{name: "trailing space is kept without collapsing whitespace", config: `{"maxLength":4}`, text: "abc def", want: "abc "},
+		{name: "tokens cjk", config: `{"maxLength":2,"maxLengthUnit":"tokens"}`, text: "你好世界", want: "你好"},
+		{name: "tokens latin", config: `{"maxLength":2,"maxLengthUnit":"tokens"}`, text: "abcdefghijkl", want: "abcdefgh"},
+		{name: "tokens mixed", config: `{"maxLength":3,"maxLengthUnit":"tokens"}`, text: "ab你好cdefg", want: "ab你好cd"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := newTestNormalizer(t, tt.config).Truncate(tt.text); got != tt.want {
+				t.Fatalf("Truncate(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,188 @@
+// 这个文件中定义缓存中存储的回答结构
+// 缓存中的回答与写入时使用的协议以及是否为流式无关，命中时由当前请求对应的协议转换为流式/非流式响应
+package protocol
+
+import (
+	"strings"
+)
+
+// 回答的结束原因统一使用 OpenAI 的取值，各协议在读写时自行转换
+const (
+	FinishReasonStop         = "stop"
+	FinishReasonLength       = "length"
+	FinishReasonToolCalls    = "tool_calls"
+	FinishReasonFunctionCall = "function_call"
+)
+
+// 一个回答中 tool call 数量的上限，超过上限或者跳跃的下标来自异常的上游响应，忽略这些分片以免按下标分配过大的切片
+const maxToolCalls = 128
+
+// Answer 定义缓存中存储的回答
+type Answer struct {
+	Content      string     `json:"content"`
+	FinishReason string     `json:"finish_reason,omitempty"`
+	ToolCalls    []ToolCall `json:"tool_calls,omitempty"`
+	// FunctionCall 对应已废弃的 functions 接口返回的 function_call
+	FunctionCall *ToolCallFunction `json:"function_call,omitempty"`
+}
+
+// ToolCall 定义非流式响应中 message.tool_calls 的结构
+type ToolCall struct {
+	ID       string           `json:"id"`
+	Type     string           `json:"type"`
+	Function ToolCallFunction `json:"function"`
+}
+
+type ToolCallFunction struct {
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
+}
+
+// GetFinishReason 返回回答的结束原因，未记录时根据是否包含工具调用推断
+func (a *Answer) GetFinishReason() string {
+	if a.FinishReason != "" {
+		return a.FinishReason
+	}
+	if len(a.ToolCalls) > 0 {
+		return FinishReasonToolCalls
+	}
+	if a.FunctionCall != nil {
+		return FinishReasonFunctionCall
+	}
+	return FinishReasonStop
+}
+
+func (a *Answer) HasCalls() bool {
+	return len(a.ToolCalls) > 0 || a.FunctionCall != nil
+}
+
+// StreamAccumulator 累积一次流式响应中的增量内容，每个 HTTP 请求需要使用独立的实例
+type StreamAccumulator struct {
+	content      strings.Builder
+	finishReason string
+	done         bool
+	failed       bool
+	toolCalls    []ToolCall
+	functionCall *ToolCallFunction
+	// 每个事件都携带截至目前的完整回答，而不是增量
+	cumulative bool
+	// 内容块下标到 tool call 下标的映射，用于文本块和工具块交错下发的协议
+	blockToolCalls map[int]int
+}
+
+// NewCumulativeStreamAccumulator 创建用于每个事件都携带完整回答的流式响应的 accumulator
+func NewCumulativeStreamAccumulator() *StreamAccumulator {
+	return &StreamAccumulator{cumulative: true}
+}
+
+func (a *StreamAccumulator) IsCumulative() bool {
+	return a.cumulative
+}
+
+// ResetAnswer 清空已累积的内容和工具调用，保留结束原因以及流的状态，用于处理携带完整回答的事件
+func (a *StreamAccumulator) ResetAnswer() {
+	a.content.Reset()
+	a.toolCalls = nil
+	a.functionCall = nil
+	a.blockToolCalls = nil
+}
+
+func (a *StreamAccumulator) AppendContent(content string) {
+	a.content.WriteString(content)
+}
+
+func (a *StreamAccumulator) SetFinishReason(finishReason string) {
+	a.finishReason = finishReason
+}
+
+// MarkDone 标记收到了流结束事件
+func (a *StreamAccumulator) MarkDone() {
+	a.done = true
+}
+
+// MarkFailed 标记流中出现了错误事件，此次响应不会被缓存
+func (a *StreamAccumulator) MarkFailed() {
+	a.failed = true
+}
+
+// Completed 判断流是否完整结束，既没有结束原因也没有结束事件时，流可能被截断
+func (a *StreamAccumulator) Completed() bool {
+	return !a.failed && (a.done || a.finishReason != "")
+}
+
+// HasCalls 判断目前为止是否收到了工具调用
+func (a *StreamAccumulator) HasCalls() bool {
+	return len(a.toolCalls) > 0 || a.functionCall != nil
+}
+
+// AppendToolCallDelta 按 index 合并 tool call 分片，函数名随第一个分片完整下发，参数按片段拼接
+// index 只能是已有的 tool call 或者下一个新的 tool call，并且不能超过 maxToolCalls，其余的分片被忽略
+func (a *StreamAccumulator) AppendToolCallDelta(index int, id, typ, name, arguments string) {
+	if index < 0 || index > len(a.toolCalls) || index >= maxToolCalls {
+		return
+	}
+	if index == len(a.toolCalls) {
+		a.toolCalls = append(a.toolCalls, ToolCall{})
+	}
+	toolCall := &a.toolCalls[index]
+	if id != "" {
+		toolCall.ID = id
+	}
+	if typ != "" {
+		toolCall.Type = typ
+	}
+	appendFunctionDelta(&toolCall.Function, name, arguments)
+}
+
+// StartBlockToolCall 为内容块开始一个新的 tool call
+func (a *StreamAccumulator) StartBlockToolCall(blockIndex int, id, name string) {
+	if a.blockToolCalls == nil {
+		a.blockToolCalls = make(map[int]int)
+	}
+	index := len(a.toolCalls)
+	a.blockToolCalls[blockIndex] = index
+	a.AppendToolCallDelta(index, id, "function", name, "")
+}
+
+// AppendBlockToolCallArguments 追加内容块对应的 tool call 的参数片段
+func (a *StreamAccumulator) AppendBlockToolCallArguments(blockIndex int, arguments string) {
+	if index, ok := a.blockToolCalls[blockIndex]; ok {
+		a.AppendToolCallDelta(index, "", "", "", arguments)
+	}
+}
+
+// AppendToolCall 追加一个完整下发的 tool call，用于不对工具调用分片的协议
+func (a *StreamAccumulator) AppendToolCall(id, name, arguments string) {
+	a.AppendToolCallDelta(len(a.toolCalls), id, "function", name, arguments)
+}
+
+// AppendFunctionCallDelta 合并已废弃的 function_call 分片
+func (a *StreamAccumulator) AppendFunctionCallDelta(name, arguments string) {
+	if a.functionCall == nil {
+		a.functionCall = &ToolCallFunction{}
+	}
+	appendFunctionDelta(a.functionCall, name, arguments)
+}
+
+// Answer 返回目前为止累积得到的回答
+func (a *StreamAccumulator) Answer() *Answer {
+	answer := &Answer{
+		Content:      a.content.String(),
+		FinishReason: a.finishReason,
+		FunctionCall: a.functionCall,
+	}
+	for _, toolCall := range a.toolCalls {
+		if toolCall.Type == "" {
+			toolCall.Type = "function"
+		}
+		answer.ToolCalls = append(answer.ToolCalls, toolCall)
+	}
+	return answer
+}
+
+func appendFunctionDelta(function *ToolCallFunction, name, arguments string) {
+	if name != "" && function.Name == "" {
+		function.Name = name
+	}
+	function.Arguments += arguments
+}
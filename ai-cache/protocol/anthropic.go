@@ -0,0 +1,228 @@
+// Anthropic Messages 协议：/v1/messages
+// 与 OpenAI 的主要区别：system 为顶层字段，消息内容和回答都是内容块数组，流式响应使用带名称的 SSE 事件
+package protocol
+
+import (
+	"strings"
+
+	"github.com/alibaba/higress/plugins/wasm-go/extensions/ai-cache/sse"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+const (
+	anthropicMessagesPathSuffix = "/v1/messages"
+
+	anthropicStopReasonEndTurn   = "end_turn"
+	anthropicStopReasonMaxTokens = "max_tokens"
+	anthropicStopReasonToolUse   = "tool_use"
+
+	anthropicMessageTemplate = `{"id":"msg_from_cache","type":"message","role":"assistant","model":"from-cache","content":[],"stop_reason":null,"stop_sequence":null,"usage":{"input_tokens":0,"output_tokens":0}}`
+)
+
+var anthropicTextPart = typedTextPart("text")
+
+type anthropicProtocolInitializer struct {
+}
+
+func (a *anthropicProtocolInitializer) CreateProtocol(config ProtocolConfig) (Protocol, error) {
+	return &AnthropicProtocol{}, nil
+}
+
+type AnthropicProtocol struct {
+}
+
+func (a *AnthropicProtocol) GetProtocolType() string {
+	return ProtocolTypeAnthropic
+}
+
+func (a *AnthropicProtocol) MatchPath(path string) bool {
+	return strings.HasSuffix(path, anthropicMessagesPathSuffix)
+}
+
+func (a *AnthropicProtocol) IsStreamRequest(path string, headers [][2]string, body gjson.Result) bool {
+	return body.Get("stream").Bool()
+}
+
+// ExtractKey 使用最后一条消息中的文本作为缓存 key，内容块数组中只取 text 类型的块
+func (a *AnthropicProtocol) ExtractKey(body gjson.Result) string {
+	return contentText(body.Get("messages.@reverse.0.content"), anthropicTextPart)
+}
+
+func (a *AnthropicProtocol) ExtractMediaDigest(body gjson.Result) string {
+	return contentMediaDigest(body.Get("messages.@reverse.0.content"), anthropicTextPart)
+}
+
+func (a *AnthropicProtocol) ExtractTools(body gjson.Result) string {
+	return joinFields(body, "tools", "tool_choice")
+}
+
+// ExtractInstructions 使用顶层 system 中的文本，system 可以是字符串或文本块数组，文本块上的 cache_control 不影响回答
+func (a *AnthropicProtocol) ExtractInstructions(body gjson.Result) string {
+	return contentText(body.Get("system"), anthropicTextPart)
+}
+
+func (a *AnthropicProtocol) ParseResponse(body []byte) *Answer {
+	bodyJson := gjson.ParseBytes(body)
+	if bodyJson.Get("type").String() != "message" {
+		return nil
+	}
+	answer := &Answer{
+		FinishReason: anthropicToFinishReason(bodyJson.Get("stop_reason").String()),
+	}
+	var content strings.Builder
+	for _, block := range bodyJson.Get("content").Array() {
+		switch block.Get("type").String() {
+		case "text":
+			content.WriteString(block.Get("text").String())
+		case "tool_use":
+			answer.ToolCalls = append(answer.ToolCalls, ToolCall{
+				ID:   block.Get("id").String(),
+				Type: "function",
+				Function: ToolCallFunction{
+					Name:      block.Get("name").String(),
+					Arguments: compactJson(block.Get("input").Raw),
+				},
+			})
+		}
+	}
+	answer.Content = content.String()
+	if answer.Content == "" && !answer.HasCalls() {
+		return nil
+	}
+	return answer
+}
+
+func (a *AnthropicProtocol) NewStreamAccumulator(body gjson.Result) *StreamAccumulator {
+	return &StreamAccumulator{}
+}
+
+// ProcessStreamEvent 处理 message_start、content_block_start、content_block_delta、message_delta、message_stop 等事件
+func (a *AnthropicProtocol) ProcessStreamEvent(event sse.Event, accumulator *StreamAccumulator) {
+	bodyJson := gjson.Parse(event.Data)
+	eventType := bodyJson.Get("type").String()
+	if eventType == "" {
+		eventType = event.Type
+	}
+	switch eventType {
+	case "content_block_start":
+		block := bodyJson.Get("content_block")
+		switch block.Get("type").String() {
+		case "text":
+			accumulator.AppendContent(block.Get("text").String())
+		case "tool_use":
+			accumulator.StartBlockToolCall(int(bodyJson.Get("index").Int()), block.Get("id").String(), block.Get("name").String())
+		}
+	case "content_block_delta":
+		delta := bodyJson.Get("delta")
+		switch delta.Get("type").String() {
+		case "text_delta":
+			accumulator.AppendContent(delta.Get("text").String())
+		case "input_json_delta":
+			accumulator.AppendBlockToolCallArguments(int(bodyJson.Get("index").Int()), delta.Get("partial_json").String())
+		}
+	case "message_delta":
+		if stopReason := bodyJson.Get("delta.stop_reason"); stopReason.Type == gjson.String {
+			accumulator.SetFinishReason(anthropicToFinishReason(stopReason.String()))
+		}
+	case "message_stop":
+		accumulator.MarkDone()
+	case "error":
+		accumulator.MarkFailed()
+	}
+}
+
+func (a *AnthropicProtocol) BuildResponse(answer *Answer) []byte {
+	body := anthropicMessageTemplate
+	for _, block := range anthropicContentBlocks(answer) {
+		body, _ = sjson.SetRaw(body, "content.-1", block)
+	}
+	body, _ = sjson.Set(body, "stop_reason", finishReasonToAnthropic(answer.GetFinishReason()))
+	return []byte(body)
+}
+
+func (a *AnthropicProtocol) StreamContentType(path string) string {
+	return contentTypeEventStream
+}
+
+func (a *AnthropicProtocol) BuildStreamResponse(path string, answer *Answer) []byte {
+	var builder strings.Builder
+	writeEvent := func(eventType, data string) {
+		data, _ = sjson.Set(data, "type", eventType)
+		builder.WriteString("event: " + eventType + "\ndata: " + data + "\n\n")
+	}
+	message, _ := sjson.SetRaw(`{}`, "message", anthropicMessageTemplate)
+	writeEvent("message_start", message)
+	for index, block := range anthropicContentBlocks(answer) {
+		start, _ := sjson.Set(`{}`, "index", index)
+		delta := start
+		blockJson := gjson.Parse(block)
+		if blockJson.Get("type").String() == "text" {
+			start, _ = sjson.SetRaw(start, "content_block", `{"type":"text","text":""}`)
+			delta, _ = sjson.Set(delta, "delta.type", "text_delta")
+			delta, _ = sjson.Set(delta, "delta.text", blockJson.Get("text").String())
+		} else {
+			toolUse, _ := sjson.SetRaw(block, "input", `{}`)
+			start, _ = sjson.SetRaw(start, "content_block", toolUse)
+			delta, _ = sjson.Set(delta, "delta.type", "input_json_delta")
+			delta, _ = sjson.Set(delta, "delta.partial_json", blockJson.Get("input").Raw)
+		}
+		writeEvent("content_block_start", start)
+		writeEvent("content_block_delta", delta)
+		stop, _ := sjson.Set(`{}`, "index", index)
+		writeEvent("content_block_stop", stop)
+	}
+	messageDelta, _ := sjson.Set(`{"delta":{"stop_sequence":null},"usage":{"output_tokens":0}}`, "delta.stop_reason", finishReasonToAnthropic(answer.GetFinishReason()))
+	writeEvent("message_delta", messageDelta)
+	writeEvent("message_stop", `{}`)
+	return []byte(builder.String())
+}
+
+// 将回答转换为 Anthropic 的内容块，文本在前，工具调用在后
+func anthropicContentBlocks(answer *Answer) []string {
+	var blocks []string
+	if answer.Content != "" || !answer.HasCalls() {
+		block, _ := sjson.Set(`{"type":"text"}`, "text", answer.Content)
+		blocks = append(blocks, block)
+	}
+	functions := make([]ToolCall, 0, len(answer.ToolCalls)+1)
+	functions = append(functions, answer.ToolCalls...)
+	if answer.FunctionCall != nil {
+		functions = append(functions, ToolCall{Function: *answer.FunctionCall})
+	}
+	for _, toolCall := range functions {
+		block, _ := sjson.Set(`{"type":"tool_use"}`, "id", toolCall.ID)
+		block, _ = sjson.Set(block, "name", toolCall.Function.Name)
+		input := toolCall.Function.Arguments
+		if !gjson.Valid(input) || !gjson.Parse(input).IsObject() {
+			input = `{}`
+		}
+		block, _ = sjson.SetRaw(block, "input", input)
+		blocks = append(blocks, block)
+	}
+	return blocks
+}
+
+func anthropicToFinishReason(stopReason string) string {
+	switch stopReason {
+	case anthropicStopReasonEndTurn, "stop_sequence":
+		return FinishReasonStop
+	case anthropicStopReasonMaxTokens:
+		return FinishReasonLength
+	case anthropicStopReasonToolUse:
+		return FinishReasonToolCalls
+	}
+	return stopReason
+}
+
+func finishReasonToAnthropic(finishReason string) string {
+	switch finishReason {
+	case FinishReasonStop:
+		return anthropicStopReasonEndTurn
+	case FinishReasonLength:
+		return anthropicStopReasonMaxTokens
+	case FinishReasonToolCalls, FinishReasonFunctionCall:
+		return anthropicStopReasonToolUse
+	}
+	return finishReason
+}
@@ -0,0 +1,129 @@
+// OpenAI 旧版 Completions 协议：/v1/completions
+// prompt 可以是字符串、字符串数组或 token 数组，回答位于 choices[].text
+package protocol
+
+import (
+	"strings"
+
+	"github.com/alibaba/higress/plugins/wasm-go/extensions/ai-cache/sse"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+const (
+	openAICompletionsPathSuffix = "/completions"
+
+	completionsResponseTemplate = `{"id":"from-cache","object":"text_completion","created":0,"model":"from-cache","choices":[{"text":"","index":0,"logprobs":null,"finish_reason":"stop"}],"usage":{"prompt_tokens":0,"completion_tokens":0,"total_tokens":0}}`
+)
+
+type completionsProtocolInitializer struct {
+}
+
+func (c *completionsProtocolInitializer) CreateProtocol(config ProtocolConfig) (Protocol, error) {
+	return &CompletionsProtocol{}, nil
+}
+
+type CompletionsProtocol struct {
+}
+
+func (c *CompletionsProtocol) GetProtocolType() string {
+	return ProtocolTypeOpenAICompletions
+}
+
+func (c *CompletionsProtocol) MatchPath(path string) bool {
+	return strings.HasSuffix(path, openAICompletionsPathSuffix) && !strings.HasSuffix(path, openAIChatCompletionsPathSuffix)
+}
+
+func (c *CompletionsProtocol) IsStreamRequest(path string, headers [][2]string, body gjson.Result) bool {
+	return body.Get("stream").Bool()
+}
+
+// ExtractKey 使用 prompt 作为缓存 key，包含多个 prompt 的请求会返回多个回答，不做缓存
+func (c *CompletionsProtocol) ExtractKey(body gjson.Result) string {
+	prompt := body.Get("prompt")
+	if !prompt.IsArray() {
+		return prompt.String()
+	}
+	items := prompt.Array()
+	if len(items) == 0 {
+		return ""
+	}
+	// token 数组形式的 prompt
+	if items[0].Type == gjson.Number {
+		return prompt.Get("@ugly").Raw
+	}
+	if len(items) > 1 {
+		return ""
+	}
+	if items[0].IsArray() {
+		return items[0].Get("@ugly").Raw
+	}
+	return items[0].String()
+}
+
+func (c *CompletionsProtocol) ExtractMediaDigest(body gjson.Result) string {
+	return ""
+}
+
+func (c *CompletionsProtocol) ExtractTools(body gjson.Result) string {
+	return ""
+}
+
+func (c *CompletionsProtocol) ExtractInstructions(body gjson.Result) string {
+	return ""
+}
+
+func (c *CompletionsProtocol) ParseResponse(body []byte) *Answer {
+	bodyJson := gjson.ParseBytes(body)
+	answer := &Answer{
+		Content:      bodyJson.Get("choices.0.text").String(),
+		FinishReason: bodyJson.Get("choices.0.finish_reason").String(),
+	}
+	if answer.Content == "" {
+		return nil
+	}
+	return answer
+}
+
+func (c *CompletionsProtocol) NewStreamAccumulator(body gjson.Result) *StreamAccumulator {
+	return &StreamAccumulator{}
+}
+
+func (c *CompletionsProtocol) ProcessStreamEvent(event sse.Event, accumulator *StreamAccumulator) {
+	if event.IsDone() {
+		accumulator.MarkDone()
+		return
+	}
+	bodyJson := gjson.Parse(event.Data)
+	if bodyJson.Get("error").Exists() {
+		accumulator.MarkFailed()
+		return
+	}
+	if finishReason := bodyJson.Get("choices.0.finish_reason"); finishReason.Type == gjson.String {
+		accumulator.SetFinishReason(finishReason.String())
+	}
+	accumulator.AppendContent(bodyJson.Get("choices.0.text").String())
+}
+
+func (c *CompletionsProtocol) BuildResponse(answer *Answer) []byte {
+	return []byte(c.buildResponse(answer))
+}
+
+func (c *CompletionsProtocol) StreamContentType(path string) string {
+	return contentTypeEventStream
+}
+
+func (c *CompletionsProtocol) BuildStreamResponse(path string, answer *Answer) []byte {
+	return []byte("data: " + c.buildResponse(answer) + "\n\ndata: [DONE]\n\n")
+}
+
+// Completions 接口无法表示工具调用，只返回回答中的文本
+func (c *CompletionsProtocol) buildResponse(answer *Answer) string {
+	body, _ := sjson.Set(completionsResponseTemplate, "choices.0.text", answer.Content)
+	finishReason := answer.GetFinishReason()
+	if finishReason == FinishReasonToolCalls || finishReason == FinishReasonFunctionCall {
+		finishReason = FinishReasonStop
+	}
+	body, _ = sjson.Set(body, "choices.0.finish_reason", finishReason)
+	return body
+}
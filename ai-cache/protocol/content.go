@@ -0,0 +1,67 @@
+// 这个文件中实现多模态消息内容的拆分
+// 内容块数组中只有文本块参与向量化，图片、音频、文件等非文本块以摘要的形式参与精确匹配的缓存 key 计算
+// 这样文本相同但图片不同的两个请求不会命中同一个回答
+package protocol
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+
+	"github.com/tidwall/gjson"
+)
+
+// partTextFunc 返回内容块中的文本，第二个返回值表示内容块是否为文本块
+type partTextFunc func(part gjson.Result) (string, bool)
+
+// contentText 提取消息内容中的文本，内容可以是字符串或内容块数组，多个文本块使用换行拼接
+func contentText(content gjson.Result, textOf partTextFunc) string {
+	if !content.IsArray() {
+		return content.String()
+	}
+	var texts []string
+	for _, part := range content.Array() {
+		if text, ok := textOf(part); ok {
+			texts = append(texts, text)
+		}
+	}
+	return strings.Join(texts, "\n")
+}
+
+// contentMediaDigest 计算消息内容中每个非文本块的摘要，按内容块的顺序使用逗号拼接，没有非文本块时返回空字符串
+// 图片 URL 等引用形式的内容块对引用本身计算摘要，base64 等内联数据对数据计算摘要
+func contentMediaDigest(content gjson.Result, textOf partTextFunc) string {
+	if !content.IsArray() {
+		return ""
+	}
+	var digests []string
+	for _, part := range content.Array() {
+		if _, ok := textOf(part); ok {
+			continue
+		}
+		hash := sha256.Sum256([]byte(part.Get("@ugly").Raw))
+		digests = append(digests, hex.EncodeToString(hash[:]))
+	}
+	return strings.Join(digests, ",")
+}
+
+// typedTextPart 用于 {"type":"text","text":"..."} 形式的内容块，textTypes 为文本块的 type 取值
+func typedTextPart(textTypes ...string) partTextFunc {
+	return func(part gjson.Result) (string, bool) {
+		typ := part.Get("type").String()
+		for _, textType := range textTypes {
+			if typ == textType {
+				return part.Get("text").String(), true
+			}
+		}
+		return "", false
+	}
+}
+
+// keyedTextPart 用于 {"text":"..."} 形式、没有 type 字段的内容块
+func keyedTextPart(part gjson.Result) (string, bool) {
+	if text := part.Get("text"); text.Exists() {
+		return text.String(), true
+	}
+	return "", false
+}
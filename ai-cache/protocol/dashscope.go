@@ -0,0 +1,166 @@
+// DashScope 原生协议：/api/v1/services/aigc/text-generation/generation
+// 请求头 X-DashScope-SSE: enable 时返回流式响应，parameters.incremental_output 为 false（默认值）时每个事件都携带截至目前的完整回答
+package protocol
+
+import (
+	"strings"
+
+	"github.com/alibaba/higress/plugins/wasm-go/extensions/ai-cache/sse"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+const (
+	dashScopeGenerationPathSuffix = "/api/v1/services/aigc/text-generation/generation"
+
+	// 流式响应中尚未结束时 finish_reason 的取值
+	dashScopeFinishReasonNull = "null"
+
+	// 同时包含 text 和 message 两种 result_format 的字段，命中缓存时无法得知请求使用的 result_format
+	dashScopeResponseTemplate = `{"output":{"text":"","finish_reason":"stop","choices":[{"finish_reason":"stop","message":{"role":"assistant","content":""}}]},"usage":{"input_tokens":0,"output_tokens":0,"total_tokens":0},"request_id":"from-cache"}`
+)
+
+type dashScopeProtocolInitializer struct {
+}
+
+func (d *dashScopeProtocolInitializer) CreateProtocol(config ProtocolConfig) (Protocol, error) {
+	return &DashScopeProtocol{}, nil
+}
+
+type DashScopeProtocol struct {
+}
+
+func (d *DashScopeProtocol) GetProtocolType() string {
+	return ProtocolTypeDashScope
+}
+
+func (d *DashScopeProtocol) MatchPath(path string) bool {
+	return strings.HasSuffix(path, dashScopeGenerationPathSuffix)
+}
+
+// IsStreamRequest DashScope 原生协议通过请求头 x-dashscope-sse: enable 而不是请求 Body 开启流式响应
+func (d *DashScopeProtocol) IsStreamRequest(path string, headers [][2]string, body gjson.Result) bool {
+	for _, header := range headers {
+		if strings.EqualFold(header[0], "x-dashscope-sse") {
+			return header[1] == "enable"
+		}
+	}
+	return false
+}
+
+// ExtractKey 使用最后一条消息的文本作为缓存 key，没有 messages 时使用 prompt
+func (d *DashScopeProtocol) ExtractKey(body gjson.Result) string {
+	if messages := body.Get("input.messages"); messages.IsArray() {
+		return contentText(messages.Get("@reverse.0.content"), keyedTextPart)
+	}
+	return body.Get("input.prompt").String()
+}
+
+// ExtractMediaDigest 多模态模型的内容为 [{"image":...},{"text":...}] 形式的数组，除 text 外的内容块都参与摘要计算
+func (d *DashScopeProtocol) ExtractMediaDigest(body gjson.Result) string {
+	return contentMediaDigest(body.Get("input.messages.@reverse.0.content"), keyedTextPart)
+}
+
+func (d *DashScopeProtocol) ExtractTools(body gjson.Result) string {
+	return joinFields(body, "parameters.tools", "parameters.tool_choice")
+}
+
+// ExtractInstructions 系统指令是 input.messages 中的消息，没有消息之外的系统指令
+func (d *DashScopeProtocol) ExtractInstructions(body gjson.Result) string {
+	return ""
+}
+
+// ParseResponse 兼容 result_format 为 text 和 message 两种格式的响应
+func (d *DashScopeProtocol) ParseResponse(body []byte) *Answer {
+	bodyJson := gjson.ParseBytes(body)
+	if bodyJson.Get("code").String() != "" || !bodyJson.Get("output").IsObject() {
+		return nil
+	}
+	accumulator := &StreamAccumulator{}
+	d.processOutput(bodyJson.Get("output"), accumulator)
+	answer := accumulator.Answer()
+	if answer.Content == "" && !answer.HasCalls() {
+		return nil
+	}
+	return answer
+}
+
+func (d *DashScopeProtocol) NewStreamAccumulator(body gjson.Result) *StreamAccumulator {
+	if body.Get("parameters.incremental_output").Bool() {
+		return &StreamAccumulator{}
+	}
+	return NewCumulativeStreamAccumulator()
+}
+
+// ProcessStreamEvent 处理 result 事件，增量模式下合并增量，非增量模式下使用事件中的完整回答替换已累积的回答
+func (d *DashScopeProtocol) ProcessStreamEvent(event sse.Event, accumulator *StreamAccumulator) {
+	bodyJson := gjson.Parse(event.Data)
+	if event.Type == "error" || bodyJson.Get("code").String() != "" {
+		accumulator.MarkFailed()
+		return
+	}
+	output := bodyJson.Get("output")
+	if !output.IsObject() {
+		return
+	}
+	if accumulator.IsCumulative() && (dashScopeContent(output).Exists() || output.Get("choices.0.message.tool_calls").Exists()) {
+		accumulator.ResetAnswer()
+	}
+	d.processOutput(output, accumulator)
+}
+
+func (d *DashScopeProtocol) processOutput(output gjson.Result, accumulator *StreamAccumulator) {
+	accumulator.AppendContent(contentText(dashScopeContent(output), keyedTextPart))
+	for index, toolCall := range output.Get("choices.0.message.tool_calls").Array() {
+		if toolCallIndex := toolCall.Get("index"); toolCallIndex.Exists() {
+			index = int(toolCallIndex.Int())
+		}
+		accumulator.AppendToolCallDelta(
+			index,
+			toolCall.Get("id").String(),
+			toolCall.Get("type").String(),
+			toolCall.Get("function.name").String(),
+			toolCall.Get("function.arguments").String(),
+		)
+	}
+	finishReason := output.Get("choices.0.finish_reason").String()
+	if finishReason == "" {
+		finishReason = output.Get("finish_reason").String()
+	}
+	if finishReason != "" && finishReason != dashScopeFinishReasonNull {
+		accumulator.SetFinishReason(finishReason)
+	}
+}
+
+func (d *DashScopeProtocol) BuildResponse(answer *Answer) []byte {
+	return []byte(d.buildResponse(answer))
+}
+
+func (d *DashScopeProtocol) StreamContentType(path string) string {
+	return contentTypeEventStream
+}
+
+// BuildStreamResponse 将完整的回答放在一个 result 事件中返回，对增量和非增量模式都适用
+func (d *DashScopeProtocol) BuildStreamResponse(path string, answer *Answer) []byte {
+	return []byte("id:1\nevent:result\n:HTTP_STATUS/200\ndata:" + d.buildResponse(answer) + "\n\n")
+}
+
+func (d *DashScopeProtocol) buildResponse(answer *Answer) string {
+	finishReason := answer.GetFinishReason()
+	body, _ := sjson.Set(dashScopeResponseTemplate, "output.text", answer.Content)
+	body, _ = sjson.Set(body, "output.finish_reason", finishReason)
+	body, _ = sjson.Set(body, "output.choices.0.message.content", answer.Content)
+	body, _ = sjson.Set(body, "output.choices.0.finish_reason", finishReason)
+	if len(answer.ToolCalls) > 0 {
+		body, _ = sjson.Set(body, "output.choices.0.message.tool_calls", answer.ToolCalls)
+	}
+	return body
+}
+
+// 回答在 result_format 为 message 时位于 choices 中，为 text 时位于 output.text
+func dashScopeContent(output gjson.Result) gjson.Result {
+	if content := output.Get("choices.0.message.content"); content.Exists() {
+		return content
+	}
+	return output.Get("text")
+}
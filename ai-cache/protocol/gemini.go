@@ -0,0 +1,216 @@
+// Google Gemini 协议：/v1beta/models/{model}:generateContent 以及 :streamGenerateContent
+// 流式接口带 alt=sse 参数时返回 SSE，否则返回逐步下发的 JSON 数组，JSON 数组形式的响应与非流式响应一样整体解析
+package protocol
+
+import (
+	"strings"
+
+	"github.com/alibaba/higress/plugins/wasm-go/extensions/ai-cache/sse"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+const (
+	geminiGenerateContentPathSuffix       = ":generateContent"
+	geminiStreamGenerateContentPathSuffix = ":streamGenerateContent"
+
+	geminiFinishReasonStop      = "STOP"
+	geminiFinishReasonMaxTokens = "MAX_TOKENS"
+	geminiFinishReasonSafety    = "SAFETY"
+
+	// OpenAI 中因内容审核而中断的结束原因
+	finishReasonContentFilter = "content_filter"
+
+	contentTypeJson = "application/json; charset=utf-8"
+
+	geminiResponseTemplate = `{"candidates":[{"content":{"role":"model","parts":[]},"finishReason":"STOP","index":0}],"usageMetadata":{"promptTokenCount":0,"candidatesTokenCount":0,"totalTokenCount":0},"modelVersion":"from-cache"}`
+)
+
+type geminiProtocolInitializer struct {
+}
+
+func (g *geminiProtocolInitializer) CreateProtocol(config ProtocolConfig) (Protocol, error) {
+	return &GeminiProtocol{}, nil
+}
+
+type GeminiProtocol struct {
+}
+
+func (g *GeminiProtocol) GetProtocolType() string {
+	return ProtocolTypeGemini
+}
+
+func (g *GeminiProtocol) MatchPath(path string) bool {
+	return strings.HasSuffix(path, geminiGenerateContentPathSuffix) || strings.HasSuffix(path, geminiStreamGenerateContentPathSuffix)
+}
+
+// IsStreamRequest Gemini 通过请求路径而不是请求 Body 区分是否为流式请求
+func (g *GeminiProtocol) IsStreamRequest(path string, headers [][2]string, body gjson.Result) bool {
+	if index := strings.IndexByte(path, '?'); index >= 0 {
+		path = path[:index]
+	}
+	return strings.HasSuffix(path, geminiStreamGenerateContentPathSuffix)
+}
+
+// ExtractKey 使用最后一条 content 中 text 类型的 part 作为缓存 key，inlineData、fileData 等 part 由 ExtractMediaDigest 处理
+func (g *GeminiProtocol) ExtractKey(body gjson.Result) string {
+	parts := body.Get("contents.@reverse.0.parts")
+	if !parts.IsArray() {
+		return ""
+	}
+	return contentText(parts, keyedTextPart)
+}
+
+func (g *GeminiProtocol) ExtractMediaDigest(body gjson.Result) string {
+	return contentMediaDigest(body.Get("contents.@reverse.0.parts"), keyedTextPart)
+}
+
+func (g *GeminiProtocol) ExtractTools(body gjson.Result) string {
+	return joinFields(body, "tools", "toolConfig", "tool_config")
+}
+
+func (g *GeminiProtocol) ExtractInstructions(body gjson.Result) string {
+	return joinFields(body, "systemInstruction", "system_instruction")
+}
+
+// ParseResponse 解析非流式响应，同时兼容流式接口返回的 JSON 数组
+func (g *GeminiProtocol) ParseResponse(body []byte) *Answer {
+	bodyJson := gjson.ParseBytes(body)
+	responses := []gjson.Result{bodyJson}
+	if bodyJson.IsArray() {
+		responses = bodyJson.Array()
+	}
+	accumulator := &StreamAccumulator{}
+	for _, response := range responses {
+		if !response.IsObject() {
+			return nil
+		}
+		g.processResponse(response, accumulator)
+	}
+	if !accumulator.Completed() {
+		return nil
+	}
+	answer := accumulator.Answer()
+	if answer.Content == "" && !answer.HasCalls() {
+		return nil
+	}
+	return answer
+}
+
+func (g *GeminiProtocol) NewStreamAccumulator(body gjson.Result) *StreamAccumulator {
+	return &StreamAccumulator{}
+}
+
+// ProcessStreamEvent 处理 alt=sse 形式的流式响应，每个事件都是一个完整的 GenerateContentResponse，没有单独的结束事件
+func (g *GeminiProtocol) ProcessStreamEvent(event sse.Event, accumulator *StreamAccumulator) {
+	g.processResponse(gjson.Parse(event.Data), accumulator)
+}
+
+func (g *GeminiProtocol) processResponse(response gjson.Result, accumulator *StreamAccumulator) {
+	if response.Get("error").Exists() || response.Get("promptFeedback.blockReason").Exists() {
+		accumulator.MarkFailed()
+		return
+	}
+	candidate := response.Get("candidates.0")
+	for _, part := range candidate.Get("content.parts").Array() {
+		// 思考过程不属于回答
+		if part.Get("thought").Bool() {
+			continue
+		}
+		if text := part.Get("text"); text.Exists() {
+			accumulator.AppendContent(text.String())
+		}
+		if functionCall := part.Get("functionCall"); functionCall.Exists() {
+			accumulator.AppendToolCall(functionCall.Get("id").String(), functionCall.Get("name").String(), compactJson(functionCall.Get("args").Raw))
+		}
+	}
+	if finishReason := candidate.Get("finishReason").String(); finishReason != "" {
+		// Gemini 中调用函数时的结束原因也是 STOP，需要根据回答中是否包含函数调用区分
+		if finishReason == geminiFinishReasonStop && accumulator.HasCalls() {
+			accumulator.SetFinishReason(FinishReasonToolCalls)
+		} else {
+			accumulator.SetFinishReason(geminiToFinishReason(finishReason))
+		}
+	}
+}
+
+func (g *GeminiProtocol) BuildResponse(answer *Answer) []byte {
+	return []byte(g.buildResponse(answer))
+}
+
+func (g *GeminiProtocol) StreamContentType(path string) string {
+	if isGeminiSSEPath(path) {
+		return contentTypeEventStream
+	}
+	return contentTypeJson
+}
+
+// BuildStreamResponse 将完整的回答作为一个 GenerateContentResponse 返回，按请求是否带 alt=sse 使用 SSE 或者 JSON 数组
+func (g *GeminiProtocol) BuildStreamResponse(path string, answer *Answer) []byte {
+	response := g.buildResponse(answer)
+	if isGeminiSSEPath(path) {
+		return []byte("data: " + response + "\n\n")
+	}
+	return []byte("[" + response + "]")
+}
+
+func (g *GeminiProtocol) buildResponse(answer *Answer) string {
+	body := geminiResponseTemplate
+	if answer.Content != "" || !answer.HasCalls() {
+		part, _ := sjson.Set(`{}`, "text", answer.Content)
+		body, _ = sjson.SetRaw(body, "candidates.0.content.parts.-1", part)
+	}
+	functions := make([]ToolCall, 0, len(answer.ToolCalls)+1)
+	functions = append(functions, answer.ToolCalls...)
+	if answer.FunctionCall != nil {
+		functions = append(functions, ToolCall{Function: *answer.FunctionCall})
+	}
+	for _, toolCall := range functions {
+		part, _ := sjson.Set(`{}`, "functionCall.name", toolCall.Function.Name)
+		args := toolCall.Function.Arguments
+		if !gjson.Valid(args) || !gjson.Parse(args).IsObject() {
+			args = `{}`
+		}
+		part, _ = sjson.SetRaw(part, "functionCall.args", args)
+		body, _ = sjson.SetRaw(body, "candidates.0.content.parts.-1", part)
+	}
+	body, _ = sjson.Set(body, "candidates.0.finishReason", finishReasonToGemini(answer.GetFinishReason()))
+	return body
+}
+
+func isGeminiSSEPath(path string) bool {
+	index := strings.IndexByte(path, '?')
+	if index < 0 {
+		return false
+	}
+	for _, param := range strings.Split(path[index+1:], "&") {
+		if param == "alt=sse" {
+			return true
+		}
+	}
+	return false
+}
+
+func geminiToFinishReason(finishReason string) string {
+	switch finishReason {
+	case geminiFinishReasonStop:
+		return FinishReasonStop
+	case geminiFinishReasonMaxTokens:
+		return FinishReasonLength
+	case geminiFinishReasonSafety, "RECITATION", "BLOCKLIST", "PROHIBITED_CONTENT", "SPII":
+		return finishReasonContentFilter
+	}
+	return strings.ToLower(finishReason)
+}
+
+func finishReasonToGemini(finishReason string) string {
+	switch finishReason {
+	case FinishReasonStop, FinishReasonToolCalls, FinishReasonFunctionCall:
+		return geminiFinishReasonStop
+	case FinishReasonLength:
+		return geminiFinishReasonMaxTokens
+	case finishReasonContentFilter:
+		return geminiFinishReasonSafety
+	}
+	return strings.ToUpper(finishReason)
+}
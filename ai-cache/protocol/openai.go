@@ -0,0 +1,179 @@
+// OpenAI Chat Completions 协议：/v1/chat/completions
+package protocol
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/alibaba/higress/plugins/wasm-go/extensions/ai-cache/sse"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+const openAIChatCompletionsPathSuffix = "/chat/completions"
+
+var openAITextPart = typedTextPart("text")
+
+type openAIProtocolInitializer struct {
+}
+
+func (o *openAIProtocolInitializer) CreateProtocol(config ProtocolConfig) (Protocol, error) {
+	return &OpenAIProtocol{config: config}, nil
+}
+
+type OpenAIProtocol struct {
+	config ProtocolConfig
+}
+
+// streamToolCall 定义流式响应中 delta.tool_calls 的结构，相比非流式多了 index 字段
+type streamToolCall struct {
+	Index int `json:"index"`
+	ToolCall
+}
+
+func (o *OpenAIProtocol) GetProtocolType() string {
+	return ProtocolTypeOpenAI
+}
+
+func (o *OpenAIProtocol) MatchPath(path string) bool {
+	return strings.HasSuffix(path, openAIChatCompletionsPathSuffix)
+}
+
+func (o *OpenAIProtocol) IsStreamRequest(path string, headers [][2]string, body gjson.Result) bool {
+	return body.Get("stream").Bool()
+}
+
+// ExtractKey 内容为内容块数组时只取其中的文本块，image_url、input_audio 等非文本块由 ExtractMediaDigest 处理
+func (o *OpenAIProtocol) ExtractKey(body gjson.Result) string {
+	return contentText(body.Get(o.config.KeyFrom), openAITextPart)
+}
+
+func (o *OpenAIProtocol) ExtractMediaDigest(body gjson.Result) string {
+	return contentMediaDigest(body.Get(o.config.KeyFrom), openAITextPart)
+}
+
+func (o *OpenAIProtocol) ExtractTools(body gjson.Result) string {
+	return joinFields(body, "tools", "tool_choice", "functions", "function_call")
+}
+
+// ExtractInstructions 系统指令是 messages 中的消息，没有消息之外的系统指令
+func (o *OpenAIProtocol) ExtractInstructions(body gjson.Result) string {
+	return ""
+}
+
+func (o *OpenAIProtocol) ParseResponse(body []byte) *Answer {
+	bodyJson := gjson.ParseBytes(body)
+	answer := &Answer{
+		Content:      bodyJson.Get(o.config.ValueFrom).String(),
+		FinishReason: bodyJson.Get("choices.0.finish_reason").String(),
+	}
+	for _, toolCall := range bodyJson.Get("choices.0.message.tool_calls").Array() {
+		answer.ToolCalls = append(answer.ToolCalls, ToolCall{
+			ID:   toolCall.Get("id").String(),
+			Type: toolCall.Get("type").String(),
+			Function: ToolCallFunction{
+				Name:      toolCall.Get("function.name").String(),
+				Arguments: toolCall.Get("function.arguments").String(),
+			},
+		})
+	}
+	if functionCall := bodyJson.Get("choices.0.message.function_call"); functionCall.IsObject() {
+		answer.FunctionCall = &ToolCallFunction{
+			Name:      functionCall.Get("name").String(),
+			Arguments: functionCall.Get("arguments").String(),
+		}
+	}
+	if answer.Content == "" && !answer.HasCalls() {
+		return nil
+	}
+	return answer
+}
+
+func (o *OpenAIProtocol) NewStreamAccumulator(body gjson.Result) *StreamAccumulator {
+	return &StreamAccumulator{}
+}
+
+func (o *OpenAIProtocol) ProcessStreamEvent(event sse.Event, accumulator *StreamAccumulator) {
+	if event.IsDone() {
+		accumulator.MarkDone()
+		return
+	}
+	bodyJson := gjson.Parse(event.Data)
+	if bodyJson.Get("error").Exists() {
+		accumulator.MarkFailed()
+		return
+	}
+	if finishReason := bodyJson.Get("choices.0.finish_reason"); finishReason.Type == gjson.String {
+		accumulator.SetFinishReason(finishReason.String())
+	}
+	accumulator.AppendContent(bodyJson.Get(o.config.StreamValueFrom).String())
+	for _, delta := range bodyJson.Get("choices.0.delta.tool_calls").Array() {
+		accumulator.AppendToolCallDelta(
+			int(delta.Get("index").Int()),
+			delta.Get("id").String(),
+			delta.Get("type").String(),
+			delta.Get("function.name").String(),
+			delta.Get("function.arguments").String(),
+		)
+	}
+	if functionCall := bodyJson.Get("choices.0.delta.function_call"); functionCall.Exists() {
+		accumulator.AppendFunctionCallDelta(functionCall.Get("name").String(), functionCall.Get("arguments").String())
+	}
+}
+
+// BuildResponse 在模版的基础上补齐 finish_reason、tool_calls 和 function_call
+func (o *OpenAIProtocol) BuildResponse(answer *Answer) []byte {
+	body := fmt.Sprintf(o.config.ResponseTemplate, escapeJsonString(answer.Content))
+	if !gjson.Get(body, "choices.0").Exists() {
+		return []byte(body)
+	}
+	body, _ = sjson.Set(body, "choices.0.finish_reason", answer.GetFinishReason())
+	if len(answer.ToolCalls) > 0 {
+		toolCalls, _ := json.Marshal(answer.ToolCalls)
+		body, _ = sjson.SetRaw(body, "choices.0.message.tool_calls", string(toolCalls))
+	}
+	if answer.FunctionCall != nil {
+		body, _ = sjson.Set(body, "choices.0.message.function_call", answer.FunctionCall)
+	}
+	if answer.HasCalls() && answer.Content == "" {
+		body, _ = sjson.SetRaw(body, "choices.0.message.content", "null")
+	}
+	return []byte(body)
+}
+
+func (o *OpenAIProtocol) StreamContentType(path string) string {
+	return contentTypeEventStream
+}
+
+// BuildStreamResponse 在模版中第一个携带 choices 的 data 事件上补齐 finish_reason、tool_calls 和 function_call
+func (o *OpenAIProtocol) BuildStreamResponse(path string, answer *Answer) []byte {
+	events := strings.Split(fmt.Sprintf(o.config.StreamResponseTemplate, escapeJsonString(answer.Content)), "\n\n")
+	for i, event := range events {
+		if !strings.HasPrefix(event, "data:") {
+			continue
+		}
+		data := strings.TrimPrefix(event[len("data:"):], " ")
+		if !gjson.Get(data, "choices.0").Exists() {
+			continue
+		}
+		data, _ = sjson.Set(data, "choices.0.finish_reason", answer.GetFinishReason())
+		if len(answer.ToolCalls) > 0 {
+			toolCalls := make([]streamToolCall, 0, len(answer.ToolCalls))
+			for index, toolCall := range answer.ToolCalls {
+				toolCalls = append(toolCalls, streamToolCall{Index: index, ToolCall: toolCall})
+			}
+			toolCallsRaw, _ := json.Marshal(toolCalls)
+			data, _ = sjson.SetRaw(data, "choices.0.delta.tool_calls", string(toolCallsRaw))
+		}
+		if answer.FunctionCall != nil {
+			data, _ = sjson.Set(data, "choices.0.delta.function_call", answer.FunctionCall)
+		}
+		if answer.HasCalls() && answer.Content == "" {
+			data, _ = sjson.SetRaw(data, "choices.0.delta.content", "null")
+		}
+		events[i] = "data:" + data
+		break
+	}
+	return []byte(strings.Join(events, "\n\n"))
+}
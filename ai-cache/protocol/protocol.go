@@ -0,0 +1,178 @@
+// 这个文件中定义协议适配层的接口，协议适配层位于缓存逻辑之前
+// 负责从不同大模型接口的请求中提取缓存 key，从响应中提取回答，以及在命中缓存时按对应接口的格式构造响应
+// 这样同一套 redis 和向量数据库可以同时服务于不同协议的路由
+package protocol
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/alibaba/higress/plugins/wasm-go/extensions/ai-cache/sse"
+	"github.com/tidwall/gjson"
+)
+
+const (
+	// 根据请求路径自动选择协议
+	ProtocolTypeAuto      = "auto"
+	ProtocolTypeOpenAI    = "openai"
+	ProtocolTypeAnthropic = "anthropic"
+	ProtocolTypeGemini    = "gemini"
+	ProtocolTypeDashScope = "dashscope"
+	// OpenAI 旧版 Completions 接口以及 Responses 接口
+	ProtocolTypeOpenAICompletions = "openai-completions"
+	ProtocolTypeOpenAIResponses   = "openai-responses"
+
+	contentTypeEventStream = "text/event-stream; charset=utf-8"
+)
+
+type protocolInitializer interface {
+	CreateProtocol(ProtocolConfig) (Protocol, error)
+}
+
+var (
+	protocolInitializers = map[string]protocolInitializer{
+		ProtocolTypeOpenAI:            &openAIProtocolInitializer{},
+		ProtocolTypeAnthropic:         &anthropicProtocolInitializer{},
+		ProtocolTypeGemini:            &geminiProtocolInitializer{},
+		ProtocolTypeDashScope:         &dashScopeProtocolInitializer{},
+		ProtocolTypeOpenAICompletions: &completionsProtocolInitializer{},
+		ProtocolTypeOpenAIResponses:   &responsesProtocolInitializer{},
+	}
+	// 自动选择协议时按顺序匹配请求路径，都不匹配时使用 OpenAI 协议
+	autoProtocolTypes = []string{
+		ProtocolTypeAnthropic,
+		ProtocolTypeGemini,
+		ProtocolTypeDashScope,
+		ProtocolTypeOpenAIResponses,
+		ProtocolTypeOpenAICompletions,
+		ProtocolTypeOpenAI,
+	}
+)
+
+// ProtocolConfig 定义创建协议适配器所需的配置
+type ProtocolConfig struct {
+	// 从请求 Body 中提取缓存 key 的 GJSON PATH，仅 OpenAI 协议使用
+	KeyFrom string
+	// 从非流式响应 Body 中提取回答的 GJSON PATH，仅 OpenAI 协议使用
+	ValueFrom string
+	// 从流式响应事件中提取回答增量的 GJSON PATH，仅 OpenAI 协议使用
+	StreamValueFrom string
+	// 非流式请求命中缓存时的响应模版，仅 OpenAI 协议使用
+	ResponseTemplate string
+	// 流式请求命中缓存时的响应模版，仅 OpenAI 协议使用
+	StreamResponseTemplate string
+}
+
+type Protocol interface {
+	GetProtocolType() string
+	// MatchPath 判断请求路径是否属于该协议，用于自动选择协议
+	MatchPath(path string) bool
+	// IsStreamRequest 根据请求路径、请求头和请求 Body 判断请求是否要求流式响应
+	IsStreamRequest(path string, headers [][2]string, body gjson.Result) bool
+	// ExtractKey 从请求中提取缓存 key，提取失败时返回空字符串
+	ExtractKey(body gjson.Result) string
+	// ExtractMediaDigest 从请求中提取用作缓存 key 的消息里非文本内容的摘要，用于参与缓存 key 的计算，没有时返回空字符串
+	ExtractMediaDigest(body gjson.Result) string
+	// ExtractTools 从请求中提取影响工具调用的定义，用于参与缓存 key 的计算，没有时返回空字符串
+	ExtractTools(body gjson.Result) string
+	// ExtractInstructions 从请求中提取位于消息之外的系统指令，例如 Anthropic 的 system，用于参与缓存 key 的计算，没有时返回空字符串
+	ExtractInstructions(body gjson.Result) string
+	// ParseResponse 从非流式响应中提取回答，提取失败时返回 nil
+	ParseResponse(body []byte) *Answer
+	// NewStreamAccumulator 根据请求创建累积流式响应的 accumulator
+	NewStreamAccumulator(body gjson.Result) *StreamAccumulator
+	// ProcessStreamEvent 处理流式响应中的一个事件，将增量合并到 accumulator 中
+	ProcessStreamEvent(event sse.Event, accumulator *StreamAccumulator)
+	// BuildResponse 构造非流式请求命中缓存时的响应
+	BuildResponse(answer *Answer) []byte
+	// StreamContentType 返回流式请求命中缓存时响应的 content-type
+	StreamContentType(path string) string
+	// BuildStreamResponse 构造流式请求命中缓存时的响应
+	BuildStreamResponse(path string, answer *Answer) []byte
+}
+
+// Selector 持有已创建的协议适配器，按配置或请求路径选择协议
+type Selector struct {
+	typ       string
+	protocols map[string]Protocol
+}
+
+func NewSelector(typ string, config ProtocolConfig) (*Selector, error) {
+	if typ == "" {
+		typ = ProtocolTypeAuto
+	}
+	if _, has := protocolInitializers[typ]; !has && typ != ProtocolTypeAuto {
+		return nil, errors.New("unknown protocol type: " + typ)
+	}
+	selector := &Selector{typ: typ, protocols: make(map[string]Protocol)}
+	for protocolType, initializer := range protocolInitializers {
+		protocol, err := initializer.CreateProtocol(config)
+		if err != nil {
+			return nil, err
+		}
+		selector.protocols[protocolType] = protocol
+	}
+	return selector, nil
+}
+
+// Select 返回请求应使用的协议
+func (s *Selector) Select(path string) Protocol {
+	if s.typ != ProtocolTypeAuto {
+		return s.protocols[s.typ]
+	}
+	// strip the query string, e.g. "?alt=sse"
+	if index := strings.IndexByte(path, '?'); index >= 0 {
+		path = path[:index]
+	}
+	for _, protocolType := range autoProtocolTypes {
+		if protocol := s.protocols[protocolType]; protocol.MatchPath(path) {
+			return protocol
+		}
+	}
+	return s.protocols[ProtocolTypeOpenAI]
+}
+
+// IsValidProtocolType 判断协议类型是否合法
+func IsValidProtocolType(typ string) bool {
+	if typ == "" || typ == ProtocolTypeAuto {
+		return true
+	}
+	_, has := protocolInitializers[typ]
+	return has
+}
+
+// 将请求中的多个字段拼接为参与缓存 key 计算的内容，字段不存在时跳过
+func joinFields(body gjson.Result, fields ...string) string {
+	var builder strings.Builder
+	for _, field := range fields {
+		value := body.Get(field + "|@ugly")
+		if !value.Exists() {
+			continue
+		}
+		builder.WriteString(field)
+		builder.WriteByte(0)
+		builder.WriteString(value.Raw)
+		builder.WriteByte(0)
+	}
+	return builder.String()
+}
+
+// 将字符串转义为可以直接放入 JSON 字符串字面量中的形式（不含首尾引号）
+func escapeJsonString(source string) string {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	_ = encoder.Encode(source)
+	escaped := strings.TrimSuffix(buf.String(), "\n")
+	return escaped[1 : len(escaped)-1]
+}
+
+// 将 JSON 内容压缩为一行，用于工具调用参数的规范化
+func compactJson(raw string) string {
+	if raw == "" {
+		return ""
+	}
+	return gjson.Get(raw, "@ugly").Raw
+}
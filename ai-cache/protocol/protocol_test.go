@@ -0,0 +1,484 @@
+package protocol
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/alibaba/higress/plugins/wasm-go/extensions/ai-cache/sse"
+	"github.com/tidwall/gjson"
+)
+
+const (
+	openAIPath           = "/v1/chat/completions"
+	anthropicPath        = "/v1/messages"
+	geminiPath           = "/v1beta/models/gemini-pro:generateContent"
+	geminiStreamPath     = "/v1beta/models/gemini-pro:streamGenerateContent"
+	geminiStreamSSEPath  = "/v1beta/models/gemini-pro:streamGenerateContent?alt=sse"
+	dashScopePath        = "/api/v1/services/aigc/text-generation/generation"
+	completionsPath      = "/v1/completions"
+	responsesPath        = "/v1/responses"
+	testResponseTemplate = `{"id":"from-cache","choices":[{"index":0,"message":{"role":"assistant","content":"%s"},"finish_reason":"stop"}],"model":"gpt-4o","object":"chat.completion","usage":{"prompt_tokens":0,"completion_tokens":0,"total_tokens":0}}`
+	testStreamTemplate   = `data:{"id":"from-cache","choices":[{"index":0,"delta":{"role":"assistant","content":"%s"},"finish_reason":"stop"}],"model":"gpt-4o","object":"chat.completion.chunk","usage":{"prompt_tokens":0,"completion_tokens":0,"total_tokens":0}}` + "\n\ndata:[DONE]\n\n"
+	testKeyFrom          = "messages.@reverse.0.content"
+	testValueFrom        = "choices.0.message.content"
+	testStreamValueFrom  = "choices.0.delta.content"
+)
+
+// 与插件的默认配置相同的自动选择协议的 Selector
+func newTestSelector(t *testing.T) *Selector {
+	selector, err := NewSelector(ProtocolTypeAuto, ProtocolConfig{
+		KeyFrom:                testKeyFrom,
+		ValueFrom:              testValueFrom,
+		StreamValueFrom:        testStreamValueFrom,
+		ResponseTemplate:       testResponseTemplate,
+		StreamResponseTemplate: testStreamTemplate,
+	})
+	if err != nil {
+		t.Fatalf("NewSelector() error = %v", err)
+	}
+	return selector
+}
+
+func TestSelectorSelect(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{path: openAIPath, want: ProtocolTypeOpenAI},
+		{path: anthropicPath, want: ProtocolTypeAnthropic},
+		{path: geminiPath, want: ProtocolTypeGemini},
+		{path: geminiStreamSSEPath, want: ProtocolTypeGemini},
+		{path: dashScopePath, want: ProtocolTypeDashScope},
+		{path: completionsPath, want: ProtocolTypeOpenAICompletions},
+		{path: responsesPath, want: ProtocolTypeOpenAIResponses},
+		{path: "/custom/path", want: ProtocolTypeOpenAI},
+	}
+	selector := newTestSelector(t)
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			if got := selector.Select(tt.path).GetProtocolType(); got != tt.want {
+				t.Fatalf("Select(%q) = %s, want %s", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewSelectorUnknownType(t *testing.T) {
+	if _, err := NewSelector("unknown", ProtocolConfig{}); err == nil {
+		t.Fatal("NewSelector() error = nil, want an error")
+	}
+	if IsValidProtocolType("unknown") || !IsValidProtocolType("") || !IsValidProtocolType(ProtocolTypeGemini) {
+		t.Fatal("IsValidProtocolType() returned an unexpected result")
+	}
+}
+
+func TestIsStreamRequest(t *testing.T) {
+	tests := []struct {
+		name    string
+		path    string
+		headers [][2]string
+		body    string
+		want    bool
+	}{
+		{name: "openai stream", path: openAIPath, body: `{"stream":true}`, want: true},
+		{name: "openai", path: openAIPath, body: `{"stream":false}`},
+		{name: "gemini stream path", path: geminiStreamSSEPath, body: `{}`, want: true},
+		{name: "gemini", path: geminiPath, body: `{}`},
+		{name: "dashscope sse header", path: dashScopePath, headers: [][2]string{{"X-DashScope-SSE", "enable"}}, body: `{}`, want: true},
+		{name: "dashscope sse disabled", path: dashScopePath, headers: [][2]string{{"x-dashscope-sse", "disable"}}, body: `{}`},
+		{name: "dashscope without header", path: dashScopePath, body: `{"stream":true}`},
+	}
+	selector := newTestSelector(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := selector.Select(tt.path).IsStreamRequest(tt.path, tt.headers, gjson.Parse(tt.body)); got != tt.want {
+				t.Fatalf("IsStreamRequest() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractKey(t *testing.T) {
+	tests := []struct {
+		name      string
+		path      string
+		body      string
+		want      string
+		wantMedia bool
+		wantTools bool
+	}{
+		{
+			name: "openai last message",
+			path: openAIPath,
+			body: `{"messages":[{"role":"user","content":"first"},{"role":"user","content":"second"}]}`,
+			want: "second",
+		},
+		{
+			name:      "openai content parts",
+			path:      openAIPath,
+			body:      `{"messages":[{"role":"user","content":[{"type":"text","text":"what is"},{"type":"image_url","image_url":{"url":"https://example.com/a.png"}},{"type":"text","text":"this"}]}],"tools":[{"type":"function","function":{"name":"f"}}]}`,
+			want:      "what is\nthis",
+			wantMedia: true,
+			wantTools: true,
+		},
+		{
+			name: "anthropic text blocks",
+			path: anthropicPath,
+			body: `{"system":"be brief","messages":[{"role":"user","content":[{"type":"text","text":"hello"}]}]}`,
+			want: "hello",
+		},
+		{
+			name: "gemini text parts",
+			path: geminiPath,
+			body: `{"contents":[{"role":"user","parts":[{"text":"old"}]},{"role":"user","parts":[{"text":"new"}]}]}`,
+			want: "new",
+		},
+		{
+			name: "dashscope messages",
+			path: dashScopePath,
+			body: `{"model":"qwen-turbo","input":{"messages":[{"role":"user","content":"hi"}]}}`,
+			want: "hi",
+		},
+		{
+			name: "dashscope prompt",
+			path: dashScopePath,
+			body: `{"model":"qwen-turbo","input":{"prompt":"hi"}}`,
+			want: "hi",
+		},
+		{
+			name: "completions prompt",
+			path: completionsPath,
+			body: `{"prompt":"say hi"}`,
+			want: "say hi",
+		},
+		{
+			name: "completions multiple prompts are not cached",
+			path: completionsPath,
+			body: `{"prompt":["a","b"]}`,
+			want: "",
+		},
+		{
+			name: "responses string input",
+			path: responsesPath,
+			body: `{"input":"hello","instructions":"be brief"}`,
+			want: "hello",
+		},
+		{
+			name: "responses last input item",
+			path: responsesPath,
+			body: `{"input":[{"role":"user","content":"old"},{"role":"user","content":[{"type":"input_text","text":"new"}]}]}`,
+			want: "new",
+		},
+		{
+			name: "responses with previous response id are not cached",
+			path: responsesPath,
+			body: `{"input":"hello","previous_response_id":"resp_123"}`,
+			want: "",
+		},
+		{
+			name: "responses in a conversation are not cached",
+			path: responsesPath,
+			body: `{"input":"hello","conversation":"conv_123"}`,
+			want: "",
+		},
+	}
+	selector := newTestSelector(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			protocol := selector.Select(tt.path)
+			body := gjson.Parse(tt.body)
+			if got := protocol.ExtractKey(body); got != tt.want {
+				t.Fatalf("ExtractKey() = %q, want %q", got, tt.want)
+			}
+			if got := protocol.ExtractMediaDigest(body); (got != "") != tt.wantMedia {
+				t.Fatalf("ExtractMediaDigest() = %q, want media %v", got, tt.wantMedia)
+			}
+			if got := protocol.ExtractTools(body); (got != "") != tt.wantTools {
+				t.Fatalf("ExtractTools() = %q, want tools %v", got, tt.wantTools)
+			}
+		})
+	}
+}
+
+// 系统指令不同的请求必须得到不同的摘要，只有格式不同的系统指令得到相同的摘要
+func TestExtractInstructions(t *testing.T) {
+	tests := []struct {
+		name      string
+		path      string
+		body      string
+		other     string
+		wantEqual bool
+	}{
+		{
+			name:  "anthropic system",
+			path:  anthropicPath,
+			body:  `{"system":"answer in english","messages":[{"role":"user","content":"hi"}]}`,
+			other: `{"system":"answer in french","messages":[{"role":"user","content":"hi"}]}`,
+		},
+		{
+			name:  "anthropic with and without system",
+			path:  anthropicPath,
+			body:  `{"system":"answer in english","messages":[{"role":"user","content":"hi"}]}`,
+			other: `{"messages":[{"role":"user","content":"hi"}]}`,
+		},
+		{
+			name:      "anthropic system blocks ignore cache control",
+			path:      anthropicPath,
+			body:      `{"system":[{"type":"text","text":"answer in english","cache_control":{"type":"ephemeral"}}]}`,
+			other:     `{"system":"answer in english"}`,
+			wantEqual: true,
+		},
+		{
+			name:  "gemini system instruction",
+			path:  geminiPath,
+			body:  `{"systemInstruction":{"parts":[{"text":"a"}]}}`,
+			other: `{"systemInstruction":{"parts":[{"text":"b"}]}}`,
+		},
+		{
+			name:  "responses instructions",
+			path:  responsesPath,
+			body:  `{"input":"hi","instructions":"answer in english"}`,
+			other: `{"input":"hi","instructions":"answer in french"}`,
+		},
+		{
+			name:      "openai system messages are part of the messages",
+			path:      openAIPath,
+			body:      `{"messages":[{"role":"system","content":"a"},{"role":"user","content":"hi"}]}`,
+			other:     `{"messages":[{"role":"user","content":"hi"}]}`,
+			wantEqual: true,
+		},
+	}
+	selector := newTestSelector(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			protocol := selector.Select(tt.path)
+			got := protocol.ExtractInstructions(gjson.Parse(tt.body))
+			other := protocol.ExtractInstructions(gjson.Parse(tt.other))
+			if (got == other) != tt.wantEqual {
+				t.Fatalf("ExtractInstructions() = %q and %q, want equal %v", got, other, tt.wantEqual)
+			}
+		})
+	}
+}
+
+// parseStreamResponse 按响应阶段的方式解析流式响应，SSE 响应逐个事件累积，其余响应整体解析
+func parseStreamResponse(t *testing.T, protocol Protocol, path string, body []byte) *Answer {
+	if !strings.Contains(protocol.StreamContentType(path), "text/event-stream") {
+		return protocol.ParseResponse(body)
+	}
+	accumulator := protocol.NewStreamAccumulator(gjson.Parse(`{}`))
+	for _, event := range sse.NewDecoder().Feed(body) {
+		protocol.ProcessStreamEvent(event, accumulator)
+	}
+	if !accumulator.Completed() {
+		t.Fatalf("the stream response is not completed: %s", body)
+	}
+	return accumulator.Answer()
+}
+
+func assertAnswer(t *testing.T, got, want *Answer) {
+	t.Helper()
+	if got == nil {
+		t.Fatal("answer = nil")
+	}
+	if got.Content != want.Content {
+		t.Fatalf("content = %q, want %q", got.Content, want.Content)
+	}
+	if got.GetFinishReason() != want.GetFinishReason() {
+		t.Fatalf("finish reason = %q, want %q", got.GetFinishReason(), want.GetFinishReason())
+	}
+	if len(got.ToolCalls) != len(want.ToolCalls) {
+		t.Fatalf("tool calls = %+v, want %+v", got.ToolCalls, want.ToolCalls)
+	}
+	for i := range want.ToolCalls {
+		if got.ToolCalls[i].Function.Name != want.ToolCalls[i].Function.Name ||
+			compactJson(got.ToolCalls[i].Function.Arguments) != compactJson(want.ToolCalls[i].Function.Arguments) {
+			t.Fatalf("tool call %d = %+v, want %+v", i, got.ToolCalls[i], want.ToolCalls[i])
+		}
+	}
+}
+
+// 命中时构造的响应可以被同一个协议重新解析为相同的回答
+func TestResponseRoundTrip(t *testing.T) {
+	text := &Answer{Content: "你好，\"world\"\n<b>&</b>", FinishReason: FinishReasonStop}
+	truncated := &Answer{Content: "partial", FinishReason: FinishReasonLength}
+	toolCall := &Answer{
+		FinishReason: FinishReasonToolCalls,
+		ToolCalls: []ToolCall{{
+			ID:       "call_1",
+			Type:     "function",
+			Function: ToolCallFunction{Name: "get_weather", Arguments: `{"city":"hangzhou"}`},
+		}},
+	}
+	tests := []struct {
+		name       string
+		path       string
+		streamPath string
+		answers    []*Answer
+	}{
+		{name: "openai", path: openAIPath, streamPath: openAIPath, answers: []*Answer{text, truncated, toolCall}},
+		{name: "anthropic", path: anthropicPath, streamPath: anthropicPath, answers: []*Answer{text, truncated, toolCall}},
+		{name: "gemini sse", path: geminiPath, streamPath: geminiStreamSSEPath, answers: []*Answer{text, truncated, toolCall}},
+		{name: "gemini json array", path: geminiPath, streamPath: geminiStreamPath, answers: []*Answer{text, truncated}},
+		{name: "dashscope", path: dashScopePath, streamPath: dashScopePath, answers: []*Answer{text, truncated}},
+		{name: "completions", path: completionsPath, streamPath: completionsPath, answers: []*Answer{text, truncated}},
+		{name: "responses", path: responsesPath, streamPath: responsesPath, answers: []*Answer{text, toolCall}},
+	}
+	selector := newTestSelector(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			protocol := selector.Select(tt.path)
+			for _, answer := range tt.answers {
+				assertAnswer(t, protocol.ParseResponse(protocol.BuildResponse(answer)), answer)
+				assertAnswer(t, parseStreamResponse(t, protocol, tt.streamPath, protocol.BuildStreamResponse(tt.streamPath, answer)), answer)
+			}
+		})
+	}
+}
+
+func TestParseResponseWithoutAnswer(t *testing.T) {
+	tests := []struct {
+		name string
+		path string
+		body string
+	}{
+		{name: "openai empty content", path: openAIPath, body: `{"choices":[{"message":{"content":""}}]}`},
+		{name: "anthropic error", path: anthropicPath, body: `{"type":"error","error":{"type":"overloaded_error"}}`},
+		{name: "not json", path: openAIPath, body: `upstream error`},
+	}
+	selector := newTestSelector(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if answer := selector.Select(tt.path).ParseResponse([]byte(tt.body)); answer != nil {
+				t.Fatalf("ParseResponse() = %+v, want nil", answer)
+			}
+		})
+	}
+}
+
+func TestProcessStreamEvent(t *testing.T) {
+	tests := []struct {
+		name          string
+		path          string
+		request       string
+		stream        string
+		want          *Answer
+		wantCompleted bool
+	}{
+		{
+			name:          "openai deltas",
+			path:          openAIPath,
+			stream:        "data: {\"choices\":[{\"delta\":{\"content\":\"Hel\"}}]}\n\ndata: {\"choices\":[{\"delta\":{\"content\":\"lo\"},\"finish_reason\":\"stop\"}]}\n\ndata: [DONE]\n\n",
+			want:          &Answer{Content: "Hello", FinishReason: FinishReasonStop},
+			wantCompleted: true,
+		},
+		{
+			name: "openai tool call fragments",
+			path: openAIPath,
+			stream: "data: {\"choices\":[{\"delta\":{\"tool_calls\":[{\"index\":0,\"id\":\"call_1\",\"type\":\"function\",\"function\":{\"name\":\"get_weather\",\"arguments\":\"{\\\"ci\"}}]}}]}\n\n" +
+				"data: {\"choices\":[{\"delta\":{\"tool_calls\":[{\"index\":1,\"id\":\"call_2\",\"function\":{\"name\":\"get_time\",\"arguments\":\"{}\"}}]}}]}\n\n" +
+				"data: {\"choices\":[{\"delta\":{\"tool_calls\":[{\"index\":0,\"function\":{\"arguments\":\"ty\\\":\\\"hz\\\"}\"}}]},\"finish_reason\":\"tool_calls\"}]}\n\n",
+			want: &Answer{FinishReason: FinishReasonToolCalls, ToolCalls: []ToolCall{
+				{Function: ToolCallFunction{Name: "get_weather", Arguments: `{"city":"hz"}`}},
+				{Function: ToolCallFunction{Name: "get_time", Arguments: `{}`}},
+			}},
+			wantCompleted: true,
+		},
+		{
+			name: "openai tool call with an out of range index",
+			path: openAIPath,
+			stream: "data: {\"choices\":[{\"delta\":{\"tool_calls\":[{\"index\":0,\"function\":{\"name\":\"get_weather\",\"arguments\":\"{}\"}}]}}]}\n\n" +
+				"data: {\"choices\":[{\"delta\":{\"tool_calls\":[{\"index\":5,\"function\":{\"name\":\"skipped\"}}]}}]}\n\n" +
+				"data: {\"choices\":[{\"delta\":{\"tool_calls\":[{\"index\":1000000000,\"function\":{\"name\":\"skipped\"}}]},\"finish_reason\":\"tool_calls\"}]}\n\n",
+			want: &Answer{FinishReason: FinishReasonToolCalls, ToolCalls: []ToolCall{
+				{Function: ToolCallFunction{Name: "get_weather", Arguments: `{}`}},
+			}},
+			wantCompleted: true,
+		},
+		{
+			name:   "openai truncated stream",
+			path:   openAIPath,
+			stream: "data: {\"choices\":[{\"delta\":{\"content\":\"Hel\"}}]}\n\n",
+			want:   &Answer{Content: "Hel"},
+		},
+		{
+			name:   "openai error event",
+			path:   openAIPath,
+			stream: "data: {\"choices\":[{\"delta\":{\"content\":\"Hel\"}}]}\n\ndata: {\"error\":{\"message\":\"overloaded\"}}\n\ndata: [DONE]\n\n",
+			want:   &Answer{Content: "Hel"},
+		},
+		{
+			name: "anthropic named events",
+			path: anthropicPath,
+			stream: "event: message_start\ndata: {\"type\":\"message_start\",\"message\":{}}\n\n" +
+				"event: content_block_start\ndata: {\"type\":\"content_block_start\",\"index\":0,\"content_block\":{\"type\":\"text\",\"text\":\"\"}}\n\n" +
+				"event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":0,\"delta\":{\"type\":\"text_delta\",\"text\":\"Hi\"}}\n\n" +
+				"event: content_block_stop\ndata: {\"type\":\"content_block_stop\",\"index\":0}\n\n" +
+				"event: message_delta\ndata: {\"type\":\"message_delta\",\"delta\":{\"stop_reason\":\"end_turn\"}}\n\n" +
+				"event: message_stop\ndata: {\"type\":\"message_stop\"}\n\n",
+			want:          &Answer{Content: "Hi", FinishReason: FinishReasonStop},
+			wantCompleted: true,
+		},
+		{
+			name:    "dashscope cumulative output",
+			path:    dashScopePath,
+			request: `{"parameters":{"incremental_output":false}}`,
+			stream: "data:{\"output\":{\"text\":\"He\"}}\n\n" +
+				"data:{\"output\":{\"text\":\"Hello\",\"finish_reason\":\"stop\"}}\n\n",
+			want:          &Answer{Content: "Hello", FinishReason: FinishReasonStop},
+			wantCompleted: true,
+		},
+		{
+			name:    "dashscope incremental output",
+			path:    dashScopePath,
+			request: `{"parameters":{"incremental_output":true}}`,
+			stream: "data:{\"output\":{\"text\":\"He\"}}\n\n" +
+				"data:{\"output\":{\"text\":\"llo\",\"finish_reason\":\"stop\"}}\n\n",
+			want:          &Answer{Content: "Hello", FinishReason: FinishReasonStop},
+			wantCompleted: true,
+		},
+	}
+	selector := newTestSelector(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			protocol := selector.Select(tt.path)
+			request := tt.request
+			if request == "" {
+				request = `{}`
+			}
+			accumulator := protocol.NewStreamAccumulator(gjson.Parse(request))
+			decoder := sse.NewDecoder()
+			// 按固定大小拆分 chunk，事件会在任意位置被切开
+			stream := []byte(tt.stream)
+			for start := 0; start < len(stream); start += 7 {
+				end := start + 7
+				if end > len(stream) {
+					end = len(stream)
+				}
+				for _, event := range decoder.Feed(stream[start:end]) {
+					protocol.ProcessStreamEvent(event, accumulator)
+				}
+			}
+			if accumulator.Completed() != tt.wantCompleted {
+				t.Fatalf("Completed() = %v, want %v", accumulator.Completed(), tt.wantCompleted)
+			}
+			answer := accumulator.Answer()
+			if answer.Content != tt.want.Content || answer.FinishReason != tt.want.FinishReason {
+				t.Fatalf("Answer() = %+v, want %+v", answer, tt.want)
+			}
+			if len(tt.want.ToolCalls) > 0 {
+				assertAnswer(t, answer, tt.want)
+			}
+		})
+	}
+}
+
+func TestAppendToolCallDeltaLimit(t *testing.T) {
+	accumulator := &StreamAccumulator{}
+	for i := 0; i <= maxToolCalls; i++ {
+		accumulator.AppendToolCallDelta(i, "", "", "call", "")
+	}
+	if got := len(accumulator.Answer().ToolCalls); got != maxToolCalls {
+		t.Fatalf("len(ToolCalls) = %d, want %d", got, maxToolCalls)
+	}
+}
@@ -0,0 +1,248 @@
+// OpenAI Responses 协议：/v1/responses
+// 请求中的 input 为字符串或输入项数组，回答位于 output[].content[].text，流式响应使用 response.output_text.delta 等带类型的事件
+package protocol
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/alibaba/higress/plugins/wasm-go/extensions/ai-cache/sse"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+const (
+	openAIResponsesPathSuffix = "/responses"
+
+	responsesStatusCompleted  = "completed"
+	responsesStatusIncomplete = "incomplete"
+
+	responsesResponseTemplate = `{"id":"resp_from_cache","object":"response","created_at":0,"status":"completed","error":null,"incomplete_details":null,"model":"from-cache","output":[],"usage":{"input_tokens":0,"output_tokens":0,"total_tokens":0}}`
+	responsesMessageTemplate  = `{"type":"message","id":"msg_from_cache","status":"completed","role":"assistant","content":[]}`
+)
+
+var responsesTextPart = typedTextPart("input_text", "output_text", "text")
+
+type responsesProtocolInitializer struct {
+}
+
+func (r *responsesProtocolInitializer) CreateProtocol(config ProtocolConfig) (Protocol, error) {
+	return &ResponsesProtocol{}, nil
+}
+
+type ResponsesProtocol struct {
+}
+
+func (r *ResponsesProtocol) GetProtocolType() string {
+	return ProtocolTypeOpenAIResponses
+}
+
+func (r *ResponsesProtocol) MatchPath(path string) bool {
+	return strings.HasSuffix(path, openAIResponsesPathSuffix)
+}
+
+func (r *ResponsesProtocol) IsStreamRequest(path string, headers [][2]string, body gjson.Result) bool {
+	return body.Get("stream").Bool()
+}
+
+// ExtractKey 使用 input 中最后一个输入项的文本作为缓存 key，input_image、input_file 等内容块由 ExtractMediaDigest 处理
+// 带 previous_response_id 或 conversation 的请求延续保存在上游的对话，回答取决于请求中看不到的上下文，不做缓存
+func (r *ResponsesProtocol) ExtractKey(body gjson.Result) string {
+	if body.Get("previous_response_id").String() != "" || body.Get("conversation").Exists() {
+		return ""
+	}
+	input := body.Get("input")
+	if !input.IsArray() {
+		return input.String()
+	}
+	return contentText(input.Get("@reverse.0.content"), responsesTextPart)
+}
+
+func (r *ResponsesProtocol) ExtractMediaDigest(body gjson.Result) string {
+	input := body.Get("input")
+	if !input.IsArray() {
+		return ""
+	}
+	return contentMediaDigest(input.Get("@reverse.0.content"), responsesTextPart)
+}
+
+func (r *ResponsesProtocol) ExtractTools(body gjson.Result) string {
+	return joinFields(body, "tools", "tool_choice")
+}
+
+func (r *ResponsesProtocol) ExtractInstructions(body gjson.Result) string {
+	return joinFields(body, "instructions")
+}
+
+func (r *ResponsesProtocol) ParseResponse(body []byte) *Answer {
+	accumulator := &StreamAccumulator{}
+	r.processResponse(gjson.ParseBytes(body), accumulator)
+	if !accumulator.Completed() {
+		return nil
+	}
+	answer := accumulator.Answer()
+	if answer.Content == "" && !answer.HasCalls() {
+		return nil
+	}
+	return answer
+}
+
+func (r *ResponsesProtocol) NewStreamAccumulator(body gjson.Result) *StreamAccumulator {
+	return &StreamAccumulator{}
+}
+
+// ProcessStreamEvent 合并文本和函数调用参数的增量，收到 response.completed 时使用其中完整的 response 替换已累积的回答
+func (r *ResponsesProtocol) ProcessStreamEvent(event sse.Event, accumulator *StreamAccumulator) {
+	bodyJson := gjson.Parse(event.Data)
+	eventType := bodyJson.Get("type").String()
+	if eventType == "" {
+		eventType = event.Type
+	}
+	switch eventType {
+	case "response.output_text.delta":
+		accumulator.AppendContent(bodyJson.Get("delta").String())
+	case "response.output_item.added":
+		item := bodyJson.Get("item")
+		if item.Get("type").String() == "function_call" {
+			accumulator.StartBlockToolCall(int(bodyJson.Get("output_index").Int()), item.Get("call_id").String(), item.Get("name").String())
+		}
+	case "response.function_call_arguments.delta":
+		accumulator.AppendBlockToolCallArguments(int(bodyJson.Get("output_index").Int()), bodyJson.Get("delta").String())
+	case "response.completed", "response.incomplete":
+		if response := bodyJson.Get("response"); response.IsObject() {
+			accumulator.ResetAnswer()
+			r.processResponse(response, accumulator)
+		}
+		accumulator.MarkDone()
+	case "response.failed", "error":
+		accumulator.MarkFailed()
+	}
+}
+
+func (r *ResponsesProtocol) processResponse(response gjson.Result, accumulator *StreamAccumulator) {
+	for _, item := range response.Get("output").Array() {
+		switch item.Get("type").String() {
+		case "message":
+			for _, part := range item.Get("content").Array() {
+				if part.Get("type").String() == "output_text" {
+					accumulator.AppendContent(part.Get("text").String())
+				}
+			}
+		case "function_call":
+			accumulator.AppendToolCall(item.Get("call_id").String(), item.Get("name").String(), item.Get("arguments").String())
+		}
+	}
+	switch response.Get("status").String() {
+	case responsesStatusCompleted:
+		if accumulator.HasCalls() {
+			accumulator.SetFinishReason(FinishReasonToolCalls)
+		} else {
+			accumulator.SetFinishReason(FinishReasonStop)
+		}
+	case responsesStatusIncomplete:
+		accumulator.SetFinishReason(FinishReasonLength)
+	}
+}
+
+func (r *ResponsesProtocol) BuildResponse(answer *Answer) []byte {
+	return []byte(r.buildResponse(answer, r.outputItems(answer)))
+}
+
+func (r *ResponsesProtocol) StreamContentType(path string) string {
+	return contentTypeEventStream
+}
+
+// BuildStreamResponse 按 Responses 接口的事件顺序构造流式响应，每个输出项的内容在一个 delta 事件中下发
+func (r *ResponsesProtocol) BuildStreamResponse(path string, answer *Answer) []byte {
+	var builder strings.Builder
+	sequence := 0
+	writeEvent := func(eventType, data string) {
+		data, _ = sjson.Set(data, "type", eventType)
+		data, _ = sjson.Set(data, "sequence_number", sequence)
+		sequence++
+		builder.WriteString("event: " + eventType + "\ndata: " + data + "\n\n")
+	}
+	inProgress, _ := sjson.Set(responsesResponseTemplate, "status", "in_progress")
+	created, _ := sjson.SetRaw(`{}`, "response", inProgress)
+	writeEvent("response.created", created)
+	items := r.outputItems(answer)
+	for index, item := range items {
+		itemJson := gjson.Parse(item)
+		event, _ := sjson.Set(`{}`, "output_index", index)
+		if itemJson.Get("type").String() == "message" {
+			itemID := itemJson.Get("id").String()
+			text := itemJson.Get("content.0.text").String()
+			added, _ := sjson.Set(item, "status", "in_progress")
+			added, _ = sjson.SetRaw(added, "content", "[]")
+			addedEvent, _ := sjson.SetRaw(event, "item", added)
+			writeEvent("response.output_item.added", addedEvent)
+			partEvent, _ := sjson.Set(event, "item_id", itemID)
+			partEvent, _ = sjson.Set(partEvent, "content_index", 0)
+			emptyPart, _ := sjson.SetRaw(partEvent, "part", `{"type":"output_text","text":"","annotations":[]}`)
+			writeEvent("response.content_part.added", emptyPart)
+			delta, _ := sjson.Set(partEvent, "delta", text)
+			writeEvent("response.output_text.delta", delta)
+			done, _ := sjson.Set(partEvent, "text", text)
+			writeEvent("response.output_text.done", done)
+			fullPart, _ := sjson.SetRaw(partEvent, "part", itemJson.Get("content.0").Raw)
+			writeEvent("response.content_part.done", fullPart)
+		} else {
+			itemID := itemJson.Get("id").String()
+			arguments := itemJson.Get("arguments").String()
+			added, _ := sjson.Set(item, "status", "in_progress")
+			added, _ = sjson.Set(added, "arguments", "")
+			addedEvent, _ := sjson.SetRaw(event, "item", added)
+			writeEvent("response.output_item.added", addedEvent)
+			argumentsEvent, _ := sjson.Set(event, "item_id", itemID)
+			delta, _ := sjson.Set(argumentsEvent, "delta", arguments)
+			writeEvent("response.function_call_arguments.delta", delta)
+			done, _ := sjson.Set(argumentsEvent, "arguments", arguments)
+			writeEvent("response.function_call_arguments.done", done)
+		}
+		doneEvent, _ := sjson.SetRaw(event, "item", item)
+		writeEvent("response.output_item.done", doneEvent)
+	}
+	response := r.buildResponse(answer, items)
+	eventType := "response.completed"
+	if gjson.Get(response, "status").String() == responsesStatusIncomplete {
+		eventType = "response.incomplete"
+	}
+	completed, _ := sjson.SetRaw(`{}`, "response", response)
+	writeEvent(eventType, completed)
+	return []byte(builder.String())
+}
+
+func (r *ResponsesProtocol) buildResponse(answer *Answer, items []string) string {
+	body := responsesResponseTemplate
+	for _, item := range items {
+		body, _ = sjson.SetRaw(body, "output.-1", item)
+	}
+	if answer.GetFinishReason() == FinishReasonLength {
+		body, _ = sjson.Set(body, "status", responsesStatusIncomplete)
+		body, _ = sjson.SetRaw(body, "incomplete_details", `{"reason":"max_output_tokens"}`)
+	}
+	return body
+}
+
+// 将回答转换为 Responses 接口的输出项，文本在前，函数调用在后
+func (r *ResponsesProtocol) outputItems(answer *Answer) []string {
+	var items []string
+	if answer.Content != "" || !answer.HasCalls() {
+		part, _ := sjson.Set(`{"type":"output_text","annotations":[]}`, "text", answer.Content)
+		item, _ := sjson.SetRaw(responsesMessageTemplate, "content.-1", part)
+		items = append(items, item)
+	}
+	functions := make([]ToolCall, 0, len(answer.ToolCalls)+1)
+	functions = append(functions, answer.ToolCalls...)
+	if answer.FunctionCall != nil {
+		functions = append(functions, ToolCall{Function: *answer.FunctionCall})
+	}
+	for index, toolCall := range functions {
+		item, _ := sjson.Set(`{"type":"function_call","status":"completed"}`, "id", "fc_from_cache_"+strconv.Itoa(index))
+		item, _ = sjson.Set(item, "call_id", toolCall.ID)
+		item, _ = sjson.Set(item, "name", toolCall.Function.Name)
+		item, _ = sjson.Set(item, "arguments", toolCall.Function.Arguments)
+		items = append(items, item)
+	}
+	return items
+}
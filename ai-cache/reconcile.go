@@ -0,0 +1,200 @@
+// 这个文件中实现向量数据库与缓存存储之间的孤儿向量清理
+// 回答过期或被删除后，对应的向量仍然留在向量数据库中，这样的向量称为孤儿向量，它们会被语义检索命中但无法返回回答
+//   - 语义检索命中的向量在缓存存储中没有对应的回答时，立即删除该向量，当前请求的回答会按新的 key 重新缓存
+//   - 开启 orphanSweep 后，每个周期用随机向量检索一批向量，删除其中没有对应回答的向量
+//
+// 向量数据库不支持原生过期时，还会定期删除一批已经过期的向量
+package main
+
+import (
+	"math"
+	"math/rand"
+
+	"github.com/alibaba/higress/plugins/wasm-go/extensions/ai-cache/config"
+	vectorStoreProvider "github.com/alibaba/higress/plugins/wasm-go/extensions/ai-cache/vectorStoreProvider"
+	"github.com/alibaba/higress/plugins/wasm-go/pkg/wrapper"
+)
+
+// similarVector 记录语义检索命中的向量和当前请求的 key
+type similarVector struct {
+	id  string
+	key string
+}
+
+// 每次清理的过期向量数
+const expiredVectorCleanupBatchSize = 100
+
+var (
+	// 向量的维度，在第一次得到查询文本的向量后确定，确定之前不进行定期清理
+	vectorDimension int
+	// 上一轮清理尚未结束时跳过本轮
+	orphanSweepRunning   bool
+	vectorCleanupRunning bool
+)
+
+func recordVectorDimension(dimension int) {
+	if dimension > 0 {
+		vectorDimension = dimension
+	}
+}
+
+// handleStaleVector 处理语义检索命中的条目无法返回的情况：恢复当前请求的 key，使上游的回答能够按当前请求的 key 写入缓存
+// 只有 answerGone 为 true，即缓存存储确认回答已经不存在时才删除命中的向量；查询失败、条目解压失败等情况下回答可能仍然存在，保留向量
+func handleStaleVector(ctx wrapper.HttpContext, config config.PluginConfig, log wrapper.Log, answerGone bool) {
+	similar, ok := ctx.GetContext(SimilarVectorContextKey).(similarVector)
+	if !ok {
+		return
+	}
+	ctx.SetContext(SimilarVectorContextKey, nil)
+	ctx.SetContext(CacheKeyContextKey, similar.key)
+	if similar.id == "" || !answerGone {
+		return
+	}
+	log.Infof("the most similar key has no cached answer, delete its vector, id:%s", similar.id)
+	deleteOrphanVectors(config, log, []string{similar.id}, metricOrphanVectorsDeletedOnHit)
+}
+
+func deleteOrphanVectors(config config.PluginConfig, log wrapper.Log, ids []string, metric string) {
+	deleter, ok := config.GetVectorProvider().(vectorStoreProvider.DeleteEmbedding)
+	if !ok {
+		return
+	}
+	err := deleter.DeleteEmbedding(ids, func(err error) {
+		if err != nil {
+			log.Errorf("Failed to delete orphan vectors: %v", err)
+			incrementCounter(metricOrphanVectorDeleteFailures, uint64(len(ids)))
+			return
+		}
+		incrementCounter(metric, uint64(len(ids)))
+	})
+	if err != nil {
+		log.Errorf("Failed to delete orphan vectors: %v", err)
+		incrementCounter(metricOrphanVectorDeleteFailures, uint64(len(ids)))
+	}
+}
+
+func registerOrphanSweep(config config.PluginConfig, log wrapper.Log) {
+	if !config.OrphanSweepConfig.Enabled {
+		return
+	}
+	if _, ok := config.GetVectorProvider().(vectorStoreProvider.DeleteEmbedding); !ok {
+		log.Warnf("the vector store provider does not support deleting vectors, orphan sweep is disabled")
+		return
+	}
+	wrapper.RegisteTickFunc(int64(config.OrphanSweepConfig.Interval), func() {
+		sweepOrphanVectors(config, log)
+	})
+}
+
+// sweepOrphanVectors 向量数据库不提供遍历接口，用随机方向的单位向量检索 batchSize 个向量作为抽样，逐个检查对应的回答是否存在
+func sweepOrphanVectors(config config.PluginConfig, log wrapper.Log) {
+	querier, ok := config.GetVectorProvider().(vectorStoreProvider.QueryEmbedding)
+	if !ok || vectorDimension == 0 || orphanSweepRunning {
+		return
+	}
+	orphanSweepRunning = true
+	err := querier.QueryEmbedding(vectorStoreProvider.QueryRequest{
+		Vector:       randomUnitVector(vectorDimension),
+		TopK:         config.OrphanSweepConfig.BatchSize,
+		OutputFields: []string{"query", vectorFieldScope},
+	}, func(resp vectorStoreProvider.QueryResponse, err error) {
+		if err != nil {
+			orphanSweepRunning = false
+			log.Errorf("Failed to sample vectors for orphan sweep: %v", err)
+			return
+		}
+		checkOrphanVectors(config, log, resp.Output)
+	})
+	if err != nil {
+		orphanSweepRunning = false
+		log.Errorf("Failed to sample vectors for orphan sweep: %v", err)
+	}
+}
+
+// checkOrphanVectors 检查所有抽样的向量后批量删除孤儿向量，查询缓存存储失败的向量不删除
+func checkOrphanVectors(config config.PluginConfig, log wrapper.Log, results []vectorStoreProvider.Result) {
+	incrementCounter(metricOrphanSweepScanned, uint64(len(results)))
+	var orphans []string
+	pending := len(results) + 1
+	done := func() {
+		pending--
+		if pending > 0 {
+			return
+		}
+		orphanSweepRunning = false
+		if len(orphans) > 0 {
+			log.Infof("orphan sweep found %d orphan vectors", len(orphans))
+			deleteOrphanVectors(config, log, orphans, metricOrphanVectorsDeletedBySweep)
+		}
+	}
+	for _, result := range results {
+		id := result.ID
+		key, _ := result.Fields["query"].(string)
+		if key == "" {
+			done()
+			continue
+		}
+		// 规则可以覆盖 key 的前缀和命名空间，回答的 redis key 按向量中保存的范围构造，没有保存范围的向量属于全局配置的范围
+		scope, _ := result.Fields[vectorFieldScope].(string)
+		if scope == "" {
+			scope = cacheKeyScope(config)
+		}
+		err := config.GetCacheStore().GetFields(buildScopedRedisKey(scope, answerKeyKind, key), func(fields map[string]string, err error) {
+			if err == nil && len(fields) == 0 {
+				orphans = append(orphans, id)
+			}
+			done()
+		})
+		if err != nil {
+			done()
+		}
+	}
+	done()
+}
+
+// registerExpiredVectorCleanup 在向量数据库不支持原生过期且缓存会过期时注册过期向量的定期清理
+func registerExpiredVectorCleanup(config config.PluginConfig, log wrapper.Log) {
+	provider := config.GetVectorProvider()
+	if provider == nil || provider.NativeTTL() || config.CacheTTL <= 0 {
+		return
+	}
+	cleaner, ok := provider.(vectorStoreProvider.CleanExpiredEmbedding)
+	if !ok {
+		log.Warnf("the vector store provider can not clean expired vectors")
+		return
+	}
+	wrapper.RegisteTickFunc(int64(config.VectorCleanupInterval), func() {
+		if vectorCleanupRunning {
+			return
+		}
+		vectorCleanupRunning = true
+		err := cleaner.CleanExpiredEmbedding(expiredVectorCleanupBatchSize, func(deleted int, err error) {
+			vectorCleanupRunning = false
+			if err != nil {
+				log.Errorf("Failed to clean expired vectors: %v", err)
+				incrementCounter(metricExpiredVectorCleanupFailures, 1)
+				return
+			}
+			incrementCounter(metricExpiredVectorsDeleted, uint64(deleted))
+		})
+		if err != nil {
+			vectorCleanupRunning = false
+			log.Errorf("Failed to clean expired vectors: %v", err)
+			incrementCounter(metricExpiredVectorCleanupFailures, 1)
+		}
+	})
+}
+
+func randomUnitVector(dimension int) []float64 {
+	vector := make([]float64, dimension)
+	var norm float64
+	for i := range vector {
+		vector[i] = rand.NormFloat64()
+		norm += vector[i] * vector[i]
+	}
+	norm = math.Sqrt(norm)
+	for i := range vector {
+		vector[i] /= norm
+	}
+	return vector
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestRandomUnitVector(t *testing.T) {
+	for _, dimension := range []int{1, 3, 1536} {
+		vector := randomUnitVector(dimension)
+		if len(vector) != dimension {
+			t.Fatalf("len(randomUnitVector(%d)) = %d", dimension, len(vector))
+		}
+		var norm float64
+		for _, value := range vector {
+			norm += value * value
+		}
+		if math.Abs(math.Sqrt(norm)-1) > 1e-9 {
+			t.Fatalf("norm of randomUnitVector(%d) = %v, want 1", dimension, math.Sqrt(norm))
+		}
+	}
+}
+
+func TestRecordVectorDimension(t *testing.T) {
+	defer func(dimension int) { vectorDimension = dimension }(vectorDimension)
+	vectorDimension = 0
+	recordVectorDimension(0)
+	if vectorDimension != 0 {
+		t.Fatalf("vectorDimension = %d after recording 0, want 0", vectorDimension)
+	}
+	recordVectorDimension(1536)
+	recordVectorDimension(0)
+	if vectorDimension != 1536 {
+		t.Fatalf("vectorDimension = %d, want 1536", vectorDimension)
+	}
+}
@@ -0,0 +1,274 @@
+// 这个文件中实现缓存条目的软过期和刷新（stale-while-revalidate）
+// 条目的年龄由 created_at 计算：
+//   - 超过 cacheTTL（硬过期时间）的条目永远不会返回，即使缓存存储或 L1 中还没有淘汰
+//   - 超过 cacheSoftTTL 的条目仍会返回，响应带有 x-ai-cache-status: stale
+//
+// 精确匹配命中时按概率提前过期算法（XFetch）决定是否刷新：年龄越接近软过期时间、上游生成回答越慢，刷新的概率越大，超过软过期时间后总是刷新
+// 决定刷新的请求还需要获得一个短期的刷新锁，获得锁的请求同样返回原来的条目，并在后台将请求重放到当前路由的上游集群，
+// 回答覆盖原来的条目并重新写入向量，完成后释放刷新锁。重放的请求直接发往上游集群，不经过网关中其他插件的处理
+package main
+
+import (
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alibaba/higress/plugins/wasm-go/extensions/ai-cache/config"
+	"github.com/alibaba/higress/plugins/wasm-go/extensions/ai-cache/protocol"
+	"github.com/alibaba/higress/plugins/wasm-go/extensions/ai-cache/sse"
+	"github.com/alibaba/higress/plugins/wasm-go/pkg/wrapper"
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm"
+	"github.com/tidwall/gjson"
+)
+
+const (
+	// redis key 中刷新锁的类型
+	refreshLockKeyKind = "refresh"
+	// 刷新锁的有效期，单位是毫秒，刷新失败时在这段时间后才会再次刷新
+	refreshLockTTL = 30000
+	// 条目中没有记录上游耗时时使用的默认值，单位是毫秒
+	defaultComputeMillis = 1000
+
+	cacheStatusHeader = "x-ai-cache-status"
+	cacheStatusHit    = "hit"
+	cacheStatusStale  = "stale"
+)
+
+func entryAge(entry *cacheEntry) int64 {
+	return time.Now().Unix() - entry.CreatedAt
+}
+
+// isEntryExpired 判断条目是否超过硬过期时间
+func isEntryExpired(config config.PluginConfig, entry *cacheEntry) bool {
+	return config.CacheTTL > 0 && entryAge(entry) >= int64(config.CacheTTL)
+}
+
+// isEntryStale 判断条目是否超过软过期时间
+func isEntryStale(config config.PluginConfig, entry *cacheEntry) bool {
+	return config.CacheSoftTTL > 0 && entryAge(entry) >= int64(config.CacheSoftTTL)
+}
+
+// shouldRefreshEntry 即 XFetch：age - delta * beta * ln(rand) >= softTTL，其中 delta 为上游生成回答的耗时
+func shouldRefreshEntry(config config.PluginConfig, entry *cacheEntry) bool {
+	if config.CacheSoftTTL <= 0 {
+		return false
+	}
+	computeMillis := entry.ComputeMillis
+	if computeMillis <= 0 {
+		computeMillis = defaultComputeMillis
+	}
+	delta := float64(computeMillis) / 1000
+	age := float64(entryAge(entry))
+	return age-delta*config.RefreshBeta*math.Log(1-rand.Float64()) >= float64(config.CacheSoftTTL)
+}
+
+// parseLiveCacheEntry 解析条目，超过硬过期时间的条目按不存在处理
+func parseLiveCacheEntry(config config.PluginConfig, fields map[string]string) *cacheEntry {
+	entry := parseCacheEntry(fields)
+	if entry != nil && isEntryExpired(config, entry) {
+		incrementCounter(metricExpiredEntries, 1)
+		return nil
+	}
+	return entry
+}
+
+// serveCacheEntry 返回命中的条目，精确匹配命中并且需要刷新时，获得刷新锁的请求同样返回原来的条目，由后台重放该请求刷新条目
+// 语义相似命中的条目属于另一个问题，当前请求的回答不能覆盖它，因此只返回不刷新
+func serveCacheEntry(key, redisKey string, entry *cacheEntry, exact bool, stream bool, ctx wrapper.HttpContext, config config.PluginConfig, log wrapper.Log) {
+	if !exact || !shouldRefreshEntry(config, entry) {
+		handleCacheHit(redisKey, entry, stream, ctx, config, log)
+		return
+	}
+	lockKey := buildRedisKey(config, refreshLockKeyKind, key)
+	token := strconv.FormatInt(time.Now().UnixNano(), 36)
+	err := config.GetCacheStore().SetNX(lockKey, token, refreshLockTTL, func(acquired bool, err error) {
+		if err == nil && acquired {
+			log.Infof("refresh cache entry in the background, key:%s, age:%d", redisKey, entryAge(entry))
+			incrementCounter(metricEntryRefreshes, 1)
+			enqueueRefresh(key, redisKey, lockKey, ctx, config, log)
+		}
+		handleCacheHit(redisKey, entry, stream, ctx, config, log)
+	})
+	if err != nil {
+		handleCacheHit(redisKey, entry, stream, ctx, config, log)
+	}
+}
+
+// refreshJob 记录重放请求所需的全部信息，请求本身在返回旧条目后就结束了
+type refreshJob struct {
+	key         string
+	redisKey    string
+	lockKey     string
+	config      config.PluginConfig
+	log         wrapper.Log
+	protocol    protocol.Protocol
+	accumulator *protocol.StreamAccumulator
+	cluster     refreshCluster
+	method      string
+	path        string
+	headers     [][2]string
+	body        []byte
+	// 除回答外的条目字段在请求阶段就已经确定
+	entry *cacheEntry
+}
+
+// refreshCluster 是当前路由的上游集群，集群名称在请求阶段读取，tick 中没有可以读取的请求属性
+type refreshCluster struct {
+	name string
+	host string
+}
+
+func (c refreshCluster) ClusterName() string {
+	return c.name
+}
+
+func (c refreshCluster) HostName() string {
+	return c.host
+}
+
+// 当前 VM 中等待发起的刷新，proxy-wasm 中每个 VM 是单线程的，不需要加锁
+var pendingRefreshes []*refreshJob
+
+// 后台刷新的检查间隔，单位是毫秒
+const refreshDispatchInterval = 100
+
+func registerRefresh(config config.PluginConfig, log wrapper.Log) {
+	if config.CacheSoftTTL <= 0 {
+		return
+	}
+	wrapper.RegisteTickFunc(refreshDispatchInterval, dispatchRefreshes)
+}
+
+// enqueueRefresh 记录当前请求，由 tick 在插件的根上下文中重放。请求返回旧条目后 HTTP 上下文随即销毁，
+// 在 HTTP 上下文中发起的调用会随之被取消，因此不能直接在这里发起
+func enqueueRefresh(key, redisKey, lockKey string, ctx wrapper.HttpContext, config config.PluginConfig, log wrapper.Log) {
+	body, _ := ctx.GetContext(RequestBodyContextKey).([]byte)
+	clusterName, err := proxywasm.GetProperty([]string{"cluster_name"})
+	if body == nil || err != nil || len(clusterName) == 0 {
+		log.Warnf("the upstream cluster of the request is unknown, skip refreshing, key:%s", redisKey)
+		config.GetCacheStore().Delete(lockKey, nil)
+		return
+	}
+	requestHeaders, _ := proxywasm.GetHttpRequestHeaders()
+	headers := make([][2]string, 0, len(requestHeaders))
+	for _, header := range requestHeaders {
+		name := strings.ToLower(header[0])
+		// pseudo headers are rebuilt by the http client, and the answer must be readable without decompressing
+		if strings.HasPrefix(name, ":") || name == "content-length" || name == "accept-encoding" {
+			continue
+		}
+		headers = append(headers, header)
+	}
+	job := &refreshJob{
+		key:      key,
+		redisKey: redisKey,
+		lockKey:  lockKey,
+		config:   config,
+		log:      log,
+		protocol: getProtocol(ctx, config),
+		cluster:  refreshCluster{name: string(clusterName), host: ctx.Host()},
+		method:   ctx.Method(),
+		path:     ctx.Path(),
+		headers:  headers,
+		body:     body,
+		entry: &cacheEntry{
+			VectorID:      vectorID(key),
+			SchemaVersion: CacheEntrySchemaVersion,
+		},
+	}
+	job.accumulator, _ = ctx.GetContext(StreamAccumulatorContextKey).(*protocol.StreamAccumulator)
+	job.entry.Query, _ = ctx.GetContext(QueryTextContextKey).(string)
+	job.entry.Model, _ = ctx.GetContext(ModelContextKey).(string)
+	job.entry.ParamsDigest, _ = ctx.GetContext(ParamsDigestContextKey).(string)
+	job.entry.Tags, _ = ctx.GetContext(TagsContextKey).([]string)
+	pendingRefreshes = append(pendingRefreshes, job)
+}
+
+func dispatchRefreshes() {
+	jobs := pendingRefreshes
+	pendingRefreshes = nil
+	for _, job := range jobs {
+		job.run()
+	}
+}
+
+// run 将请求重放到上游，回答写入成功后释放刷新锁并重新写入向量以延长向量的过期时间
+// 刷新失败时保留刷新锁，在锁过期之前不会再次刷新；重放的超时时间与锁的有效期相同，锁不会在刷新完成之前过期
+func (j *refreshJob) run() {
+	start := time.Now()
+	client := wrapper.NewClusterClient(j.cluster)
+	err := client.Call(j.method, j.path, j.headers, j.body, func(statusCode int, responseHeaders http.Header, responseBody []byte) {
+		if statusCode != http.StatusOK {
+			j.log.Warnf("refresh cache entry failed, key:%s, status:%d", j.redisKey, statusCode)
+			return
+		}
+		answer := j.parseAnswer(responseHeaders.Get("content-type"), responseBody)
+		if answer == nil || (answer.HasCalls() && !j.config.CacheToolCalls) {
+			j.log.Warnf("refresh cache entry failed, no answer to cache, key:%s", j.redisKey)
+			return
+		}
+		entry := *j.entry
+		entry.Answer = answer
+		entry.CreatedAt = time.Now().Unix()
+		entry.ComputeMillis = time.Since(start).Milliseconds()
+		if j.config.CacheFullResponse {
+			entry.Response = string(responseBody)
+		}
+		writeCacheEntry(j.config, j.redisKey, &entry, j.log, func(err error) {
+			if err != nil {
+				return
+			}
+			j.config.GetCacheStore().Delete(j.lockKey, nil)
+			j.refreshVector()
+		})
+	}, refreshLockTTL)
+	if err != nil {
+		j.log.Warnf("refresh cache entry failed, key:%s, err:%v", j.redisKey, err)
+	}
+}
+
+// parseAnswer 与响应阶段相同，SSE 响应按事件累积回答，其余响应整体解析
+func (j *refreshJob) parseAnswer(contentType string, body []byte) *protocol.Answer {
+	if !strings.Contains(contentType, "text/event-stream") {
+		return j.protocol.ParseResponse(body)
+	}
+	accumulator := j.accumulator
+	if accumulator == nil {
+		accumulator = &protocol.StreamAccumulator{}
+	}
+	for _, event := range sse.NewDecoder().Feed(body) {
+		if !event.IsDone() && !gjson.Valid(event.Data) {
+			continue
+		}
+		j.protocol.ProcessStreamEvent(event, accumulator)
+	}
+	if !accumulator.Completed() {
+		return nil
+	}
+	answer := accumulator.Answer()
+	if answer.Content == "" && !answer.HasCalls() {
+		return nil
+	}
+	return answer
+}
+
+// refreshVector 重新向量化查询并写入向量，向量的过期时间从现在起重新计算，与刷新后的回答一致
+func (j *refreshJob) refreshVector() {
+	if j.config.GetEmbeddingProvider() == nil || j.config.GetVectorProvider() == nil {
+		return
+	}
+	queryText, _ := splitKeySuffix(j.key)
+	err := fetchQueryEmbedding(j.config, j.log, queryText, func(text_embedding []float64, err error) {
+		if err != nil {
+			j.log.Warnf("refresh vector failed, key:%s, err:%v", j.redisKey, err)
+			return
+		}
+		uploadQueryEmbedding(j.config, j.log, j.key, text_embedding, nil)
+	})
+	if err != nil {
+		j.log.Warnf("refresh vector failed, key:%s, err:%v", j.redisKey, err)
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alibaba/higress/plugins/wasm-go/extensions/ai-cache/config"
+)
+
+func TestEntryExpiration(t *testing.T) {
+	c := config.PluginConfig{CacheTTL: 100, CacheSoftTTL: 60}
+	tests := []struct {
+		name        string
+		age         int64
+		wantStale   bool
+		wantExpired bool
+	}{
+		{name: "fresh", age: 10},
+		{name: "stale", age: 60, wantStale: true},
+		{name: "expired", age: 100, wantStale: true, wantExpired: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry := &cacheEntry{CreatedAt: time.Now().Unix() - tt.age}
+			if got := isEntryStale(c, entry); got != tt.wantStale {
+				t.Fatalf("isEntryStale() = %v, want %v", got, tt.wantStale)
+			}
+			if got := isEntryExpired(c, entry); got != tt.wantExpired {
+				t.Fatalf("isEntryExpired() = %v, want %v", got, tt.wantExpired)
+			}
+		})
+	}
+
+	entry := &cacheEntry{CreatedAt: time.Now().Unix() - 1000}
+	if isEntryStale(config.PluginConfig{}, entry) || isEntryExpired(config.PluginConfig{}, entry) {
+		t.Fatal("entries never expire without cacheTTL and cacheSoftTTL")
+	}
+}
+
+func TestShouldRefreshEntry(t *testing.T) {
+	tests := []struct {
+		name   string
+		config config.PluginConfig
+		age    int64
+		want   bool
+	}{
+		{name: "soft ttl disabled", config: config.PluginConfig{RefreshBeta: 1}, age: 1000},
+		// beta 为0时不会提前刷新，只在超过软过期时间后刷新
+		{name: "before soft ttl", config: config.PluginConfig{CacheSoftTTL: 60}, age: 59},
+		{name: "after soft ttl", config: config.PluginConfig{CacheSoftTTL: 60}, age: 60, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry := &cacheEntry{CreatedAt: time.Now().Unix() - tt.age, ComputeMillis: 1000}
+			if got := shouldRefreshEntry(tt.config, entry); got != tt.want {
+				t.Fatalf("shouldRefreshEntry() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// 上游生成回答越慢越早刷新：耗时远大于剩余的软过期时间时几乎总是提前刷新
+func TestShouldRefreshEntryEarly(t *testing.T) {
+	c := config.PluginConfig{CacheSoftTTL: 60, RefreshBeta: 1}
+	slow := &cacheEntry{CreatedAt: time.Now().Unix() - 50, ComputeMillis: 1000000}
+	fresh := &cacheEntry{CreatedAt: time.Now().Unix(), ComputeMillis: 1}
+	var slowRefreshes, freshRefreshes int
+	for i := 0; i < 1000; i++ {
+		if shouldRefreshEntry(c, slow) {
+			slowRefreshes++
+		}
+		if shouldRefreshEntry(c, fresh) {
+			freshRefreshes++
+		}
+	}
+	if slowRefreshes < 900 || freshRefreshes > 0 {
+		t.Fatalf("refreshes of the slow entry = %d, of the fresh entry = %d", slowRefreshes, freshRefreshes)
+	}
+}
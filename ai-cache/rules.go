@@ -0,0 +1,39 @@
+// 这个文件中实现按请求覆盖缓存配置的规则
+// 规则在请求 Body 阶段匹配一次，覆盖后的配置保存在上下文中，响应阶段使用同一份配置，保证查询和写入使用相同的过期时间和 key 的范围
+package main
+
+import (
+	"github.com/alibaba/higress/plugins/wasm-go/extensions/ai-cache/config"
+	"github.com/alibaba/higress/plugins/wasm-go/pkg/wrapper"
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm"
+	"github.com/tidwall/gjson"
+)
+
+const consumerHeader = "x-mse-consumer"
+
+// applyCacheRules 匹配规则并将覆盖后的配置保存在上下文中，没有配置规则时直接返回全局配置
+func applyCacheRules(ctx wrapper.HttpContext, c config.PluginConfig, body gjson.Result) config.PluginConfig {
+	if len(c.Rules) == 0 {
+		return c
+	}
+	attrs := config.RequestAttributes{
+		Host:  ctx.Host(),
+		Path:  ctx.Path(),
+		Model: requestModel(ctx.Path(), body),
+	}
+	if routeName, err := proxywasm.GetProperty([]string{"route_name"}); err == nil {
+		attrs.Route = string(routeName)
+	}
+	attrs.Consumer, _ = proxywasm.GetHttpRequestHeader(consumerHeader)
+	requestConfig := c.ForRequest(attrs)
+	ctx.SetContext(RequestConfigContextKey, requestConfig)
+	return requestConfig
+}
+
+// getRequestConfig 返回请求阶段匹配规则后的配置
+func getRequestConfig(ctx wrapper.HttpContext, c config.PluginConfig) config.PluginConfig {
+	if requestConfig, ok := ctx.GetContext(RequestConfigContextKey).(config.PluginConfig); ok {
+		return requestConfig
+	}
+	return c
+}
@@ -0,0 +1,283 @@
+// 这个文件中实现相同问题的并发未命中请求的合并（single flight）
+// 精确匹配未命中时，第一个请求通过 SetNX 获得一个短期的锁后按正常的未命中流程处理，其余相同 key 的请求暂停并加入等待队列
+// 等待的请求由 proxy-wasm tick 定期检查回答是否已经写入，写入后直接从缓存返回；持有锁的请求命中缓存（通常是语义命中）时，
+// 回答不会写入当前的 key，锁的值被替换为命中的回答的 key，等待的请求从这个 key 读取回答；
+// 持有锁的请求失败（锁被释放或过期但回答仍未写入）或者等待超时后，等待的请求直接转发到上游
+package main
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alibaba/higress/plugins/wasm-go/extensions/ai-cache/config"
+	"github.com/alibaba/higress/plugins/wasm-go/pkg/wrapper"
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm"
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm/types"
+)
+
+const (
+	// redis key 中锁的类型
+	singleFlightLockKeyKind = "lock"
+	// 锁的值以此为前缀时表示持有锁的请求已经命中缓存，前缀之后是命中的回答的 redis key
+	singleFlightHitPrefix = "hit:"
+)
+
+type singleFlightWaiter struct {
+	key      string
+	ctx      wrapper.HttpContext
+	config   config.PluginConfig
+	log      wrapper.Log
+	stream   bool
+	deadline time.Time
+	// 请求的 x-request-id，切换上下文后用于确认当前生效的是该请求，没有 x-request-id 的请求不会等待
+	requestID string
+	// 上一次检查尚未返回时不发起新的检查
+	polling bool
+	// 已经返回、转发到上游或者下游已经关闭的请求
+	done bool
+}
+
+// 当前 VM 中等待的请求，proxy-wasm 中每个 VM 是单线程的，不需要加锁
+var singleFlightWaiters []*singleFlightWaiter
+
+func singleFlightLockKey(config config.PluginConfig, key string) string {
+	return buildRedisKey(config, singleFlightLockKeyKind, key)
+}
+
+func registerSingleFlight(config config.PluginConfig, log wrapper.Log) {
+	if !config.SingleFlightConfig.Enabled {
+		return
+	}
+	wrapper.RegisteTickFunc(int64(config.SingleFlightConfig.PollInterval), pollSingleFlightWaiters)
+}
+
+// coalesceCacheMiss 在精确匹配未命中时尝试获取锁，获取失败的请求加入等待队列，其余情况按正常的未命中流程处理
+func coalesceCacheMiss(key string, lookupErr error, ctx wrapper.HttpContext, config config.PluginConfig, log wrapper.Log, queryString string, stream bool) {
+	if !config.SingleFlightConfig.Enabled || lookupErr != nil {
+		handleCacheMiss(key, lookupErr, ctx, config, log, queryString, stream)
+		return
+	}
+	lockKey := singleFlightLockKey(config, key)
+	token := strconv.FormatInt(time.Now().UnixNano(), 36)
+	err := config.GetCacheStore().SetNX(lockKey, token, config.SingleFlightConfig.LockTTL, func(acquired bool, err error) {
+		if err != nil {
+			log.Warnf("acquire single flight lock failed, key:%s, err:%v", lockKey, err)
+			handleCacheMiss(key, nil, ctx, config, log, queryString, stream)
+			return
+		}
+		if acquired {
+			incrementCounter(metricSingleFlightLocks, 1)
+			ctx.SetContext(SingleFlightLockContextKey, lockKey)
+			handleCacheMiss(key, nil, ctx, config, log, queryString, stream)
+			return
+		}
+		requestID, _ := proxywasm.GetHttpRequestHeader("x-request-id")
+		if _, ok := ctx.GetContext(ContextIDContextKey).(uint32); !ok || requestID == "" {
+			// without x-request-id the request can not be verified after switching contexts, so it does not wait
+			log.Infof("the same query is in flight, but the request can not wait for it, key:%s", answerRedisKey(config, key))
+			handleCacheMiss(key, nil, ctx, config, log, queryString, stream)
+			return
+		}
+		log.Infof("the same query is in flight, wait for its answer, key:%s", answerRedisKey(config, key))
+		incrementCounter(metricSingleFlightWaiters, 1)
+		waiter := &singleFlightWaiter{
+			key:       key,
+			ctx:       ctx,
+			config:    config,
+			log:       log,
+			stream:    stream,
+			deadline:  time.Now().Add(time.Duration(config.SingleFlightConfig.WaitTimeout) * time.Millisecond),
+			requestID: requestID,
+		}
+		ctx.SetContext(SingleFlightWaiterContextKey, waiter)
+		singleFlightWaiters = append(singleFlightWaiters, waiter)
+	})
+	if err != nil {
+		log.Warnf("acquire single flight lock failed, key:%s, err:%v", lockKey, err)
+		handleCacheMiss(key, nil, ctx, config, log, queryString, stream)
+	}
+}
+
+// abandonSingleFlightWait 在请求结束时调用，下游提前关闭的等待请求不再被检查，tick 中也不会再操作已经销毁的 HTTP 上下文
+func abandonSingleFlightWait(ctx wrapper.HttpContext) {
+	if waiter, ok := ctx.GetContext(SingleFlightWaiterContextKey).(*singleFlightWaiter); ok {
+		waiter.done = true
+	}
+}
+
+// releaseSingleFlightLock 在持有锁的请求结束时释放锁，回答写入成功后再释放，等待的请求不会在回答写入之前看到锁被释放
+func releaseSingleFlightLock(ctx wrapper.HttpContext, config config.PluginConfig) {
+	detachSingleFlightLock(ctx, config)()
+}
+
+// detachSingleFlightLock 将锁从请求上下文中取出，返回释放锁的函数。回答异步写入时由写入的回调释放锁，
+// 请求结束时 onHttpStreamDone 不会在回答写入之前释放锁
+func detachSingleFlightLock(ctx wrapper.HttpContext, config config.PluginConfig) func() {
+	lockKey, ok := ctx.GetContext(SingleFlightLockContextKey).(string)
+	if !ok {
+		return func() {}
+	}
+	ctx.SetContext(SingleFlightLockContextKey, nil)
+	return func() {
+		config.GetCacheStore().Delete(lockKey, nil)
+	}
+}
+
+// shareSingleFlightHit 在持有锁的请求命中缓存时调用，锁的值替换为命中的回答的 redis key，过期时间与锁相同，
+// 等待的请求不会因为回答没有写入自己的 key 而全部转发到上游
+func shareSingleFlightHit(ctx wrapper.HttpContext, config config.PluginConfig, redisKey string) {
+	lockKey, ok := ctx.GetContext(SingleFlightLockContextKey).(string)
+	if !ok {
+		return
+	}
+	ctx.SetContext(SingleFlightLockContextKey, nil)
+	ttl := (config.SingleFlightConfig.LockTTL + 999) / 1000
+	config.GetCacheStore().Set(lockKey, singleFlightHitPrefix+redisKey, ttl, nil)
+}
+
+// sharedHitKey 解析锁的值，持有锁的请求已经命中缓存时返回命中的回答的 redis key
+func sharedHitKey(lockValue string) (string, bool) {
+	if !strings.HasPrefix(lockValue, singleFlightHitPrefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(lockValue, singleFlightHitPrefix), true
+}
+
+func pollSingleFlightWaiters() {
+	now := time.Now()
+	pending := singleFlightWaiters[:0]
+	for _, waiter := range singleFlightWaiters {
+		if waiter.done {
+			continue
+		}
+		if now.After(waiter.deadline) {
+			waiter.log.Warnf("wait for the in-flight query timed out, key:%s", answerRedisKey(waiter.config, waiter.key))
+			incrementCounter(metricSingleFlightTimeouts, 1)
+			waiter.fallback()
+			continue
+		}
+		pending = append(pending, waiter)
+		if !waiter.polling {
+			waiter.poll()
+		}
+	}
+	singleFlightWaiters = pending
+}
+
+// poll 检查回答是否已经写入，未写入时再检查锁是否还存在，以及持有锁的请求是否已经命中缓存
+func (w *singleFlightWaiter) poll() {
+	w.polling = true
+	store := w.config.GetCacheStore()
+	redisKey := answerRedisKey(w.config, w.key)
+	err := store.GetFields(redisKey, func(fields map[string]string, err error) {
+		if w.done {
+			return
+		}
+		if err == nil && w.serve(redisKey, fields) {
+			return
+		}
+		err = store.Get(singleFlightLockKey(w.config, w.key), func(value string, found bool, err error) {
+			if hitKey, ok := sharedHitKey(value); !w.done && err == nil && found && ok {
+				w.pollSharedHit(hitKey)
+				return
+			}
+			w.polling = false
+			if w.done || err != nil || found {
+				return
+			}
+			w.log.Warnf("the in-flight query finished without an answer, key:%s", redisKey)
+			incrementCounter(metricSingleFlightFallbacks, 1)
+			w.fallback()
+		})
+		if err != nil {
+			w.polling = false
+		}
+	})
+	if err != nil {
+		w.polling = false
+	}
+}
+
+// pollSharedHit 读取持有锁的请求命中的回答，回答已经不存在时转发到上游
+func (w *singleFlightWaiter) pollSharedHit(redisKey string) {
+	err := w.config.GetCacheStore().GetFields(redisKey, func(fields map[string]string, err error) {
+		w.polling = false
+		if w.done || err == nil && w.serve(redisKey, fields) {
+			return
+		}
+		w.log.Warnf("the answer hit by the in-flight query is gone, key:%s", redisKey)
+		incrementCounter(metricSingleFlightFallbacks, 1)
+		w.fallback()
+	})
+	if err != nil {
+		w.polling = false
+	}
+}
+
+// serve 在回答存在时直接从缓存返回，回答不存在或者已经过期时返回 false
+func (w *singleFlightWaiter) serve(redisKey string, fields map[string]string) bool {
+	entry := parseLiveCacheEntry(w.config, fields)
+	if entry == nil {
+		return false
+	}
+	w.done = true
+	if l1Cache := w.config.GetL1Cache(); l1Cache != nil {
+		l1Cache.Set(redisKey, fields)
+	}
+	if w.activate() {
+		incrementCounter(metricSingleFlightServed, 1)
+		handleCacheHit(redisKey, entry, w.stream, w.ctx, w.config, w.log)
+	}
+	return true
+}
+
+// fallback 将等待的请求转发到上游，上游的回答仍会写入缓存
+func (w *singleFlightWaiter) fallback() {
+	w.done = true
+	if !w.activate() {
+		return
+	}
+	resumeRequest(w.ctx)
+}
+
+// activate 将当前生效的 HTTP 上下文切换为等待的请求，tick 以及 tick 中发起的调用的回调都在插件的根上下文中执行，
+// 需要先通过 SetEffectiveContext 显式切换到请求的 context ID 才能继续或直接响应被暂停的请求。
+// 切换后再读取请求头确认生效的是同一个请求：请求已经结束或者 x-request-id 不一致时返回 false，调用方不能再操作这个请求
+func (w *singleFlightWaiter) activate() bool {
+	contextID, _ := w.ctx.GetContext(ContextIDContextKey).(uint32)
+	if err := proxywasm.SetEffectiveContext(contextID); err != nil {
+		w.log.Warnf("the waiting request is gone, key:%s, err:%v", answerRedisKey(w.config, w.key), err)
+		return false
+	}
+	requestID, err := proxywasm.GetHttpRequestHeader("x-request-id")
+	if err != nil || requestID != w.requestID {
+		w.log.Warnf("the waiting request is not the effective one, key:%s", answerRedisKey(w.config, w.key))
+		return false
+	}
+	return true
+}
+
+// singleFlightVMContext 在创建 HTTP 上下文时将 context ID 记录到请求上下文中，wrapper 没有暴露 context ID，
+// 等待的请求在 tick 中恢复时需要通过它切换上下文
+type singleFlightVMContext struct {
+	types.VMContext
+}
+
+func (v singleFlightVMContext) NewPluginContext(contextID uint32) types.PluginContext {
+	return singleFlightPluginContext{v.VMContext.NewPluginContext(contextID)}
+}
+
+type singleFlightPluginContext struct {
+	types.PluginContext
+}
+
+func (p singleFlightPluginContext) NewHttpContext(contextID uint32) types.HttpContext {
+	httpCtx := p.PluginContext.NewHttpContext(contextID)
+	if ctx, ok := httpCtx.(interface {
+		SetContext(key string, value interface{})
+	}); ok {
+		ctx.SetContext(ContextIDContextKey, contextID)
+	}
+	return httpCtx
+}
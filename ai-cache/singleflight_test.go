@@ -0,0 +1,77 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/alibaba/higress/plugins/wasm-go/extensions/ai-cache/config"
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm/types"
+)
+
+func TestSingleFlightLockKey(t *testing.T) {
+	c := config.PluginConfig{CacheKeyPrefix: DefaultCacheKeyPrefix}
+	lockKey := singleFlightLockKey(c, "hello")
+	if lockKey != singleFlightLockKey(c, "hello") {
+		t.Fatal("singleFlightLockKey() is not stable")
+	}
+	if lockKey == answerRedisKey(c, "hello") {
+		t.Fatalf("singleFlightLockKey() = answerRedisKey() = %s", lockKey)
+	}
+	if lockKey == singleFlightLockKey(c, "world") {
+		t.Fatalf("singleFlightLockKey() of different keys = %s", lockKey)
+	}
+}
+
+// 已经结束的等待请求被移出队列，上一次检查尚未返回的请求保留在队列中且不会发起新的检查
+func TestPollSingleFlightWaiters(t *testing.T) {
+	defer func() { singleFlightWaiters = nil }()
+	deadline := time.Now().Add(time.Minute)
+	pending := &singleFlightWaiter{key: "pending", deadline: deadline, polling: true}
+	singleFlightWaiters = []*singleFlightWaiter{
+		{key: "served", deadline: deadline, done: true},
+		pending,
+		{key: "fallen back", deadline: time.Now().Add(-time.Minute), done: true},
+	}
+	pollSingleFlightWaiters()
+	if len(singleFlightWaiters) != 1 || singleFlightWaiters[0] != pending {
+		t.Fatalf("singleFlightWaiters = %+v, want only the pending waiter", singleFlightWaiters)
+	}
+	if !pending.polling || pending.done {
+		t.Fatalf("pending waiter = %+v, want it still polling", pending)
+	}
+}
+
+func TestSharedHitKey(t *testing.T) {
+	if _, ok := sharedHitKey(strconv.FormatInt(time.Now().UnixNano(), 36)); ok {
+		t.Fatal("sharedHitKey() of a lock token = true, want false")
+	}
+	hitKey, ok := sharedHitKey(singleFlightHitPrefix + "higressAiCache:answer:hello")
+	if !ok || hitKey != "higressAiCache:answer:hello" {
+		t.Fatalf("sharedHitKey() = %s, %v, want the hit redis key", hitKey, ok)
+	}
+}
+
+type testPluginContext struct {
+	types.DefaultPluginContext
+}
+
+func (*testPluginContext) NewHttpContext(uint32) types.HttpContext {
+	return &testHttpContext{values: map[string]interface{}{}}
+}
+
+type testHttpContext struct {
+	types.DefaultHttpContext
+	values map[string]interface{}
+}
+
+func (c *testHttpContext) SetContext(key string, value interface{}) {
+	c.values[key] = value
+}
+
+func TestSingleFlightPluginContextRecordsContextID(t *testing.T) {
+	httpCtx := singleFlightPluginContext{&testPluginContext{}}.NewHttpContext(42).(*testHttpContext)
+	if contextID, _ := httpCtx.values[ContextIDContextKey].(uint32); contextID != 42 {
+		t.Fatalf("context ID = %v, want 42", httpCtx.values[ContextIDContextKey])
+	}
+}
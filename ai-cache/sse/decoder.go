@@ -0,0 +1,183 @@
+// 这个文件中实现增量的 SSE（Server-Sent Events）解析器，解析规则遵循 WHATWG HTML 标准中的 event stream 一节
+// https://html.spec.whatwg.org/multipage/server-sent-events.html#event-stream-interpretation
+// 响应体以任意大小的 chunk 到达，解析器会缓存不完整的行，保证在 chunk 边界上切开的事件也能被正确解析
+package sse
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+)
+
+const (
+	// DefaultEventType 未指定 event 字段时事件的类型
+	DefaultEventType = "message"
+	// DoneData OpenAI 兼容协议中表示流结束的 data
+	DoneData = "[DONE]"
+)
+
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// Event 定义一个完整的 SSE 事件
+type Event struct {
+	// ID 为分发该事件时的 last event ID
+	ID string
+	// Type 为 event 字段的值，未指定时为 DefaultEventType
+	Type string
+	// Data 为所有 data 字段按换行拼接后的结果
+	Data string
+	// Retry 为 retry 字段的值，单位为毫秒，未指定时为 -1
+	Retry int
+}
+
+// IsDone 判断该事件是否为 OpenAI 兼容协议中的结束标记 [DONE]
+func (e Event) IsDone() bool {
+	return strings.TrimSpace(e.Data) == DoneData
+}
+
+// Decoder 定义增量 SSE 解析器，不是并发安全的，每个 HTTP 请求需要使用独立的实例
+type Decoder struct {
+	// 尚未遇到行结束符的不完整行
+	pending []byte
+	// 上一个 chunk 以 \r 结尾，需要跳过下一个 chunk 开头的 \n
+	skipLF     bool
+	bomChecked bool
+
+	data        []byte
+	hasData     bool
+	eventType   string
+	lastEventID string
+	retry       int
+}
+
+func NewDecoder() *Decoder {
+	return &Decoder{retry: -1}
+}
+
+// Feed 输入一段响应体，返回这段数据中所有已完整结束的事件
+func (d *Decoder) Feed(chunk []byte) []Event {
+	var events []Event
+	if !d.bomChecked {
+		d.pending = append(d.pending, chunk...)
+		if len(d.pending) < len(utf8BOM) && bytes.HasPrefix(utf8BOM, d.pending) {
+			return nil
+		}
+		d.bomChecked = true
+		chunk = bytes.TrimPrefix(d.pending, utf8BOM)
+		d.pending = nil
+	}
+	if d.skipLF && len(chunk) > 0 {
+		if chunk[0] == '\n' {
+			chunk = chunk[1:]
+		}
+		d.skipLF = false
+	}
+	for len(chunk) > 0 {
+		i := bytes.IndexAny(chunk, "\r\n")
+		if i < 0 {
+			d.pending = append(d.pending, chunk...)
+			break
+		}
+		line := chunk[:i]
+		if len(d.pending) > 0 {
+			line = append(d.pending, line...)
+			d.pending = nil
+		}
+		if chunk[i] == '\r' {
+			if i+1 == len(chunk) {
+				d.skipLF = true
+			} else if chunk[i+1] == '\n' {
+				i++
+			}
+		}
+		chunk = chunk[i+1:]
+		if event, ok := d.processLine(line); ok {
+			events = append(events, event)
+		}
+	}
+	return events
+}
+
+// LastEventID 返回最近一次收到的 id 字段
+func (d *Decoder) LastEventID() string {
+	return d.lastEventID
+}
+
+// Reset 丢弃所有未完成的数据，按照标准，流结束时未以空行结尾的事件不会被分发
+func (d *Decoder) Reset() {
+	d.pending = nil
+	d.skipLF = false
+	d.resetEvent()
+}
+
+func (d *Decoder) processLine(line []byte) (Event, bool) {
+	if len(line) == 0 {
+		return d.dispatch()
+	}
+	if line[0] == ':' {
+		// comment
+		return Event{}, false
+	}
+	var field, value string
+	if i := bytes.IndexByte(line, ':'); i >= 0 {
+		field = string(line[:i])
+		value = string(bytes.TrimPrefix(line[i+1:], []byte{' '}))
+	} else {
+		field = string(line)
+	}
+	switch field {
+	case "event":
+		d.eventType = value
+	case "data":
+		d.data = append(d.data, value...)
+		d.data = append(d.data, '\n')
+		d.hasData = true
+	case "id":
+		if !strings.ContainsRune(value, 0) {
+			d.lastEventID = value
+		}
+	case "retry":
+		if isASCIIDigits(value) {
+			if retry, err := strconv.Atoi(value); err == nil {
+				d.retry = retry
+			}
+		}
+	}
+	return Event{}, false
+}
+
+func (d *Decoder) dispatch() (Event, bool) {
+	if !d.hasData {
+		d.resetEvent()
+		return Event{}, false
+	}
+	event := Event{
+		ID:    d.lastEventID,
+		Type:  d.eventType,
+		Data:  string(d.data[:len(d.data)-1]),
+		Retry: d.retry,
+	}
+	if event.Type == "" {
+		event.Type = DefaultEventType
+	}
+	d.resetEvent()
+	return event, true
+}
+
+func (d *Decoder) resetEvent() {
+	d.data = d.data[:0]
+	d.hasData = false
+	d.eventType = ""
+}
+
+func isASCIIDigits(value string) bool {
+	if value == "" {
+		return false
+	}
+	for i := 0; i < len(value); i++ {
+		if value[i] < '0' || value[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
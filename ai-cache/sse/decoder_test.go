@@ -0,0 +1,146 @@
+package sse
+
+import (
+	"reflect"
+	"testing"
+)
+
+func feedAll(chunks []string) []Event {
+	decoder := NewDecoder()
+	var events []Event
+	for _, chunk := range chunks {
+		events = append(events, decoder.Feed([]byte(chunk))...)
+	}
+	return events
+}
+
+func dataEvent(data string) Event {
+	return Event{Type: DefaultEventType, Data: data, Retry: -1}
+}
+
+func TestDecoderFeed(t *testing.T) {
+	tests := []struct {
+		name   string
+		chunks []string
+		want   []Event
+	}{
+		{
+			name:   "lf",
+			chunks: []string{"data: a\n\ndata: b\n\n"},
+			want:   []Event{dataEvent("a"), dataEvent("b")},
+		},
+		{
+			name:   "crlf",
+			chunks: []string{"data: a\r\n\r\n"},
+			want:   []Event{dataEvent("a")},
+		},
+		{
+			name:   "cr",
+			chunks: []string{"data: a\r\rdata: b\r\r"},
+			want:   []Event{dataEvent("a"), dataEvent("b")},
+		},
+		{
+			name:   "crlf split between chunks",
+			chunks: []string{"data: a\r", "\n\r", "\ndata: b\r\n\r\n"},
+			want:   []Event{dataEvent("a"), dataEvent("b")},
+		},
+		{
+			name:   "line split between chunks",
+			chunks: []string{"da", "ta: {\"a\"", ":1}\n", "\n"},
+			want:   []Event{dataEvent(`{"a":1}`)},
+		},
+		{
+			name:   "bom",
+			chunks: []string{"\xEF\xBB\xBFdata: a\n\n"},
+			want:   []Event{dataEvent("a")},
+		},
+		{
+			name:   "bom split between chunks",
+			chunks: []string{"\xEF", "\xBB", "\xBFdata: a\n\n"},
+			want:   []Event{dataEvent("a")},
+		},
+		{
+			name:   "bom only at the start of the stream",
+			chunks: []string{"data: a\n\n", "\xEF\xBB\xBFdata: b\n\n"},
+			want:   []Event{dataEvent("a")},
+		},
+		{
+			name:   "multi-line data",
+			chunks: []string{"data: a\ndata: b\n\n"},
+			want:   []Event{dataEvent("a\nb")},
+		},
+		{
+			name:   "event type id and retry",
+			chunks: []string{"event: message_delta\nid: 7\nretry: 3000\ndata: a\n\n"},
+			want:   []Event{{ID: "7", Type: "message_delta", Data: "a", Retry: 3000}},
+		},
+		{
+			name:   "comments and events without data are ignored",
+			chunks: []string{": keep-alive\n\nevent: ping\n\ndata: a\n\n"},
+			want:   []Event{dataEvent("a")},
+		},
+		{
+			name:   "event type does not leak into the next event",
+			chunks: []string{"event: ping\n\ndata: a\n\n"},
+			want:   []Event{dataEvent("a")},
+		},
+		{
+			name:   "field without colon",
+			chunks: []string{"data\n\n"},
+			want:   []Event{dataEvent("")},
+		},
+		{
+			name:   "invalid retry is ignored",
+			chunks: []string{"retry: 1s\ndata: a\n\n"},
+			want:   []Event{dataEvent("a")},
+		},
+		{
+			name:   "unterminated event is not dispatched",
+			chunks: []string{"data: a\n\ndata: b\n"},
+			want:   []Event{dataEvent("a")},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := feedAll(tt.chunks); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("Feed() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+// 按字节拆分的 chunk 与一次性输入得到相同的事件
+func TestDecoderFeedByteByByte(t *testing.T) {
+	stream := "\xEF\xBB\xBFevent: a\r\ndata: 1\r\n\r\ndata: 2\rdata: 3\r\r: comment\ndata: [DONE]\n\n"
+	var chunks []string
+	for i := 0; i < len(stream); i++ {
+		chunks = append(chunks, stream[i:i+1])
+	}
+	want := feedAll([]string{stream})
+	if len(want) != 3 {
+		t.Fatalf("Feed() returned %d events, want 3", len(want))
+	}
+	if got := feedAll(chunks); !reflect.DeepEqual(got, want) {
+		t.Fatalf("Feed() = %#v, want %#v", got, want)
+	}
+	if !want[2].IsDone() {
+		t.Fatalf("IsDone() = false for %q", want[2].Data)
+	}
+}
+
+func TestDecoderLastEventIDAndReset(t *testing.T) {
+	decoder := NewDecoder()
+	decoder.Feed([]byte("id: 1\ndata: a\n\nid: 2\ndata: b"))
+	if id := decoder.LastEventID(); id != "2" {
+		t.Fatalf("LastEventID() = %q, want %q", id, "2")
+	}
+	decoder.Reset()
+	if events := decoder.Feed([]byte("\n\n")); len(events) != 0 {
+		t.Fatalf("Feed() after Reset() = %#v, want no events", events)
+	}
+	events := decoder.Feed([]byte("data: c\n\n"))
+	want := []Event{{ID: "2", Type: DefaultEventType, Data: "c", Retry: -1}}
+	if !reflect.DeepEqual(events, want) {
+		t.Fatalf("Feed() = %#v, want %#v", events, want)
+	}
+}
@@ -0,0 +1,82 @@
+// 这个文件中实现多租户隔离
+// 开启后每个请求的租户标识从请求头、消费者、JWT claim 或 API Key 中提取，标识的摘要作为 redis key 范围的一部分，
+// 向量中保存的范围也包含租户摘要，语义检索只会命中同一个租户写入的向量。共享的命名空间中的条目和向量不区分租户
+// 无法提取租户标识的请求既不查询也不写入缓存
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+
+	"github.com/alibaba/higress/plugins/wasm-go/extensions/ai-cache/config"
+	"github.com/alibaba/higress/plugins/wasm-go/pkg/wrapper"
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm"
+	"github.com/tidwall/gjson"
+)
+
+// 租户摘要的长度，租户标识本身不会出现在 redis key 和向量中
+const tenantDigestLength = 16
+
+// applyTenant 返回属于当前请求租户的配置，无法提取租户标识时返回 false
+func applyTenant(ctx wrapper.HttpContext, c config.PluginConfig, log wrapper.Log) (config.PluginConfig, bool) {
+	if !c.TenantConfig.Enabled() || c.TenantConfig.IsShared(c.CacheKeyNamespace) {
+		return c, true
+	}
+	tenant := extractTenant(c.TenantConfig)
+	if tenant == "" {
+		log.Warnf("tenant not found in request, skip cache, from:%s", c.TenantConfig.From)
+		incrementCounter(metricTenantMissing, 1)
+		return c, false
+	}
+	tenantConfig := c.WithTenant(tenantDigest(tenant))
+	ctx.SetContext(RequestConfigContextKey, tenantConfig)
+	return tenantConfig, true
+}
+
+// tenantDigest 返回租户标识的摘要，管理接口按同样的方式由租户标识得到范围
+func tenantDigest(tenant string) string {
+	hash := sha256.Sum256([]byte(tenant))
+	return hex.EncodeToString(hash[:])[:tenantDigestLength]
+}
+
+func extractTenant(c config.TenantConfig) string {
+	switch c.From {
+	case config.TenantFromHeader:
+		value, _ := proxywasm.GetHttpRequestHeader(c.Header)
+		return value
+	case config.TenantFromConsumer:
+		value, _ := proxywasm.GetHttpRequestHeader(consumerHeader)
+		return value
+	case config.TenantFromJWTClaim:
+		value, _ := proxywasm.GetHttpRequestHeader(c.Header)
+		return jwtClaim(bearerToken(value), c.Claim)
+	case config.TenantFromAPIKey:
+		// the key itself is only used as input of the digest
+		value, _ := proxywasm.GetHttpRequestHeader(c.Header)
+		return bearerToken(value)
+	}
+	return ""
+}
+
+func bearerToken(value string) string {
+	value = strings.TrimSpace(value)
+	if len(value) > 7 && strings.EqualFold(value[:7], "bearer ") {
+		return strings.TrimSpace(value[7:])
+	}
+	return value
+}
+
+// jwtClaim 读取 JWT payload 中的 claim，不校验签名，需要在网关的认证插件（例如 jwt-auth）校验 JWT 之后使用
+func jwtClaim(token, claim string) string {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return ""
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil || !gjson.ValidBytes(payload) {
+		return ""
+	}
+	return gjson.GetBytes(payload, claim).String()
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/alibaba/higress/plugins/wasm-go/extensions/ai-cache/config"
+)
+
+func TestBearerToken(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{value: "Bearer sk-123", want: "sk-123"},
+		{value: "bearer  sk-123 ", want: "sk-123"},
+		{value: "sk-123", want: "sk-123"},
+		{value: "Bearer", want: "Bearer"},
+		{value: "", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			if got := bearerToken(tt.value); got != tt.want {
+				t.Fatalf("bearerToken(%q) = %q, want %q", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestJwtClaim(t *testing.T) {
+	token := func(payload string) string {
+		return "eyJhbGciOiJIUzI1NiJ9." + base64.RawURLEncoding.EncodeToString([]byte(payload)) + ".signature"
+	}
+	tests := []struct {
+		name  string
+		token string
+		claim string
+		want  string
+	}{
+		{name: "claim", token: token(`{"tenant_id":"acme"}`), claim: "tenant_id", want: "acme"},
+		{name: "nested claim", token: token(`{"org":{"id":42}}`), claim: "org.id", want: "42"},
+		{name: "padded payload", token: strings.Replace(token(`{"tenant_id":"a"}`), ".signature", "", 1) + "==.signature", claim: "tenant_id", want: "a"},
+		{name: "missing claim", token: token(`{"sub":"alice"}`), claim: "tenant_id"},
+		{name: "not a jwt", token: "sk-123", claim: "tenant_id"},
+		{name: "invalid payload", token: "a.!!!.c", claim: "tenant_id"},
+		{name: "payload is not json", token: token(`tenant_id`), claim: "tenant_id"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := jwtClaim(tt.token, tt.claim); got != tt.want {
+				t.Fatalf("jwtClaim() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// 不同租户的 redis key 不同，共享的命名空间中不区分租户
+func TestTenantScope(t *testing.T) {
+	c := config.PluginConfig{
+		CacheKeyPrefix:    DefaultCacheKeyPrefix,
+		CacheKeyNamespace: "default",
+		TenantConfig:      config.TenantConfig{From: config.TenantFromConsumer, SharedNamespaces: []string{"public"}},
+	}
+	alice, bob := c.WithTenant("alice"), c.WithTenant("bob")
+	if answerRedisKey(alice, "hello") == answerRedisKey(bob, "hello") {
+		t.Fatal("tenants share the same redis key")
+	}
+	if scope := cacheKeyScope(alice); !strings.HasSuffix(scope, ":"+tenantScopePrefix+"alice") {
+		t.Fatalf("cacheKeyScope() = %s, want the tenant at the end", scope)
+	}
+
+	c.CacheKeyNamespace = "public"
+	alice, bob = c.WithTenant("alice"), c.WithTenant("bob")
+	if alice.Tenant() != "" || answerRedisKey(alice, "hello") != answerRedisKey(bob, "hello") {
+		t.Fatal("tenants do not share the redis key in a shared namespace")
+	}
+}
@@ -1,6 +1,21 @@
 package TextEmbeddingProvider
 
-import "errors"
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/alibaba/higress/plugins/wasm-go/pkg/wrapper"
+)
+
+const (
+	dashScopeDomain       = "dashscope.aliyuncs.com"
+	dashScopePort         = 443
+	dashScopeTimeout      = 10000
+	dashScopeEmbeddingURL = "/api/v1/services/embeddings/text-embedding/text-embedding"
+	dashScopeDefaultModel = "text-embedding-v1"
+)
 
 type dashScopeProviderInitializer struct {
 }
@@ -16,6 +31,14 @@ func (d *dashScopeProviderInitializer) ValidateConfig(config ProviderConfig) err
 }
 
 func (d *dashScopeProviderInitializer) CreateProvider(config ProviderConfig) (Provider, error) {
+	if config.Model == "" {
+		config.Model = dashScopeDefaultModel
+	}
+	config.DashScopeClient = wrapper.NewClusterClient(wrapper.DnsCluster{
+		ServiceName: config.DashScopeServiceName,
+		Port:        dashScopePort,
+		Domain:      dashScopeDomain,
+	})
 	return &DSProvider{config: config}, nil
 }
 
@@ -27,7 +50,56 @@ func (d *DSProvider) GetProviderType() string {
 	return providerTypeDashScope
 }
 
-// TODO: Implement the GetEmbedding method
+type dashScopeEmbeddingRequest struct {
+	Model string `json:"model"`
+	Input struct {
+		Texts []string `json:"texts"`
+	} `json:"input"`
+	Parameters struct {
+		TextType string `json:"text_type"`
+	} `json:"parameters"`
+}
+
+type dashScopeEmbeddingResponse struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Output  struct {
+		Embeddings []struct {
+			TextIndex int       `json:"text_index"`
+			Embedding []float64 `json:"embedding"`
+		} `json:"embeddings"`
+	} `json:"output"`
+}
+
+// GetEmbedding 调用 DashScope 的文本向量化接口，向量化的文本作为检索的查询（text_type 为 query）
 func (d *DSProvider) GetEmbedding(text string, callback func([]float64, error)) error {
-	return nil
+	var req dashScopeEmbeddingRequest
+	req.Model = d.config.Model
+	req.Input.Texts = []string{text}
+	req.Parameters.TextType = "query"
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	headers := [][2]string{
+		{"Content-Type", "application/json"},
+		{"Authorization", "Bearer " + d.config.DashScopeKey},
+	}
+	return d.config.DashScopeClient.Post(dashScopeEmbeddingURL, headers, body,
+		func(statusCode int, responseHeaders http.Header, responseBody []byte) {
+			var resp dashScopeEmbeddingResponse
+			if err := json.Unmarshal(responseBody, &resp); err != nil && statusCode == http.StatusOK {
+				callback(nil, err)
+				return
+			}
+			if statusCode != http.StatusOK {
+				callback(nil, errors.New("dashscope responded with status "+strconv.Itoa(statusCode)+": "+resp.Code+" "+resp.Message))
+				return
+			}
+			if len(resp.Output.Embeddings) < 1 || len(resp.Output.Embeddings[0].Embedding) == 0 {
+				callback(nil, errors.New("dashscope responded with no embedding"))
+				return
+			}
+			callback(resp.Output.Embeddings[0].Embedding, nil)
+		}, dashScopeTimeout)
 }
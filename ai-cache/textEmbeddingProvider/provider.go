@@ -1,6 +1,9 @@
 package TextEmbeddingProvider
 
 import (
+	"errors"
+
+	"github.com/alibaba/higress/plugins/wasm-go/pkg/wrapper"
 	"github.com/tidwall/gjson"
 )
 
@@ -22,11 +25,17 @@ var (
 type ProviderConfig struct {
 	// @Title zh-CN 文本特征提取服务提供者类型
 	// @Description zh-CN 文本特征提取服务提供者类型，例如 DashScope
-	typ string `json:"TextEmbeddingProviderType"`
+	typ string
 	// @Title zh-CN DashScope 阿里云大模型服务名
 	// @Description zh-CN 调用阿里云的大模型服务
 	DashScopeServiceName string `require:"true" yaml:"DashScopeServiceName" jaon:"DashScopeServiceName"`
 	DashScopeKey         string `require:"true" yaml:"DashScopeKey" jaon:"DashScopeKey"`
+	// @Title zh-CN 文本向量化模型
+	// @Description zh-CN 为空时使用服务提供者的默认模型
+	Model string `require:"false" yaml:"model" json:"model"`
+	// @Title zh-CN DashScope Client
+	// @Description zh-CN 调用阿里云大模型服务的 Client
+	DashScopeClient wrapper.HttpClient `yaml:"-" json:"-"`
 }
 
 type Provider interface {
@@ -39,5 +48,22 @@ type GetEmbedding interface {
 
 func (c *ProviderConfig) FromJson(json gjson.Result) {
 	c.typ = json.Get("TextEmbeddingProviderType").String()
+	c.DashScopeServiceName = json.Get("DashScopeServiceName").String()
+	c.DashScopeKey = json.Get("DashScopeKey").String()
+	c.Model = json.Get("model").String()
+}
+
+func (c *ProviderConfig) GetProviderType() string {
+	return c.typ
+}
 
+func CreateProvider(pc ProviderConfig) (Provider, error) {
+	initializer, has := providerInitializers[pc.typ]
+	if !has {
+		return nil, errors.New("unknown provider type: " + pc.typ)
+	}
+	if err := initializer.ValidateConfig(pc); err != nil {
+		return nil, err
+	}
+	return initializer.CreateProvider(pc)
 }
@@ -1,6 +1,19 @@
 package vectorStorePrvider
 
-import "errors"
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/alibaba/higress/plugins/wasm-go/pkg/wrapper"
+)
+
+const (
+	dashVectorPort    = 443
+	dashVectorTimeout = 10000
+)
 
 type dashVectorProviderInitializer struct {
 }
@@ -22,6 +35,11 @@ func (d *dashVectorProviderInitializer) ValidateConfig(config ProviderConfig) er
 }
 
 func (d *dashVectorProviderInitializer) CreateProvider(config ProviderConfig) (Provider, error) {
+	config.DashVectorClient = wrapper.NewClusterClient(wrapper.DnsCluster{
+		ServiceName: config.DashVectorServiceName,
+		Port:        dashVectorPort,
+		Domain:      config.DashVectorAuthApiEnd,
+	})
 	return &DvProvider{config: config}, nil
 }
 
@@ -33,7 +51,122 @@ func (d *DvProvider) GetProviderType() string {
 	return providerTypeDashVector
 }
 
-// TODO: Implement the QueryEmbedding method
+// DashVector 不支持过期，collection 中需要定义 int 类型的 expires_at 字段
+func (d *DvProvider) NativeTTL() bool {
+	return false
+}
+
+func (d *DvProvider) InsertEmbedding(docs []Document, ttl int, callback func(err error)) error {
+	var expireAt int64
+	if ttl > 0 {
+		expireAt = time.Now().Unix() + int64(ttl)
+	}
+	for i := range docs {
+		if docs[i].Fields == nil {
+			docs[i].Fields = make(map[string]interface{})
+		}
+		docs[i].Fields[FieldExpiresAt] = expireAt
+	}
+	body, err := json.Marshal(map[string][]Document{"docs": docs})
+	if err != nil {
+		return err
+	}
+	// upsert, the same ID is written again when an entry is refreshed or overwritten
+	return d.config.DashVectorClient.Put(d.collectionPath("/docs"), d.headers(), body,
+		func(statusCode int, responseHeaders http.Header, responseBody []byte) {
+			if callback != nil {
+				callback(dashVectorError(statusCode, responseBody))
+			}
+		}, dashVectorTimeout)
+}
+
 func (d *DvProvider) QueryEmbedding(req QueryRequest, callback func(resp QueryResponse, err error)) error {
+	unexpired := FieldExpiresAt + " = 0 or " + FieldExpiresAt + " > " + strconv.FormatInt(time.Now().Unix(), 10)
+	return d.query(req, "("+unexpired+")", callback)
+}
+
+// CleanExpiredEmbedding 只按过期时间过滤，不需要查询向量
+func (d *DvProvider) CleanExpiredEmbedding(batchSize int, callback func(deleted int, err error)) error {
+	expired := FieldExpiresAt + " > 0 and " + FieldExpiresAt + " <= " + strconv.FormatInt(time.Now().Unix(), 10)
+	req := QueryRequest{TopK: batchSize, OutputFields: []string{FieldExpiresAt}}
+	return d.query(req, expired, func(resp QueryResponse, err error) {
+		if err != nil || len(resp.Output) == 0 {
+			callback(0, err)
+			return
+		}
+		ids := make([]string, 0, len(resp.Output))
+		for _, result := range resp.Output {
+			ids = append(ids, result.ID)
+		}
+		err = d.DeleteEmbedding(ids, func(err error) {
+			if err != nil {
+				callback(0, err)
+			} else {
+				callback(len(ids), nil)
+			}
+		})
+		if err != nil {
+			callback(0, err)
+		}
+	})
+}
+
+func (d *DvProvider) query(req QueryRequest, filter string, callback func(resp QueryResponse, err error)) error {
+	if req.Filter == "" {
+		req.Filter = filter
+	} else {
+		req.Filter = "(" + req.Filter + ") and " + filter
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	return d.config.DashVectorClient.Post(d.collectionPath("/query"), d.headers(), body,
+		func(statusCode int, responseHeaders http.Header, responseBody []byte) {
+			var resp QueryResponse
+			err := dashVectorError(statusCode, responseBody)
+			if err == nil {
+				err = json.Unmarshal(responseBody, &resp)
+			}
+			callback(resp, err)
+		}, dashVectorTimeout)
+}
+
+func (d *DvProvider) DeleteEmbedding(ids []string, callback func(err error)) error {
+	body, err := json.Marshal(map[string][]string{"ids": ids})
+	if err != nil {
+		return err
+	}
+	return d.config.DashVectorClient.Delete(d.collectionPath("/docs"), d.headers(), body,
+		func(statusCode int, responseHeaders http.Header, responseBody []byte) {
+			if callback != nil {
+				callback(dashVectorError(statusCode, responseBody))
+			}
+		}, dashVectorTimeout)
+}
+
+func (d *DvProvider) collectionPath(suffix string) string {
+	return "/v1/collections/" + d.config.DashVectorCollection + suffix
+}
+
+func (d *DvProvider) headers() [][2]string {
+	return [][2]string{
+		{"Content-Type", "application/json"},
+		{"dashvector-auth-token", d.config.DashVectorKey},
+	}
+}
+
+// DashVector 在 HTTP 状态码为200时仍可能通过 code 字段返回错误
+func dashVectorError(statusCode int, responseBody []byte) error {
+	if statusCode != http.StatusOK {
+		return errors.New("dashvector responded with status " + strconv.Itoa(statusCode) + ": " + string(responseBody))
+	}
+	var resp struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	}
+	if err := json.Unmarshal(responseBody, &resp); err == nil && resp.Code != 0 {
+		return errors.New("dashvector responded with code " + strconv.Itoa(resp.Code) + ": " + resp.Message)
+	}
 	return nil
 }
@@ -0,0 +1,120 @@
+package vectorStorePrvider
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alibaba/higress/plugins/wasm-go/pkg/wrapper"
+	"github.com/tidwall/gjson"
+)
+
+// fakeClient 记录最后一次请求，并同步返回给定的响应
+type fakeClient struct {
+	wrapper.HttpClient
+	path       string
+	body       []byte
+	statusCode int
+	response   string
+}
+
+func (c *fakeClient) do(rawURL string, body []byte, cb wrapper.ResponseCallback) error {
+	c.path, c.body = rawURL, body
+	cb(c.statusCode, http.Header{}, []byte(c.response))
+	return nil
+}
+
+func (c *fakeClient) Post(rawURL string, headers [][2]string, body []byte, cb wrapper.ResponseCallback, timeoutMillisecond ...uint32) error {
+	return c.do(rawURL, body, cb)
+}
+
+func (c *fakeClient) Put(rawURL string, headers [][2]string, body []byte, cb wrapper.ResponseCallback, timeoutMillisecond ...uint32) error {
+	return c.do(rawURL, body, cb)
+}
+
+func (c *fakeClient) Delete(rawURL string, headers [][2]string, body []byte, cb wrapper.ResponseCallback, timeoutMillisecond ...uint32) error {
+	return c.do(rawURL, body, cb)
+}
+
+func newTestProvider(client *fakeClient) *DvProvider {
+	return &DvProvider{config: ProviderConfig{DashVectorCollection: "cache", DashVectorClient: client}}
+}
+
+func TestInsertEmbeddingExpiresAt(t *testing.T) {
+	client := &fakeClient{statusCode: http.StatusOK, response: `{"code":0}`}
+	provider := newTestProvider(client)
+	tests := []struct {
+		name string
+		ttl  int
+	}{
+		{name: "never expires", ttl: 0},
+		{name: "ttl", ttl: 60},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var callbackErr error
+			err := provider.InsertEmbedding([]Document{{ID: "id", Vector: []float64{1}}}, tt.ttl, func(err error) {
+				callbackErr = err
+			})
+			if err != nil || callbackErr != nil {
+				t.Fatalf("InsertEmbedding() = %v, callback %v", err, callbackErr)
+			}
+			if client.path != "/v1/collections/cache/docs" {
+				t.Fatalf("path = %s", client.path)
+			}
+			expiresAt := gjson.GetBytes(client.body, "docs.0.fields."+FieldExpiresAt)
+			if !expiresAt.Exists() {
+				t.Fatalf("body = %s, want the %s field", client.body, FieldExpiresAt)
+			}
+			if tt.ttl == 0 && expiresAt.Int() != 0 {
+				t.Fatalf("%s = %d, want 0", FieldExpiresAt, expiresAt.Int())
+			}
+			if tt.ttl > 0 && expiresAt.Int() <= time.Now().Unix() {
+				t.Fatalf("%s = %d, want a future time", FieldExpiresAt, expiresAt.Int())
+			}
+		})
+	}
+}
+
+func TestQueryEmbeddingFilter(t *testing.T) {
+	client := &fakeClient{statusCode: http.StatusOK, response: `{"code":0,"output":[{"id":"id","score":0.05,"fields":{"query":"hello"}}]}`}
+	provider := newTestProvider(client)
+	var resp QueryResponse
+	err := provider.QueryEmbedding(QueryRequest{Vector: []float64{1}, TopK: 1, Filter: "scope = 'a'"}, func(r QueryResponse, err error) {
+		if err != nil {
+			t.Fatalf("QueryEmbedding() callback error = %v", err)
+		}
+		resp = r
+	})
+	if err != nil {
+		t.Fatalf("QueryEmbedding() = %v", err)
+	}
+	filter := gjson.GetBytes(client.body, "filter").String()
+	if !strings.HasPrefix(filter, "(scope = 'a') and (") || !strings.Contains(filter, FieldExpiresAt+" = 0") {
+		t.Fatalf("filter = %q, want the request filter and the unexpired filter", filter)
+	}
+	if len(resp.Output) != 1 || resp.Output[0].Fields["query"] != "hello" {
+		t.Fatalf("QueryEmbedding() output = %+v", resp.Output)
+	}
+}
+
+func TestDashVectorError(t *testing.T) {
+	tests := []struct {
+		name       string
+		statusCode int
+		body       string
+		wantErr    bool
+	}{
+		{name: "ok", statusCode: http.StatusOK, body: `{"code":0}`},
+		{name: "status", statusCode: http.StatusInternalServerError, body: `oops`, wantErr: true},
+		{name: "code", statusCode: http.StatusOK, body: `{"code":-2021,"message":"collection not exist"}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := dashVectorError(tt.statusCode, []byte(tt.body)); (err != nil) != tt.wantErr {
+				t.Fatalf("dashVectorError() = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -1,12 +1,17 @@
 package vectorStorePrvider
 
 import (
+	"errors"
+
 	"github.com/alibaba/higress/plugins/wasm-go/pkg/wrapper"
 	"github.com/tidwall/gjson"
 )
 
 const (
 	providerTypeDashVector = "dashvector"
+
+	// FieldExpiresAt 是不支持原生 TTL 的向量数据库中保存过期时间的字段，值为 unix 时间戳，单位是秒，为0时表示永不过期
+	FieldExpiresAt = "expires_at"
 )
 
 type providerInitializer interface {
@@ -23,7 +28,7 @@ var (
 type ProviderConfig struct {
 	// @Title zh-CN 向量存储服务提供者类型
 	// @Description zh-CN 向量存储服务提供者类型，例如 DashVector、Milvus
-	typ string `json:"vectorStoreProviderType"`
+	typ string
 	// @Title zh-CN DashVector 阿里云向量搜索引擎
 	// @Description zh-CN 调用阿里云的向量搜索引擎
 	DashVectorServiceName string `require:"true" yaml:"DashVectorServiceName" jaon:"DashVectorServiceName"`
@@ -43,15 +48,51 @@ type ProviderConfig struct {
 
 type Provider interface {
 	GetProviderType() string
+	// NativeTTL 返回向量数据库是否原生支持过期（例如 Milvus 的 collection TTL、Redis 的 key 过期）
+	// 不支持时服务提供者在写入时保存 FieldExpiresAt 字段，查询时过滤已过期的向量，并实现 CleanExpiredEmbedding 在后台清理
+	NativeTTL() bool
+}
+
+// InsertEmbedding 写入向量，ID 已存在时覆盖原来的向量，ttl 的单位是秒，为0时永不过期
+type InsertEmbedding interface {
+	InsertEmbedding(docs []Document, ttl int, callback func(err error)) error
 }
 
 type QueryEmbedding interface {
 	QueryEmbedding(req QueryRequest, callback func(resp QueryResponse, err error)) error
 }
 
+// DeleteEmbedding 按 ID 删除向量，用于清理回答已经不存在的向量
+type DeleteEmbedding interface {
+	DeleteEmbedding(ids []string, callback func(err error)) error
+}
+
+// CleanExpiredEmbedding 删除最多 batchSize 个已过期的向量，回调中返回删除的数量
+type CleanExpiredEmbedding interface {
+	CleanExpiredEmbedding(batchSize int, callback func(deleted int, err error)) error
+}
+
 func (c *ProviderConfig) FromJson(json gjson.Result) {
 	c.typ = json.Get("vectorStoreProviderType").String()
+	c.DashVectorServiceName = json.Get("DashVectorServiceName").String()
+	c.DashVectorKey = json.Get("DashVectorKey").String()
+	c.DashVectorAuthApiEnd = json.Get("DashVectorEnd").String()
+	c.DashVectorCollection = json.Get("DashVectorCollection").String()
+}
+
+func (c *ProviderConfig) GetProviderType() string {
+	return c.typ
+}
 
+func CreateProvider(pc ProviderConfig) (Provider, error) {
+	initializer, has := providerInitializers[pc.typ]
+	if !has {
+		return nil, errors.New("unknown provider type: " + pc.typ)
+	}
+	if err := initializer.ValidateConfig(pc); err != nil {
+		return nil, err
+	}
+	return initializer.CreateProvider(pc)
 }
 
 // QueryResponse 定义查询响应的结构
@@ -64,9 +105,21 @@ type QueryResponse struct {
 
 // QueryRequest 定义查询请求的结构
 type QueryRequest struct {
-	Vector        []float64 `json:"vector"`
+	// Vector 为空时只按 Filter 过滤
+	Vector        []float64 `json:"vector,omitempty"`
 	TopK          int       `json:"topk"`
 	IncludeVector bool      `json:"include_vector"`
+	// OutputFields 为空时返回所有字段
+	OutputFields []string `json:"output_fields,omitempty"`
+	// Filter 是 SQL where 子句形式的过滤条件，服务提供者会追加过滤已过期向量的条件
+	Filter string `json:"filter,omitempty"`
+}
+
+// Document 定义写入的向量
+type Document struct {
+	ID     string                 `json:"id,omitempty"`
+	Vector []float64              `json:"vector"`
+	Fields map[string]interface{} `json:"fields"`
 }
 
 // Result 定义查询结果的结构